@@ -0,0 +1,15 @@
+// kuma-net-cni is the chained CNI plugin installing the transparent proxy
+// (iptables rules or eBPF programs) inside network namespaces of pods,
+// so pods don't need privileged init containers. The plugin is configured
+// by the "kumaNet" field of the network configuration (see cni.NetConf)
+package main
+
+import (
+	"os"
+
+	"github.com/kumahq/kuma-net/cni"
+)
+
+func main() {
+	os.Exit(cni.Main(os.LookupEnv, os.Stdin, os.Stdout, os.Stderr))
+}
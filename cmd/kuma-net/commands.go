@@ -0,0 +1,393 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/kumahq/kuma-net/firewalld"
+	"github.com/kumahq/kuma-net/iptables/builder"
+	transparent_proxy "github.com/kumahq/kuma-net/transparent-proxy"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+func familyName(ipv6 bool) string {
+	if ipv6 {
+		return "ipv6"
+	}
+
+	return "ipv4"
+}
+
+// getDnsServers returns DNS servers, traffic to which should be captured,
+// when only the traffic to selected servers is captured
+func getDnsServers(cfg config.Config) ([]string, []string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	if !cfg.ShouldRedirectDNS() || cfg.ShouldCaptureAllDNS() {
+		return nil, nil, nil
+	}
+
+	return builder.GetDnsServersFromConfig(cfg)
+}
+
+// buildRules returns generated iptables rules (and ip6tables rules, when
+// IPv6 is enabled)
+func buildRules(cfg config.Config) (string, string, error) {
+	dnsIpv4, dnsIpv6, err := getDnsServers(cfg)
+	if err != nil {
+		return "", "", err
+	}
+
+	ipv4Rules, err := builder.BuildIPTables(cfg, dnsIpv4, false)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !cfg.IPv6 {
+		return ipv4Rules, "", nil
+	}
+
+	ipv6Rules, err := builder.BuildIPTables(cfg, dnsIpv6, true)
+	if err != nil {
+		return "", "", err
+	}
+
+	return ipv4Rules, ipv6Rules, nil
+}
+
+type installResult struct {
+	Backend transparent_proxy.Backend
+	Reasons []string
+	Output  string
+}
+
+func (r installResult) text() string {
+	return fmt.Sprintf("transparent proxy installed (backend: %s)", r.Backend)
+}
+
+// watchResolvConf blocks until the process is interrupted or terminated,
+// updating captured DNS servers when resolv.conf changes
+func watchResolvConf(cfg config.Config) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-signals:
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	_, _ = fmt.Fprintf(cfg.RuntimeStdout, "watching %s for changes of DNS servers\n",
+		config.MergeConfigWithDefaults(cfg).Redirect.DNS.ResolvConfigPath)
+
+	return builder.WatchResolvConf(cfg, stop)
+}
+
+func runInstall(cfg config.Config, _ options) (result, error) {
+	decision, err := transparent_proxy.SelectBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	watch := cfg.Redirect.DNS.WatchResolvConf && !cfg.DryRun
+	if watch {
+		if decision.Backend != transparent_proxy.BackendIptables {
+			return nil, fmt.Errorf("watching resolv.conf is supported only " +
+				"by the iptables backend")
+		}
+
+		if !cfg.ShouldRedirectDNS() || cfg.ShouldCaptureAllDNS() {
+			return nil, fmt.Errorf("watching resolv.conf requires DNS " +
+				"redirection without capturing all the DNS traffic")
+		}
+	}
+
+	output, err := transparent_proxy.Setup(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if watch {
+		if err := watchResolvConf(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return installResult{
+		Backend: decision.Backend,
+		Reasons: decision.Reasons,
+		Output:  output,
+	}, nil
+}
+
+type uninstallResult struct {
+	Output string
+}
+
+func (r uninstallResult) text() string {
+	return "transparent proxy uninstalled"
+}
+
+func runUninstall(cfg config.Config, _ options) (result, error) {
+	output, err := transparent_proxy.Uninstall(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return uninstallResult{Output: output}, nil
+}
+
+type statusResult struct {
+	Installed bool
+	Families  []builder.Status
+}
+
+func (r statusResult) text() string {
+	var lines []string
+
+	for _, status := range r.Families {
+		if !status.Installed {
+			lines = append(lines, fmt.Sprintf("%s: not installed", familyName(status.IPv6)))
+			continue
+		}
+
+		var chains []string
+		for _, chain := range status.Chains {
+			chains = append(chains, chain.Table+"/"+chain.Chain)
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: installed (chains: %s)",
+			familyName(status.IPv6), strings.Join(chains, ", ")))
+
+		for _, counter := range status.Counters {
+			lines = append(lines, fmt.Sprintf("  %s/%s %s: %d packets, %d bytes",
+				counter.Table, counter.Chain, counter.Purpose, counter.Packets, counter.Bytes))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (r statusResult) exitCode() int {
+	if !r.Installed {
+		return exitNotInstalled
+	}
+
+	return exitOK
+}
+
+func runStatus(cfg config.Config, _ options) (result, error) {
+	statuses, err := builder.GetStatus(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := statusResult{Families: statuses}
+	for _, status := range statuses {
+		res.Installed = res.Installed || status.Installed
+	}
+
+	return res, nil
+}
+
+type dryRunResult struct {
+	// IPSets are empty, when ipsets are disabled or there is nothing to exclude
+	IPSets    string
+	Rules     string
+	IPv6Rules string
+}
+
+// text returns inputs of ipset restore, iptables-restore
+// and ip6tables-restore, each preceded by the comment with the command name
+func (r dryRunResult) text() string {
+	var sections []string
+
+	for _, section := range []struct {
+		command string
+		content string
+	}{
+		{"ipset restore", r.IPSets},
+		{"iptables-restore", r.Rules},
+		{"ip6tables-restore", r.IPv6Rules},
+	} {
+		if section.content != "" {
+			sections = append(sections, fmt.Sprintf("# %s\n%s", section.command, section.content))
+		}
+	}
+
+	return strings.Join(sections, "\n")
+}
+
+// runDryRun prints rules which would be installed by the iptables backend
+// for both IP families, as eBPF backend doesn't support dry run
+func runDryRun(cfg config.Config, _ options) (result, error) {
+	ipSets, err := builder.BuildIPSets(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv4Rules, ipv6Rules, err := buildRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return dryRunResult{IPSets: ipSets, Rules: ipv4Rules, IPv6Rules: ipv6Rules}, nil
+}
+
+type explainRule struct {
+	IPv6    bool
+	Table   string
+	Chain   string
+	Purpose string
+	Rule    string
+}
+
+type explainResult struct {
+	Backend transparent_proxy.Backend
+	Reasons []string
+	// Rules are empty, when eBPF backend is selected
+	Rules []explainRule
+}
+
+func (r explainResult) text() string {
+	lines := []string{fmt.Sprintf("backend: %s", r.Backend)}
+
+	for _, reason := range r.Reasons {
+		lines = append(lines, fmt.Sprintf("  - %s", reason))
+	}
+
+	family := ""
+	for _, rule := range r.Rules {
+		if familyName(rule.IPv6) != family {
+			family = familyName(rule.IPv6)
+			lines = append(lines, "", fmt.Sprintf("%s rules:", family))
+		}
+
+		lines = append(lines, fmt.Sprintf("  %s/%s [%s] %s", rule.Table, rule.Chain, rule.Purpose, rule.Rule))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func runExplain(cfg config.Config, _ options) (result, error) {
+	decision, err := transparent_proxy.SelectBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := explainResult{Backend: decision.Backend, Reasons: decision.Reasons}
+
+	if decision.Backend != transparent_proxy.BackendIptables {
+		return res, nil
+	}
+
+	// purposes of the rules are read from their comments, and explaining
+	// rules different from the ones which would be installed would be
+	// misleading
+	if !cfg.Comment.Enabled {
+		return nil, fmt.Errorf("rules cannot be explained unless comments " +
+			"are enabled (--comments), as purposes of the rules are read from them")
+	}
+
+	ipv4Rules, ipv6Rules, err := buildRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, family := range []struct {
+		rules string
+		ipv6  bool
+	}{{ipv4Rules, false}, {ipv6Rules, true}} {
+		counters, err := builder.ParseRuleCounters(cfg, family.rules, family.ipv6)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, counter := range counters {
+			res.Rules = append(res.Rules, explainRule{
+				IPv6:    counter.IPv6,
+				Table:   counter.Table,
+				Chain:   counter.Chain,
+				Purpose: counter.Purpose,
+				Rule:    counter.Rule,
+			})
+		}
+	}
+
+	return res, nil
+}
+
+type firewalldOptions struct {
+	directFilePath string
+	dbus           bool
+	remove         bool
+}
+
+func registerFirewalldFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.firewalld.directFilePath, "firewalld-direct-file", "",
+		"path to the firewalld direct.xml file (/etc/firewalld/direct.xml by default)")
+	fs.BoolVar(&opts.firewalld.dbus, "firewalld-dbus", false,
+		"apply rules through the firewalld D-Bus interface instead of the direct.xml file")
+	fs.BoolVar(&opts.firewalld.remove, "firewalld-remove", false,
+		"remove previously stored rules instead of storing them")
+}
+
+type firewalldResult struct {
+	Direct string
+}
+
+// text is empty, as the direct configuration is already printed
+// by the translator
+func (r firewalldResult) text() string {
+	return ""
+}
+
+func runFirewalld(cfg config.Config, opts options) (result, error) {
+	ipv4Rules, ipv6Rules, err := buildRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	translator := firewalld.NewIptablesTranslator().
+		WithDryRun(cfg.DryRun).
+		WithOutput(cfg.RuntimeStdout).
+		// rules tagged by previous installations are removed even if comments
+		// are not enabled now
+		WithCommentPrefix(config.MergeConfigWithDefaults(cfg).Comment.Prefix)
+
+	if opts.firewalld.directFilePath != "" {
+		translator.WithDirectFilePath(opts.firewalld.directFilePath)
+	}
+
+	if opts.firewalld.dbus {
+		client, err := firewalld.NewDBusDirectClient()
+		if err != nil {
+			return nil, err
+		}
+
+		translator.WithDirectClient(client)
+	}
+
+	store := translator.StoreDualStackRules
+	if opts.firewalld.remove {
+		store = translator.RemoveDualStackRules
+	}
+
+	direct, err := store(ipv4Rules, ipv6Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return firewalldResult{Direct: direct}, nil
+}
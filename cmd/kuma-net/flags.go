@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// envPrefix prefixes names of environment variables, which are setting
+// flags (i.e. KUMA_NET_REDIRECT_OUTBOUND for --redirect-outbound)
+const envPrefix = "KUMA_NET_"
+
+type uint16Value struct {
+	value *uint16
+}
+
+func (v uint16Value) String() string {
+	if v.value == nil {
+		return "0"
+	}
+
+	return strconv.Itoa(int(*v.value))
+}
+
+func (v uint16Value) Set(value string) error {
+	parsed, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	*v.value = uint16(parsed)
+
+	return nil
+}
+
+// uint16ListValue is the comma separated list of numbers (i.e. ports)
+type uint16ListValue struct {
+	value *[]uint16
+}
+
+func (v uint16ListValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+
+	var values []string
+	for _, value := range *v.value {
+		values = append(values, strconv.Itoa(int(value)))
+	}
+
+	return strings.Join(values, ",")
+}
+
+func (v uint16ListValue) Set(value string) error {
+	var result []uint16
+
+	for _, field := range splitList(value) {
+		parsed, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return err
+		}
+
+		result = append(result, uint16(parsed))
+	}
+
+	*v.value = result
+
+	return nil
+}
+
+// stringListValue is the comma separated list of strings (i.e. CIDRs)
+type stringListValue struct {
+	value *[]string
+}
+
+func (v stringListValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+
+	return strings.Join(*v.value, ",")
+}
+
+func (v stringListValue) Set(value string) error {
+	*v.value = splitList(value)
+
+	return nil
+}
+
+// portRangeListValue is the comma separated list of port ranges (i.e.
+// 8080,30000-32767)
+type portRangeListValue struct {
+	value *[]config.PortRange
+}
+
+func (v portRangeListValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+
+	var values []string
+	for _, value := range *v.value {
+		values = append(values, value.String())
+	}
+
+	return strings.Join(values, ",")
+}
+
+func (v portRangeListValue) Set(value string) error {
+	var result []config.PortRange
+
+	for _, field := range splitList(value) {
+		portRange, err := config.ParsePortRange(field)
+		if err != nil {
+			return err
+		}
+
+		result = append(result, portRange)
+	}
+
+	*v.value = result
+
+	return nil
+}
+
+func splitList(value string) []string {
+	var result []string
+
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			result = append(result, field)
+		}
+	}
+
+	return result
+}
+
+func registerTrafficFlowFlags(fs *flag.FlagSet, flow *config.TrafficFlow, name string) {
+	fs.BoolVar(&flow.Enabled, "redirect-"+name, flow.Enabled,
+		fmt.Sprintf("redirect %s traffic to the proxy", name))
+	fs.Var(uint16Value{&flow.Port}, name+"-port",
+		fmt.Sprintf("port of the proxy listener for %s traffic", name))
+	fs.Var(uint16Value{&flow.PortIPv6}, name+"-port-ipv6",
+		fmt.Sprintf("port of the proxy listener for %s IPv6 traffic (the same as --%s-port by default)", name, name))
+	fs.StringVar(&flow.Chain.Name, name+"-chain", flow.Chain.Name,
+		fmt.Sprintf("name of the %s chain (without the prefix)", name))
+	fs.StringVar(&flow.RedirectChain.Name, name+"-redirect-chain", flow.RedirectChain.Name,
+		fmt.Sprintf("name of the %s redirect chain (without the prefix)", name))
+	fs.Var(uint16ListValue{&flow.ExcludePorts}, "exclude-"+name+"-ports",
+		fmt.Sprintf("comma separated list of %s ports excluded from the redirection", name))
+	fs.Var(uint16ListValue{&flow.IncludePorts}, "include-"+name+"-ports",
+		fmt.Sprintf("comma separated list of %s ports, which will be the only redirected ones", name))
+	fs.Var(portRangeListValue{&flow.ExcludePortRanges}, "exclude-"+name+"-port-ranges",
+		fmt.Sprintf("comma separated list of %s port ranges (i.e. 30000-32767) excluded from the redirection", name))
+	fs.Var(portRangeListValue{&flow.IncludePortRanges}, "include-"+name+"-port-ranges",
+		fmt.Sprintf("comma separated list of %s port ranges, which will be the only redirected ones", name))
+	fs.Var(stringListValue{&flow.ExcludeCIDRs}, "exclude-"+name+"-cidrs",
+		fmt.Sprintf("comma separated list of addresses or CIDRs excluded from the %s redirection", name))
+}
+
+// registerConfigFlags registers flags setting fields of provided configuration,
+// with their current values as defaults. Zero values are replaced by defaults
+// of the configuration when it's used (see config.MergeConfigWithDefaults)
+func registerConfigFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.StringVar(&cfg.Owner.UID, "uid", cfg.Owner.UID,
+		"uid of the user running the proxy, which traffic won't be redirected")
+
+	// .Redirect
+	redirect := &cfg.Redirect
+	fs.StringVar(&redirect.NamePrefix, "name-prefix", redirect.NamePrefix,
+		"prefix of the names of generated chains and ipsets")
+	registerTrafficFlowFlags(fs, &redirect.Inbound, "inbound")
+	registerTrafficFlowFlags(fs, &redirect.Outbound, "outbound")
+	fs.Var(stringListValue{&redirect.ExcludeInterfaces}, "exclude-interfaces",
+		"comma separated list of output interfaces (i.e. wg0 or wg+) excluded from the redirection")
+	fs.Var(stringListValue{&redirect.ExcludeInboundInterfaces}, "exclude-inbound-interfaces",
+		"comma separated list of input interfaces excluded from the redirection")
+	fs.BoolVar(&redirect.IPSets.Enabled, "ipsets", redirect.IPSets.Enabled,
+		"put excluded CIDRs and ports into ipsets")
+
+	// .Redirect.DNS
+	dns := &redirect.DNS
+	fs.BoolVar(&dns.Enabled, "redirect-dns", dns.Enabled,
+		"redirect DNS traffic to the DNS proxy")
+	fs.BoolVar(&dns.CaptureAll, "capture-all-dns", dns.CaptureAll,
+		"capture all the DNS traffic, instead of only the traffic to the DNS servers from resolv.conf")
+	fs.Var(uint16Value{&dns.Port}, "dns-port",
+		"port of the DNS proxy")
+	fs.Var(uint16Value{&dns.PortIPv6}, "dns-port-ipv6",
+		"port of the DNS proxy for IPv6 traffic (the same as --dns-port by default)")
+	fs.BoolVar(&dns.ConntrackZoneSplit, "dns-conntrack-zone-split", dns.ConntrackZoneSplit,
+		"split DNS traffic into separate conntrack zones")
+	fs.StringVar(&dns.ResolvConfigPath, "resolv-config-path", dns.ResolvConfigPath,
+		"path to the resolv.conf file")
+	fs.BoolVar(&dns.WatchResolvConf, "watch-resolv-conf", dns.WatchResolvConf,
+		"keep running after the installation and update captured DNS servers when resolv.conf changes")
+	fs.Var(stringListValue{&dns.Servers}, "dns-servers",
+		"comma separated list of additionally captured DNS servers")
+	fs.BoolVar(&dns.ExcludeLinkLocalServers, "exclude-link-local-dns-servers", dns.ExcludeLinkLocalServers,
+		"don't capture DNS servers with link-local addresses")
+	fs.BoolVar(&dns.ExcludeStubResolvers, "exclude-stub-resolvers", dns.ExcludeStubResolvers,
+		"don't capture DNS servers with loopback addresses")
+	fs.StringVar(&dns.SystemdResolvedConfigPath, "systemd-resolved-config-path", dns.SystemdResolvedConfigPath,
+		"path to the resolv.conf file maintained by systemd-resolved")
+	fs.StringVar(&dns.SystemdResolvedUser, "systemd-resolved-user", dns.SystemdResolvedUser,
+		"user systemd-resolved is running as (i.e. systemd-resolve), which DNS traffic won't be captured")
+	fs.Var(uint16Value{&dns.UpstreamConntrackZone}, "dns-upstream-conntrack-zone",
+		"conntrack zone of the DNS traffic to the upstream servers")
+	fs.Var(uint16Value{&dns.ProxyConntrackZone}, "dns-proxy-conntrack-zone",
+		"conntrack zone of the DNS traffic to the DNS proxy")
+	fs.StringVar(&dns.RedirectChain.Name, "dns-redirect-chain", dns.RedirectChain.Name,
+		"name of the DNS redirect chain (without the prefix)")
+	fs.StringVar(&dns.ConntrackOutputChain.Name, "dns-conntrack-output-chain", dns.ConntrackOutputChain.Name,
+		"name of the DNS conntrack output chain (without the prefix)")
+	fs.StringVar(&dns.ConntrackPreroutingChain.Name, "dns-conntrack-prerouting-chain", dns.ConntrackPreroutingChain.Name,
+		"name of the DNS conntrack prerouting chain (without the prefix)")
+
+	// .Redirect.EgressLockdown
+	lockdown := &redirect.EgressLockdown
+	fs.BoolVar(&lockdown.Enabled, "egress-lockdown", lockdown.Enabled,
+		"block outbound traffic bypassing the proxy")
+	fs.StringVar((*string)(&lockdown.Target), "egress-lockdown-target", string(lockdown.Target),
+		"target of the blocked traffic (REJECT or DROP)")
+	fs.StringVar(&lockdown.Chain.Name, "egress-lockdown-chain", lockdown.Chain.Name,
+		"name of the egress lockdown chain (without the prefix)")
+
+	// .Ebpf
+	ebpf := &cfg.Ebpf
+	fs.BoolVar(&ebpf.Enabled, "ebpf", ebpf.Enabled,
+		"use eBPF programs instead of iptables")
+	fs.StringVar((*string)(&ebpf.Policy), "ebpf-policy", string(ebpf.Policy),
+		"eBPF policy (required, preferred or disabled)")
+	fs.StringVar(&ebpf.InstanceIP, "ebpf-instance-ip", ebpf.InstanceIP,
+		"IP address of the instance (required by eBPF programs)")
+	fs.StringVar(&ebpf.BPFFSPath, "ebpf-bpffs-path", ebpf.BPFFSPath,
+		"path to the BPF file system")
+	fs.StringVar(&ebpf.ProgramsSourcePath, "ebpf-programs-source-path", ebpf.ProgramsSourcePath,
+		"path to the compiled eBPF programs")
+
+	// .DropInvalidPackets and .Hardening
+	fs.BoolVar(&cfg.DropInvalidPackets, "drop-invalid-packets", cfg.DropInvalidPackets,
+		"drop packets in the INVALID conntrack state")
+	hardening := &cfg.Hardening
+	fs.BoolVar(&hardening.DropInvalid, "hardening-drop-invalid", hardening.DropInvalid,
+		"drop packets in the INVALID conntrack state")
+	fs.BoolVar(&hardening.DropInvalidTCPFlags, "hardening-drop-invalid-tcp-flags", hardening.DropInvalidTCPFlags,
+		"drop TCP packets with illegal combinations of flags")
+	fs.BoolVar(&hardening.MeshOnly, "hardening-mesh-only", hardening.MeshOnly,
+		"apply hardening rules only to TCP traffic in directions with enabled redirection")
+	fs.StringVar(&hardening.Chain.Name, "hardening-chain", hardening.Chain.Name,
+		"name of the hardening chain (without the prefix)")
+
+	// .Comment
+	fs.BoolVar(&cfg.Comment.Enabled, "comments", cfg.Comment.Enabled,
+		"tag generated rules with comments")
+	fs.StringVar(&cfg.Comment.Prefix, "comment-prefix", cfg.Comment.Prefix,
+		"prefix of comments of generated rules")
+
+	// .Trace
+	trace := &cfg.Trace
+	fs.BoolVar(&trace.Enabled, "trace", trace.Enabled,
+		"log packets before redirection decisions")
+	fs.StringVar((*string)(&trace.Target), "trace-target", string(trace.Target),
+		"target of the logging rules (LOG or NFLOG)")
+	fs.StringVar(&trace.Prefix, "trace-prefix", trace.Prefix,
+		"prefix of logged messages")
+	fs.Var(uint16Value{&trace.NflogGroup}, "trace-nflog-group",
+		"nfnetlink_log group of the NFLOG target")
+	fs.StringVar(&trace.Limit, "trace-limit", trace.Limit,
+		"maximum average rate of logged packets per rule (i.e. 10/second)")
+	fs.UintVar(&trace.LimitBurst, "trace-limit-burst", trace.LimitBurst,
+		"maximum initial number of logged packets per rule")
+
+	fs.BoolVar(&cfg.IPv6, "ipv6", cfg.IPv6,
+		"configure ip6tables as well")
+	fs.BoolVar(&cfg.Verbose, "verbose", cfg.Verbose,
+		"generate rules with long flag names")
+	fs.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun,
+		"only print instructions instead of executing them")
+}
+
+// envName returns the name of the environment variable setting the flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets flags from environment variables returned by lookup
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		if value, ok := lookup(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q of %s: %s", value, envName(f.Name), setErr)
+			}
+		}
+	})
+
+	return err
+}
+
+// findConfigFlag returns the value of the --config flag from provided
+// arguments, as the configuration file has to be loaded before other flags
+// are parsed
+func findConfigFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return ""
+}
+
+// loadConfigFile reads the configuration (fields of config.Config) from
+// provided JSON file
+func loadConfigFile(path string, cfg *config.Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %s", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %s", path, err)
+	}
+
+	return nil
+}
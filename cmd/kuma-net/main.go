@@ -0,0 +1,287 @@
+// kuma-net installs the transparent proxy (iptables rules or eBPF programs),
+// which redirects the traffic of applications to the proxy. Every field
+// of the configuration (see config.Config) can be set by the flag, the JSON
+// config file (--config) or the environment variable (KUMA_NET_<FLAG>, i.e.
+// KUMA_NET_REDIRECT_OUTBOUND=true), with flags taking precedence over
+// environment variables, and environment variables over the config file
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// Exit codes of the kuma-net
+const (
+	exitOK = 0
+	// exitError is returned when the command failed
+	exitError = 1
+	// exitUsage is returned for unknown commands, invalid flags or values
+	// of environment variables, and unreadable config files
+	exitUsage = 2
+	// exitNotInstalled is returned by the status command, when the transparent
+	// proxy is not installed
+	exitNotInstalled = 3
+)
+
+type outputFormat string
+
+const (
+	outputText outputFormat = "text"
+	outputJSON outputFormat = "json"
+)
+
+type outputValue struct {
+	value *outputFormat
+}
+
+func (v outputValue) String() string {
+	if v.value == nil {
+		return string(outputText)
+	}
+
+	return string(*v.value)
+}
+
+func (v outputValue) Set(value string) error {
+	switch outputFormat(value) {
+	case outputText, outputJSON:
+		*v.value = outputFormat(value)
+	default:
+		return fmt.Errorf("unsupported output format %q (supported formats: %s, %s)",
+			value, outputText, outputJSON)
+	}
+
+	return nil
+}
+
+// options are flags which are not part of the configuration
+type options struct {
+	output    outputFormat
+	firewalld firewalldOptions
+}
+
+// result is the result of the command, which is printed as JSON or text
+type result interface {
+	text() string
+}
+
+// exitCoder is implemented by results, which exit codes depend on them
+type exitCoder interface {
+	exitCode() int
+}
+
+type command struct {
+	name        string
+	description string
+	run         func(cfg config.Config, opts options) (result, error)
+	// flags registers flags of the command, which are not part of
+	// the configuration
+	flags func(fs *flag.FlagSet, opts *options)
+}
+
+var commands = []command{
+	{
+		name:        "install",
+		description: "install the transparent proxy",
+		run:         runInstall,
+	},
+	{
+		name:        "uninstall",
+		description: "remove iptables rules, chains and ipsets created by install",
+		run:         runUninstall,
+	},
+	{
+		name:        "status",
+		description: "show installed chains and counters of generated rules",
+		run:         runStatus,
+	},
+	{
+		name:        "dry-run",
+		description: "print ipsets and iptables rules of both IP families, which would be installed",
+		run:         runDryRun,
+	},
+	{
+		name:        "explain",
+		description: "explain the selected backend and purposes of generated rules",
+		run:         runExplain,
+	},
+	{
+		name:        "firewalld",
+		description: "store generated rules as firewalld direct rules",
+		run:         runFirewalld,
+		flags:       registerFirewalldFlags,
+	},
+}
+
+func usage(w io.Writer) {
+	var lines []string
+
+	lines = append(lines,
+		"Usage: kuma-net <command> [flags]",
+		"",
+		"Commands:",
+	)
+
+	for _, cmd := range commands {
+		lines = append(lines, fmt.Sprintf("  %-10s %s", cmd.name, cmd.description))
+	}
+
+	lines = append(lines,
+		"",
+		"Flags can also be set by environment variables (i.e. KUMA_NET_REDIRECT_OUTBOUND",
+		"for --redirect-outbound) and by the JSON config file (--config) with fields",
+		"of the configuration. Flags take precedence over environment variables,",
+		"which take precedence over the config file.",
+		"",
+		"Exit codes:",
+		fmt.Sprintf("  %d  success", exitOK),
+		fmt.Sprintf("  %d  the command failed", exitError),
+		fmt.Sprintf("  %d  invalid usage, flags, environment variables or config file", exitUsage),
+		fmt.Sprintf("  %d  the transparent proxy is not installed (status)", exitNotInstalled),
+		"",
+		"Run 'kuma-net <command> -h' to list flags of the command.",
+	)
+
+	_, _ = fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+
+	return command{}, false
+}
+
+func printError(stdout, stderr io.Writer, output outputFormat, err error) {
+	if output == outputJSON {
+		data, _ := json.Marshal(struct{ Error string }{Error: err.Error()})
+		_, _ = fmt.Fprintln(stdout, string(data))
+
+		return
+	}
+
+	_, _ = fmt.Fprintf(stderr, "Error: %s\n", err)
+}
+
+func printResult(stdout io.Writer, output outputFormat, res result) error {
+	if output == outputJSON {
+		data, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to marshal the result: %s", err)
+		}
+
+		_, _ = fmt.Fprintln(stdout, string(data))
+
+		return nil
+	}
+
+	if text := res.text(); text != "" {
+		_, _ = fmt.Fprintln(stdout, strings.TrimRight(text, "\n"))
+	}
+
+	return nil
+}
+
+func run(args []string, stdout, stderr io.Writer, lookupEnv func(string) (string, bool)) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		usage(stdout)
+		return exitOK
+	}
+
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "Error: unknown command %q\n\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+
+	opts := options{output: outputText}
+	var cfg config.Config
+
+	configPath := findConfigFlag(args[1:])
+	if configPath == "" {
+		configPath, _ = lookupEnv(envName("config"))
+	}
+
+	if configPath != "" {
+		if err := loadConfigFile(configPath, &cfg); err != nil {
+			printError(stdout, stderr, opts.output, err)
+			return exitUsage
+		}
+	}
+
+	fs := flag.NewFlagSet("kuma-net "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.String("config", configPath, "path to the JSON config file with fields of the configuration")
+	fs.Var(outputValue{&opts.output}, "output", "output format (text or json)")
+	registerConfigFlags(fs, &cfg)
+
+	if cmd.flags != nil {
+		cmd.flags(fs, &opts)
+	}
+
+	if err := applyEnv(fs, lookupEnv); err != nil {
+		printError(stdout, stderr, opts.output, err)
+		return exitUsage
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+
+		return exitUsage
+	}
+
+	if fs.NArg() > 0 {
+		printError(stdout, stderr, opts.output,
+			fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " ")))
+		return exitUsage
+	}
+
+	// JSON output cannot be mixed with progress messages
+	cfg.RuntimeStdout = stdout
+	if opts.output == outputJSON {
+		cfg.RuntimeStdout = io.Discard
+	}
+
+	cfg.RuntimeStderr = stderr
+
+	res, err := cmd.run(cfg, opts)
+	if err != nil {
+		printError(stdout, stderr, opts.output, err)
+		return exitError
+	}
+
+	if err := printResult(stdout, opts.output, res); err != nil {
+		printError(stdout, stderr, opts.output, err)
+		return exitError
+	}
+
+	if coder, ok := res.(exitCoder); ok {
+		return coder.exitCode()
+	}
+
+	return exitOK
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.LookupEnv))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("kuma-net", func() {
+	var stdout, stderr *bytes.Buffer
+	var env map[string]string
+
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	BeforeEach(func() {
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+		env = map[string]string{}
+	})
+
+	DescribeTable("should return exit codes",
+		func(args []string, exitCode int) {
+			Expect(run(args, stdout, stderr, lookupEnv)).To(Equal(exitCode))
+		},
+		Entry("no command", nil, exitUsage),
+		Entry("help", []string{"help"}, exitOK),
+		Entry("unknown command", []string{"unknown"}, exitUsage),
+		Entry("invalid flag value", []string{"dry-run", "--inbound-port", "x"}, exitUsage),
+		Entry("invalid port range", []string{"dry-run", "--exclude-outbound-port-ranges", "2-1"}, exitUsage),
+		Entry("unexpected arguments", []string{"dry-run", "foo"}, exitUsage),
+		Entry("invalid output", []string{"dry-run", "--output", "yaml"}, exitUsage),
+		Entry("missing config file", []string{"dry-run", "--config", "/nonexistent.json"}, exitUsage),
+		Entry("watching resolv.conf without DNS redirection", []string{"install", "--watch-resolv-conf"}, exitError),
+	)
+
+	It("should print rules in JSON", func() {
+		// when
+		exitCode := run([]string{
+			"dry-run",
+			"--redirect-outbound",
+			"--exclude-outbound-ports", "22,80",
+			"--output", "json",
+		}, stdout, stderr, lookupEnv)
+
+		// then
+		Expect(exitCode).To(Equal(exitOK))
+
+		var res dryRunResult
+		Expect(json.Unmarshal(stdout.Bytes(), &res)).To(Succeed())
+		Expect(res.Rules).To(ContainSubstring("--dports 22,80"))
+		Expect(res.Rules).To(ContainSubstring("-j REDIRECT --to-ports 15001"))
+	})
+
+	It("should print rules of both IP families", func() {
+		// when
+		exitCode := run([]string{
+			"dry-run", "--redirect-outbound", "--ipv6", "--output", "json",
+		}, stdout, stderr, lookupEnv)
+
+		// then
+		Expect(exitCode).To(Equal(exitOK))
+
+		var res dryRunResult
+		Expect(json.Unmarshal(stdout.Bytes(), &res)).To(Succeed())
+		Expect(res.Rules).To(ContainSubstring("-d 127.0.0.1/32"))
+		Expect(res.IPv6Rules).To(ContainSubstring("-d ::1/128"))
+	})
+
+	It("should refuse to explain rules without comments", func() {
+		// when
+		exitCode := run([]string{"explain"}, stdout, stderr, lookupEnv)
+
+		// then
+		Expect(exitCode).To(Equal(exitError))
+		Expect(stderr.String()).To(ContainSubstring("unless comments are enabled"))
+	})
+
+	It("should print errors in JSON", func() {
+		// when
+		exitCode := run([]string{
+			"dry-run", "--output", "json", "--trace", "--trace-target", "FOO",
+		}, stdout, stderr, lookupEnv)
+
+		// then
+		Expect(exitCode).To(Equal(exitError))
+		Expect(stdout.String()).To(HavePrefix(`{`))
+		Expect(stdout.String()).To(ContainSubstring(`"Error"`))
+	})
+
+	It("should prefer flags to environment variables and the config file", func() {
+		// given
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(configPath, []byte(`{
+			"Redirect": {
+				"Outbound": {"Enabled": true, "Port": 1000},
+				"Inbound": {"Enabled": true, "Port": 2000}
+			}
+		}`), 0o600)).To(Succeed())
+
+		env[envName("config")] = configPath
+		env[envName("outbound-port")] = "3000"
+		env[envName("inbound-port")] = "4000"
+
+		// when
+		exitCode := run([]string{"dry-run", "--inbound-port", "5000"}, stdout, stderr, lookupEnv)
+
+		// then
+		Expect(exitCode).To(Equal(exitOK))
+		Expect(stdout.String()).To(ContainSubstring("--to-ports 3000"))
+		Expect(stdout.String()).To(ContainSubstring("--to-ports 5000"))
+		Expect(stdout.String()).ToNot(ContainSubstring("--to-ports 1000"))
+		Expect(stdout.String()).ToNot(ContainSubstring("--to-ports 4000"))
+	})
+
+	It("should reject unknown fields of the config file", func() {
+		// given
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(configPath, []byte(`{"Unknown": true}`), 0o600)).To(Succeed())
+
+		// when
+		exitCode := run([]string{"dry-run", "--config=" + configPath}, stdout, stderr, lookupEnv)
+
+		// then
+		Expect(exitCode).To(Equal(exitUsage))
+		Expect(stderr.String()).To(ContainSubstring("Unknown"))
+	})
+})
+
+var _ = Describe("envName", func() {
+	It("should convert flag names", func() {
+		Expect(envName("exclude-outbound-ports")).To(Equal("KUMA_NET_EXCLUDE_OUTBOUND_PORTS"))
+	})
+})
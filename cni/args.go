@@ -0,0 +1,104 @@
+package cni
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Commands of the CNI specification
+const (
+	CommandAdd     = "ADD"
+	CommandDel     = "DEL"
+	CommandCheck   = "CHECK"
+	CommandVersion = "VERSION"
+)
+
+// Args are parameters of the plugin invocation passed by the container
+// runtime in CNI_* environment variables
+type Args struct {
+	Command     string
+	ContainerID string
+	// NetNS is the path to the network namespace of the container
+	// (i.e. /run/netns/pod), which can be empty for DEL
+	NetNS  string
+	IfName string
+	// Args are additional arguments in format "KEY1=VALUE1;KEY2=VALUE2"
+	// (i.e. K8S_POD_NAMESPACE=default;K8S_POD_NAME=demo-app)
+	Args string
+	Path string
+}
+
+// ArgsFromEnv returns Args read from CNI_* environment variables
+func ArgsFromEnv(lookupEnv func(string) (string, bool)) Args {
+	get := func(name string) string {
+		value, _ := lookupEnv(name)
+		return value
+	}
+
+	return Args{
+		Command:     get("CNI_COMMAND"),
+		ContainerID: get("CNI_CONTAINERID"),
+		NetNS:       get("CNI_NETNS"),
+		IfName:      get("CNI_IFNAME"),
+		Args:        get("CNI_ARGS"),
+		Path:        get("CNI_PATH"),
+	}
+}
+
+// validate checks if environment variables required by the command are set
+func (a Args) validate() error {
+	var missing []string
+
+	if a.ContainerID == "" {
+		missing = append(missing, "CNI_CONTAINERID")
+	}
+
+	// the network namespace can be already gone when the container is removed
+	if a.NetNS == "" && a.Command != CommandDel {
+		missing = append(missing, "CNI_NETNS")
+	}
+
+	if a.IfName == "" {
+		missing = append(missing, "CNI_IFNAME")
+	}
+
+	if len(missing) > 0 {
+		return newError(ErrInvalidEnvironment, "required environment variables are missing",
+			fmt.Errorf("%s", strings.Join(missing, ", ")))
+	}
+
+	return nil
+}
+
+// RuntimeArgs are parsed additional arguments (CNI_ARGS)
+type RuntimeArgs map[string]string
+
+// PodNamespace returns the namespace of the pod passed by Kubernetes runtimes
+func (a RuntimeArgs) PodNamespace() string {
+	return a["K8S_POD_NAMESPACE"]
+}
+
+// PodName returns the name of the pod passed by Kubernetes runtimes
+func (a RuntimeArgs) PodName() string {
+	return a["K8S_POD_NAME"]
+}
+
+// ParseRuntimeArgs parses additional arguments in format "KEY1=VALUE1;KEY2=VALUE2"
+func ParseRuntimeArgs(args string) (RuntimeArgs, error) {
+	result := RuntimeArgs{}
+
+	if args == "" {
+		return result, nil
+	}
+
+	for _, pair := range strings.Split(args, ";") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid argument %q (expected format: KEY=VALUE)", pair)
+		}
+
+		result[key] = value
+	}
+
+	return result, nil
+}
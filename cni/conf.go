@@ -0,0 +1,148 @@
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// SupportedVersions are versions of the CNI specification supported
+// by the plugin
+var SupportedVersions = []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0"}
+
+// checkSupportedSince is the first version of the CNI specification defining
+// the CHECK command
+const checkSupportedSince = "0.4.0"
+
+func versionIndex(version string) int {
+	for i, supported := range SupportedVersions {
+		if supported == version {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// NetConf is the network configuration of the plugin passed by the container
+// runtime to stdin, i.e.:
+//
+//	{
+//	  "cniVersion": "1.0.0",
+//	  "name": "k8s-pod-network",
+//	  "type": "kuma-net-cni",
+//	  "excludeNamespaces": ["kube-system"],
+//	  "kumaNet": {
+//	    "Redirect": {
+//	      "Inbound": {"Enabled": true},
+//	      "Outbound": {"Enabled": true}
+//	    }
+//	  }
+//	}
+type NetConf struct {
+	CNIVersion string `json:"cniVersion"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	// ExcludeNamespaces are namespaces of pods (K8S_POD_NAMESPACE), which
+	// traffic won't be redirected
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// KumaNet is the configuration of the transparent proxy (see config.Config)
+	KumaNet config.Config `json:"kumaNet"`
+	// RawPrevResult is the result of the previous plugin in the chain, which
+	// is returned unchanged by ADD
+	RawPrevResult json.RawMessage `json:"prevResult,omitempty"`
+}
+
+// ParseNetConf parses the network configuration passed to stdin
+func ParseNetConf(data []byte) (*NetConf, error) {
+	conf := &NetConf{}
+
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, newError(ErrDecodingFailure, "cannot parse network configuration", err)
+	}
+
+	if conf.CNIVersion == "" {
+		return nil, newError(ErrInvalidNetworkConf, "cniVersion is not set", nil)
+	}
+
+	if versionIndex(conf.CNIVersion) < 0 {
+		return nil, newError(ErrIncompatibleVersion,
+			fmt.Sprintf("unsupported CNI version %q", conf.CNIVersion),
+			fmt.Errorf("supported versions: %v", SupportedVersions))
+	}
+
+	return conf, nil
+}
+
+func (c *NetConf) isNamespaceExcluded(namespace string) bool {
+	for _, excluded := range c.ExcludeNamespaces {
+		if excluded == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Interface is the interface from the result of the previous plugin
+type Interface struct {
+	Name string `json:"name"`
+	// Sandbox is the path to the network namespace of the interface
+	// (it's empty for interfaces on the host)
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+// IPConfig is the address from the result of the previous plugin
+type IPConfig struct {
+	// Interface is the index of the interface in Result.Interfaces
+	Interface *int   `json:"interface,omitempty"`
+	Address   string `json:"address"`
+}
+
+// Result contains fields of the result of the previous plugin, which are
+// relevant for the configuration of the transparent proxy
+type Result struct {
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	IPs        []IPConfig  `json:"ips,omitempty"`
+}
+
+// ParsePrevResult parses the result of the previous plugin
+func (c *NetConf) ParsePrevResult() (*Result, error) {
+	if len(bytes.TrimSpace(c.RawPrevResult)) == 0 {
+		return nil, newError(ErrInvalidNetworkConf,
+			"prevResult is missing (the plugin has to be chained)", nil)
+	}
+
+	result := &Result{}
+
+	if err := json.Unmarshal(c.RawPrevResult, result); err != nil {
+		return nil, newError(ErrDecodingFailure, "cannot parse prevResult", err)
+	}
+
+	return result, nil
+}
+
+// PodIPs returns addresses assigned to interfaces inside the network
+// namespace of the pod (addresses without the interface are included as well)
+func (r *Result) PodIPs() ([]net.IP, error) {
+	var ips []net.IP
+
+	for _, ipConfig := range r.IPs {
+		if i := ipConfig.Interface; i != nil && *i >= 0 && *i < len(r.Interfaces) &&
+			r.Interfaces[*i].Sandbox == "" {
+			continue
+		}
+
+		ip, _, err := net.ParseCIDR(ipConfig.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %s", ipConfig.Address, err)
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,58 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Error codes defined by the CNI specification
+const (
+	ErrIncompatibleVersion = 1
+	ErrUnsupportedField    = 2
+	ErrUnknownContainer    = 3
+	ErrInvalidEnvironment  = 4
+	ErrIOFailure           = 5
+	ErrDecodingFailure     = 6
+	ErrInvalidNetworkConf  = 7
+	ErrTryAgainLater       = 11
+)
+
+// Error is the error result of the plugin, which is printed to stdout
+// as required by the CNI specification
+type Error struct {
+	CNIVersion string `json:"cniVersion"`
+	Code       uint   `json:"code"`
+	Msg        string `json:"msg"`
+	Details    string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Details == "" {
+		return e.Msg
+	}
+
+	return fmt.Sprintf("%s: %s", e.Msg, e.Details)
+}
+
+func newError(code uint, msg string, details error) *Error {
+	err := &Error{Code: code, Msg: msg}
+	if details != nil {
+		err.Details = details.Error()
+	}
+
+	return err
+}
+
+func printError(w io.Writer, cniVersion string, err error) {
+	cniErr, ok := err.(*Error)
+	if !ok {
+		cniErr = &Error{Code: ErrIOFailure, Msg: err.Error()}
+	}
+
+	if cniErr.CNIVersion == "" {
+		cniErr.CNIVersion = cniVersion
+	}
+
+	_ = json.NewEncoder(w).Encode(cniErr)
+}
@@ -0,0 +1,336 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/vishvananda/netns"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	transparent_proxy "github.com/kumahq/kuma-net/transparent-proxy"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+type versionResult struct {
+	CNIVersion        string   `json:"cniVersion"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+func latestVersion() string {
+	return SupportedVersions[len(SupportedVersions)-1]
+}
+
+// Main executes the CNI command described by CNI_* environment variables
+// with the network configuration read from stdin. The result (or the error)
+// is printed to stdout and the exit code is returned
+func Main(lookupEnv func(string) (string, bool), stdin io.Reader, stdout, stderr io.Writer) int {
+	cniVersion := latestVersion()
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		printError(stdout, cniVersion, newError(ErrIOFailure, "cannot read network configuration", err))
+		return 1
+	}
+
+	// the version of the error should match the version of the configuration
+	// (when it's known)
+	var version struct {
+		CNIVersion string `json:"cniVersion"`
+	}
+	if json.Unmarshal(data, &version) == nil && version.CNIVersion != "" {
+		cniVersion = version.CNIVersion
+	}
+
+	if err := Run(ArgsFromEnv(lookupEnv), data, stdout, stderr); err != nil {
+		printError(stdout, cniVersion, err)
+		return 1
+	}
+
+	return 0
+}
+
+// Run executes provided CNI command with provided network configuration
+func Run(args Args, stdin []byte, stdout, stderr io.Writer) error {
+	if args.Command == CommandVersion {
+		return json.NewEncoder(stdout).Encode(versionResult{
+			CNIVersion:        latestVersion(),
+			SupportedVersions: SupportedVersions,
+		})
+	}
+
+	switch args.Command {
+	case CommandAdd, CommandDel, CommandCheck:
+	case "":
+		return newError(ErrInvalidEnvironment, "CNI_COMMAND is not set", nil)
+	default:
+		return newError(ErrInvalidEnvironment, fmt.Sprintf("unknown CNI_COMMAND %q", args.Command), nil)
+	}
+
+	if err := args.validate(); err != nil {
+		return err
+	}
+
+	conf, err := ParseNetConf(stdin)
+	if err != nil {
+		return err
+	}
+
+	runtimeArgs, err := ParseRuntimeArgs(args.Args)
+	if err != nil {
+		return newError(ErrInvalidEnvironment, "cannot parse CNI_ARGS", err)
+	}
+
+	if conf.isNamespaceExcluded(runtimeArgs.PodNamespace()) {
+		_, _ = fmt.Fprintf(stderr, "pod %s/%s is in the excluded namespace, skipping %s\n",
+			runtimeArgs.PodNamespace(), runtimeArgs.PodName(), args.Command)
+
+		return printPrevResult(stdout, args, conf)
+	}
+
+	switch args.Command {
+	case CommandAdd:
+		return cmdAdd(args, conf, stdout, stderr)
+	case CommandDel:
+		return cmdDel(args, conf, stderr)
+	default:
+		return cmdCheck(args, conf, stderr)
+	}
+}
+
+// printPrevResult prints the result of the previous plugin, which is
+// the result of ADD of the chained plugin (DEL and CHECK print nothing)
+func printPrevResult(stdout io.Writer, args Args, conf *NetConf) error {
+	if args.Command != CommandAdd {
+		return nil
+	}
+
+	if _, err := conf.ParsePrevResult(); err != nil {
+		return err
+	}
+
+	if _, err := stdout.Write(append(conf.RawPrevResult, '\n')); err != nil {
+		return newError(ErrIOFailure, "cannot print result", err)
+	}
+
+	return nil
+}
+
+// podConfig returns the configuration of the transparent proxy for the pod
+// with provided result of the previous plugin. ip6tables are configured
+// only when the pod has IPv6 address, and the instance IP of eBPF programs
+// defaults to the IPv4 address of the pod. As stdout is reserved
+// for the result, runtime information is printed to stderr
+//
+// The plugin is running on the host, so resolv.conf files and users
+// of the host are not the ones of the pod. Because of that all the DNS
+// traffic is captured, and DNS traffic of systemd-resolved is not excluded
+func podConfig(conf *NetConf, result *Result, stderr io.Writer) (config.Config, error) {
+	cfg := conf.KumaNet
+	cfg.RuntimeStdout = stderr
+	cfg.RuntimeStderr = stderr
+	cfg.Redirect.DNS.CaptureAll = true
+	cfg.Redirect.DNS.SystemdResolvedUser = ""
+
+	if result == nil {
+		return cfg, nil
+	}
+
+	ips, err := result.PodIPs()
+	if err != nil {
+		return config.Config{}, newError(ErrInvalidNetworkConf, "invalid prevResult", err)
+	}
+
+	var ipv4 string
+	var hasIPv6 bool
+
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			hasIPv6 = true
+		} else if ipv4 == "" {
+			ipv4 = ip.String()
+		}
+	}
+
+	cfg.IPv6 = cfg.IPv6 && hasIPv6
+
+	if cfg.Ebpf.InstanceIP == "" {
+		cfg.Ebpf.InstanceIP = ipv4
+	}
+
+	return cfg, nil
+}
+
+func cmdAdd(args Args, conf *NetConf, stdout, stderr io.Writer) error {
+	result, err := conf.ParsePrevResult()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := podConfig(conf, result, stderr)
+	if err != nil {
+		return err
+	}
+
+	setup := func() error {
+		decision, err := transparent_proxy.SelectBackend(cfg)
+		if err != nil {
+			return newError(ErrInvalidNetworkConf, "cannot select transparent proxy backend", err)
+		}
+
+		// ADD can be retried by the runtime, so rules installed by
+		// the previous attempt are removed first
+		if decision.Backend == transparent_proxy.BackendIptables && !cfg.DryRun {
+			if _, err := builder.CleanupIPTables(cfg); err != nil {
+				return newError(ErrIOFailure, "cannot remove previously installed rules", err)
+			}
+		}
+
+		if _, err := transparent_proxy.Setup(cfg); err != nil {
+			return newError(ErrIOFailure, "cannot setup transparent proxy", err)
+		}
+
+		return nil
+	}
+
+	// rules are only printed in the dry run mode, so there is no need
+	// to enter the network namespace
+	if cfg.DryRun {
+		err = setup()
+	} else {
+		err = withNetNS(args.NetNS, setup)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return printPrevResult(stdout, args, conf)
+}
+
+func cmdDel(args Args, conf *NetConf, stderr io.Writer) error {
+	// DEL has to succeed when the network namespace is already gone
+	if args.NetNS == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(args.NetNS); os.IsNotExist(err) {
+		return nil
+	}
+
+	// prevResult is optional for DEL
+	var result *Result
+	if len(conf.RawPrevResult) > 0 {
+		var err error
+		if result, err = conf.ParsePrevResult(); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := podConfig(conf, result, stderr)
+	if err != nil {
+		return err
+	}
+
+	return withNetNS(args.NetNS, func() error {
+		if _, err := transparent_proxy.Uninstall(cfg); err != nil {
+			return newError(ErrIOFailure, "cannot uninstall transparent proxy", err)
+		}
+
+		return nil
+	})
+}
+
+func cmdCheck(args Args, conf *NetConf, stderr io.Writer) error {
+	if versionIndex(conf.CNIVersion) < versionIndex(checkSupportedSince) {
+		return newError(ErrIncompatibleVersion,
+			fmt.Sprintf("CHECK is not supported by CNI version %q", conf.CNIVersion), nil)
+	}
+
+	result, err := conf.ParsePrevResult()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := podConfig(conf, result, stderr)
+	if err != nil {
+		return err
+	}
+
+	decision, err := transparent_proxy.SelectBackend(cfg)
+	if err != nil {
+		return newError(ErrInvalidNetworkConf, "cannot select transparent proxy backend", err)
+	}
+
+	// installed eBPF programs cannot be verified
+	if decision.Backend != transparent_proxy.BackendIptables {
+		return nil
+	}
+
+	return withNetNS(args.NetNS, func() error {
+		statuses, err := builder.GetStatus(cfg)
+		if err != nil {
+			return newError(ErrIOFailure, "cannot get transparent proxy status", err)
+		}
+
+		for _, status := range statuses {
+			if !status.Installed {
+				family := "iptables"
+				if status.IPv6 {
+					family = "ip6tables"
+				}
+
+				return newError(ErrIOFailure,
+					fmt.Sprintf("transparent proxy is not installed in %s", family), nil)
+			}
+		}
+
+		return nil
+	})
+}
+
+// withNetNS executes provided function in the network namespace with provided
+// path. Commands executed by the function (i.e. iptables-restore) inherit
+// the namespace, as they are started from the locked thread
+func withNetNS(path string, fn func() error) error {
+	target, err := netns.GetFromPath(path)
+	if err != nil {
+		return newError(ErrUnknownContainer, fmt.Sprintf("cannot open network namespace %s", path), err)
+	}
+	defer target.Close()
+
+	done := make(chan error)
+
+	go func() {
+		// the thread is unlocked only after switching back to the original
+		// namespace, otherwise it's terminated together with the goroutine
+		runtime.LockOSThread()
+
+		original, err := netns.Get()
+		if err != nil {
+			done <- newError(ErrIOFailure, "cannot get the original network namespace", err)
+			return
+		}
+		defer original.Close()
+
+		if err := netns.Set(target); err != nil {
+			done <- newError(ErrIOFailure, fmt.Sprintf("cannot switch to network namespace %s", path), err)
+			return
+		}
+
+		fnErr := fn()
+
+		if err := netns.Set(original); err != nil {
+			done <- newError(ErrIOFailure, "cannot switch to the original network namespace", err)
+			return
+		}
+
+		runtime.UnlockOSThread()
+
+		done <- fnErr
+	}()
+
+	return <-done
+}
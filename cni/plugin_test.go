@@ -0,0 +1,206 @@
+package cni_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/cni"
+	cni_framework "github.com/kumahq/kuma-net/test/framework/cni"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("CNI plugin", func() {
+	const netNSPath = "/run/netns/kuma-net-test"
+
+	dryRunConfig := config.Config{
+		Redirect: config.Redirect{
+			Outbound: config.TrafficFlow{Enabled: true, Port: 15001},
+		},
+		DryRun: true,
+	}
+
+	invoke := func(command string, conf []byte, args string) (cni_framework.Output, error) {
+		return cni_framework.Invoke(cni_framework.Invocation{
+			Command:     command,
+			ContainerID: cni_framework.DefaultContainerID,
+			NetNS:       netNSPath,
+			IfName:      cni_framework.DefaultIfName,
+			Args:        args,
+			Conf:        conf,
+		})
+	}
+
+	newConf := func(cfg config.Config, addresses ...string) []byte {
+		conf, err := cni_framework.NewConf(cfg, netNSPath, addresses...)
+		Expect(err).ToNot(HaveOccurred())
+
+		return conf
+	}
+
+	expectCNIError := func(err error, code uint) {
+		cniErr, ok := err.(*cni.Error)
+		Expect(ok).To(BeTrue(), "expected *cni.Error, got: %v", err)
+		Expect(cniErr.Code).To(Equal(code), cniErr.Error())
+	}
+
+	It("should print supported versions", func() {
+		// when
+		output, err := invoke(cni.CommandVersion, nil, "")
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output.Stdout).To(MatchJSON(
+			`{"cniVersion": "1.0.0", "supportedVersions": ["0.3.0", "0.3.1", "0.4.0", "1.0.0"]}`,
+		))
+	})
+
+	It("should return the previous result and print rules to stderr", func() {
+		// given
+		conf := newConf(dryRunConfig, "10.0.0.2/24")
+
+		var netConf map[string]json.RawMessage
+		Expect(json.Unmarshal(conf, &netConf)).To(Succeed())
+
+		// when
+		output, err := invoke(cni.CommandAdd, conf, "K8S_POD_NAMESPACE=default;K8S_POD_NAME=demo-app")
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output.Stdout).To(MatchJSON(netConf["prevResult"]))
+		Expect(string(output.Stderr)).To(ContainSubstring("-j REDIRECT --to-ports 15001"))
+	})
+
+	It("should capture all the DNS traffic of the pod", func() {
+		// given
+		cfg := dryRunConfig
+		cfg.Redirect.DNS = config.DNS{
+			Enabled:          true,
+			Port:             15053,
+			ResolvConfigPath: "/nonexistent/resolv.conf",
+		}
+
+		// when
+		output, err := invoke(cni.CommandAdd, newConf(cfg, "10.0.0.2/24"), "")
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(output.Stderr)).To(ContainSubstring(
+			"-A OUTPUT -p udp --dport 53 -j REDIRECT --to-ports 15053",
+		))
+		Expect(string(output.Stderr)).ToNot(ContainSubstring("MESH_DNS_REDIRECT"))
+	})
+
+	It("should skip pods in excluded namespaces", func() {
+		// given
+		var netConf map[string]json.RawMessage
+		Expect(json.Unmarshal(newConf(dryRunConfig, "10.0.0.2/24"), &netConf)).To(Succeed())
+		netConf["excludeNamespaces"] = json.RawMessage(`["kube-system"]`)
+
+		conf, err := json.Marshal(netConf)
+		Expect(err).ToNot(HaveOccurred())
+
+		// when
+		output, err := invoke(cni.CommandAdd, conf, "K8S_POD_NAMESPACE=kube-system;K8S_POD_NAME=coredns")
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output.Stdout).To(MatchJSON(netConf["prevResult"]))
+		Expect(string(output.Stderr)).ToNot(ContainSubstring("REDIRECT"))
+	})
+
+	It("should succeed DEL without the network namespace", func() {
+		// when
+		_, err := cni_framework.Invoke(cni_framework.Invocation{
+			Command:     cni.CommandDel,
+			ContainerID: cni_framework.DefaultContainerID,
+			IfName:      cni_framework.DefaultIfName,
+			Conf:        newConf(config.Config{}),
+		})
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should fail without the previous result", func() {
+		// when
+		output, err := invoke(cni.CommandAdd, []byte(`{"cniVersion": "0.4.0", "name": "test", "type": "kuma-net-cni"}`), "")
+
+		// then
+		expectCNIError(err, cni.ErrInvalidNetworkConf)
+		Expect(output.Stdout).To(ContainSubstring(`"cniVersion":"0.4.0"`))
+	})
+
+	DescribeTable("should fail",
+		func(command, netNS, conf, args string, code uint) {
+			// when
+			_, err := cni_framework.Invoke(cni_framework.Invocation{
+				Command:     command,
+				ContainerID: cni_framework.DefaultContainerID,
+				NetNS:       netNS,
+				IfName:      cni_framework.DefaultIfName,
+				Args:        args,
+				Conf:        []byte(conf),
+			})
+
+			// then
+			expectCNIError(err, code)
+		},
+		Entry("without the command", "", netNSPath, `{}`, "", uint(cni.ErrInvalidEnvironment)),
+		Entry("with unknown command", "UPDATE", netNSPath, `{}`, "", uint(cni.ErrInvalidEnvironment)),
+		Entry("without the network namespace", cni.CommandAdd, "", `{}`, "", uint(cni.ErrInvalidEnvironment)),
+		Entry("with invalid configuration", cni.CommandAdd, netNSPath, `{`, "", uint(cni.ErrDecodingFailure)),
+		Entry("with unsupported version", cni.CommandAdd, netNSPath, `{"cniVersion": "0.2.0"}`, "",
+			uint(cni.ErrIncompatibleVersion)),
+		Entry("with CHECK before version 0.4.0", cni.CommandCheck, netNSPath, `{"cniVersion": "0.3.1"}`, "",
+			uint(cni.ErrIncompatibleVersion)),
+		Entry("with invalid arguments", cni.CommandAdd, netNSPath, `{"cniVersion": "1.0.0"}`, "K8S_POD_NAME",
+			uint(cni.ErrInvalidEnvironment)),
+	)
+})
+
+var _ = Describe("ParseRuntimeArgs", func() {
+	It("should parse Kubernetes arguments", func() {
+		// when
+		args, err := cni.ParseRuntimeArgs("IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=demo-app")
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args.PodNamespace()).To(Equal("default"))
+		Expect(args.PodName()).To(Equal("demo-app"))
+	})
+})
+
+var _ = Describe("Result", func() {
+	It("should return addresses of interfaces in the pod", func() {
+		// given
+		conf, err := cni.ParseNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"prevResult": {
+				"interfaces": [
+					{"name": "veth1234"},
+					{"name": "eth0", "sandbox": "/run/netns/pod"}
+				],
+				"ips": [
+					{"interface": 0, "address": "169.254.1.1/32"},
+					{"interface": 1, "address": "10.0.0.2/24"},
+					{"interface": 1, "address": "fd00::2/64"}
+				]
+			}
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := conf.ParsePrevResult()
+		Expect(err).ToNot(HaveOccurred())
+
+		// when
+		ips, err := result.PodIPs()
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ips).To(HaveLen(2))
+		Expect(ips[0].String()).To(Equal("10.0.0.2"))
+		Expect(ips[1].String()).To(Equal("fd00::2"))
+	})
+})
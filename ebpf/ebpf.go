@@ -18,26 +18,10 @@ import (
 )
 
 // MaxItemLen is the maximal amount of items like ports or IP ranges to include
-// or/and exclude. It's currently hardcoded to 10 as merbridge during creation
-// of this map is assigning hardcoded 244 bytes for map values:
-//
-//  Cidr:        8 bytes
-//    Cidr.Net:  4 bytes
-//    Cidr.Mask: 1 byte
-//    pad:       3 bytes
-//
-//  PodConfig:                                  244 bytes
-//    PodConfig.StatusPort:                       2 bytes
-//    pad:                                        2 bytes
-//    PodConfig.ExcludeOutRanges (10x Cidr):     80 bytes
-//    PodConfig.IncludeOutRanges (10x Cidr):     80 bytes
-//    PodConfig.IncludeInPorts   (10x 2 bytes):  20 bytes
-//    PodConfig.IncludeOutPorts  (10x 2 bytes):  20 bytes
-//    PodConfig.ExcludeInPorts   (10x 2 bytes):  20 bytes
-//    PodConfig.ExcludeOutPorts  (10x 2 bytes):  20 bytes
-//
-// todo (bartsmykla): merbridge flagged this constant to be changed, so if
-//                    it will be changed, we have to update it
+// or/and exclude, which merbridge is using (MAX_ITEM_LEN) when creating
+// the local_pod_ips map. merbridge flagged this constant to be changed, so
+// the layout which is actually used is being detected at runtime from
+// the pinned map (see DetectPodConfigLayout and KnownPodConfigLayouts)
 const MaxItemLen = 10
 
 // LocalPodIPSPinnedMapPathRelativeToBPFFS is a path where the local_pod_ips map
@@ -52,21 +36,13 @@ type Cidr struct {
 	_    [3]uint8 // pad
 }
 
-type PodConfig struct {
-	StatusPort       uint16
-	_                uint16 // pad
-	ExcludeOutRanges [MaxItemLen]Cidr
-	IncludeOutRanges [MaxItemLen]Cidr
-	IncludeInPorts   [MaxItemLen]uint16
-	IncludeOutPorts  [MaxItemLen]uint16
-	ExcludeInPorts   [MaxItemLen]uint16
-	ExcludeOutPorts  [MaxItemLen]uint16
-}
-
 type Program struct {
 	PinName          string
 	MakeLoadTarget   string
 	MakeAttachTarget string
+	// AttachPoint is a human-readable description of the hook the program
+	// is being attached to by MakeAttachTarget (i.e. "cgroup/connect4")
+	AttachPoint string
 }
 
 func ipStrToUint32(ipstr string) (uint32, error) {
@@ -0,0 +1,474 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	ciliumebpf "github.com/cilium/ebpf"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// CidrEntry is a human-readable representation of the Cidr struct
+type CidrEntry struct {
+	Net  string `json:"net"`
+	Mask uint8  `json:"mask"`
+}
+
+func (c CidrEntry) String() string {
+	return fmt.Sprintf("%s/%d", c.Net, c.Mask)
+}
+
+// PodConfigEntry is a human-readable representation of a single local_pod_ips
+// map entry (instance IP as a key and PodConfigValue as a value)
+type PodConfigEntry struct {
+	InstanceIP       string      `json:"instanceIP"`
+	StatusPort       uint16      `json:"statusPort,omitempty"`
+	ExcludeOutRanges []CidrEntry `json:"excludeOutRanges,omitempty"`
+	IncludeOutRanges []CidrEntry `json:"includeOutRanges,omitempty"`
+	IncludeInPorts   []uint16    `json:"includeInPorts,omitempty"`
+	IncludeOutPorts  []uint16    `json:"includeOutPorts,omitempty"`
+	ExcludeInPorts   []uint16    `json:"excludeInPorts,omitempty"`
+	ExcludeOutPorts  []uint16    `json:"excludeOutPorts,omitempty"`
+}
+
+// RawMapEntry is an entry of the map we don't know how to decode, so key
+// and value are presented as hex encoded strings
+type RawMapEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type MapInspection struct {
+	Name       string        `json:"name"`
+	PinPath    string        `json:"pinPath"`
+	Type       string        `json:"type"`
+	KeySize    uint32        `json:"keySize"`
+	ValueSize  uint32        `json:"valueSize"`
+	MaxEntries uint32        `json:"maxEntries"`
+	Entries    []RawMapEntry `json:"entries,omitempty"`
+	// Error is set when the map was found, but it was not possible to read it
+	Error string `json:"error,omitempty"`
+}
+
+type ProgramInspection struct {
+	ID           uint32   `json:"id,omitempty"`
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Tag          string   `json:"tag,omitempty"`
+	PinPath      string   `json:"pinPath,omitempty"`
+	AttachPoints []string `json:"attachPoints,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+type Inspection struct {
+	BPFFSPath      string              `json:"bpffsPath"`
+	LocalPodIPs    []PodConfigEntry    `json:"localPodIPs"`
+	Maps           []MapInspection     `json:"maps"`
+	PinnedPrograms []ProgramInspection `json:"pinnedPrograms"`
+	LoadedPrograms []ProgramInspection `json:"loadedPrograms"`
+	TCAttached     []string            `json:"tcAttached,omitempty"`
+}
+
+func (i *Inspection) JSON() ([]byte, error) {
+	return json.MarshalIndent(i, "", "  ")
+}
+
+func uint32ToIPStr(value uint32) string {
+	ip := make(net.IP, net.IPv4len)
+	*(*uint32)(unsafe.Pointer(&ip[0])) = value
+
+	return ip.String()
+}
+
+func nonZeroPorts(ports []uint16) []uint16 {
+	var result []uint16
+
+	for _, port := range ports {
+		if port != 0 {
+			result = append(result, port)
+		}
+	}
+
+	return result
+}
+
+func nonEmptyCidrs(cidrs []Cidr) []CidrEntry {
+	var result []CidrEntry
+
+	for _, cidr := range cidrs {
+		if cidr.Net == 0 && cidr.Mask == 0 {
+			continue
+		}
+
+		result = append(result, CidrEntry{
+			Net:  uint32ToIPStr(cidr.Net),
+			Mask: cidr.Mask,
+		})
+	}
+
+	return result
+}
+
+// NewPodConfigEntry decodes provided local_pod_ips map key and value into its
+// human-readable form
+func NewPodConfigEntry(ip uint32, podConfig *PodConfigValue) PodConfigEntry {
+	return PodConfigEntry{
+		InstanceIP:       uint32ToIPStr(ip),
+		StatusPort:       podConfig.StatusPort,
+		ExcludeOutRanges: nonEmptyCidrs(podConfig.ExcludeOutRanges),
+		IncludeOutRanges: nonEmptyCidrs(podConfig.IncludeOutRanges),
+		IncludeInPorts:   nonZeroPorts(podConfig.IncludeInPorts),
+		IncludeOutPorts:  nonZeroPorts(podConfig.IncludeOutPorts),
+		ExcludeInPorts:   nonZeroPorts(podConfig.ExcludeInPorts),
+		ExcludeOutPorts:  nonZeroPorts(podConfig.ExcludeOutPorts),
+	}
+}
+
+// loadLocalPodIPsMap loads the pinned local_pod_ips map, which can be
+// modified only when readOnly is false
+func loadLocalPodIPsMap(cfg config.Config, readOnly bool) (*ciliumebpf.Map, error) {
+	localPodIPsMap, err := ciliumebpf.LoadPinnedMap(
+		cfg.Ebpf.BPFFSPath+LocalPodIPSPinnedMapPathRelativeToBPFFS,
+		&ciliumebpf.LoadPinOptions{ReadOnly: readOnly},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("loading pinned local_pod_ips map failed: %v", err)
+	}
+
+	return localPodIPsMap, nil
+}
+
+func readLocalPodIPs(localPodIPsMap *ciliumebpf.Map) ([]PodConfigEntry, error) {
+	layout, err := DetectPodConfigLayout(localPodIPsMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PodConfigEntry
+	var ip uint32
+	var value []byte
+
+	iter := localPodIPsMap.Iterate()
+	for iter.Next(&ip, &value) {
+		podConfig, err := layout.Decode(value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding local_pod_ips map entry for %s failed: %v",
+				uint32ToIPStr(ip), err)
+		}
+
+		entries = append(entries, NewPodConfigEntry(ip, podConfig))
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over local_pod_ips map failed: %v", err)
+	}
+
+	return entries, nil
+}
+
+func inspectMap(m *ciliumebpf.Map, pinPath string) MapInspection {
+	result := MapInspection{
+		Name:       path.Base(pinPath),
+		PinPath:    pinPath,
+		Type:       m.Type().String(),
+		KeySize:    m.KeySize(),
+		ValueSize:  m.ValueSize(),
+		MaxEntries: m.MaxEntries(),
+	}
+
+	if info, err := m.Info(); err == nil && info.Name != "" {
+		result.Name = info.Name
+	}
+
+	// sockmap and sockhash values are socket file descriptors, which cannot
+	// be read from the user space
+	switch m.Type() {
+	case ciliumebpf.SockMap, ciliumebpf.SockHash:
+		return result
+	}
+
+	var key, value []byte
+
+	iter := m.Iterate()
+	for iter.Next(&key, &value) {
+		result.Entries = append(result.Entries, RawMapEntry{
+			Key:   hex.EncodeToString(key),
+			Value: hex.EncodeToString(value),
+		})
+	}
+
+	if err := iter.Err(); err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func inspectProgram(p *ciliumebpf.Program, pinPath string) ProgramInspection {
+	result := ProgramInspection{
+		Type:    p.Type().String(),
+		PinPath: pinPath,
+	}
+
+	if pinPath != "" {
+		result.Name = path.Base(pinPath)
+
+		for _, program := range programs {
+			if program.PinName == result.Name {
+				result.AttachPoints = append(result.AttachPoints, program.AttachPoint)
+			}
+		}
+	}
+
+	info, err := p.Info()
+	if err != nil {
+		result.Error = err.Error()
+
+		return result
+	}
+
+	if id, ok := info.ID(); ok {
+		result.ID = uint32(id)
+	}
+
+	if info.Name != "" {
+		result.Name = info.Name
+	}
+
+	result.Tag = info.Tag
+
+	return result
+}
+
+// walkPinnedObjects will try to load every file from the bpf file system
+// as a map or as a program, and call the respective callback
+func walkPinnedObjects(
+	bpffsPath string,
+	onMap func(m *ciliumebpf.Map, pinPath string),
+	onProgram func(p *ciliumebpf.Program, pinPath string),
+) error {
+	return filepath.WalkDir(bpffsPath, func(pinPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		if m, err := ciliumebpf.LoadPinnedMap(pinPath, &ciliumebpf.LoadPinOptions{
+			ReadOnly: true,
+		}); err == nil {
+			defer m.Close()
+			onMap(m, pinPath)
+
+			return nil
+		}
+
+		if p, err := ciliumebpf.LoadPinnedProgram(pinPath, nil); err == nil {
+			defer p.Close()
+			onProgram(p, pinPath)
+		}
+
+		return nil
+	})
+}
+
+func listLoadedPrograms() ([]ProgramInspection, error) {
+	var result []ProgramInspection
+	var id ciliumebpf.ProgramID
+
+	for {
+		var err error
+
+		if id, err = ciliumebpf.ProgramGetNextID(id); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return result, nil
+			}
+
+			return nil, fmt.Errorf("getting next program id failed: %v", err)
+		}
+
+		p, err := ciliumebpf.NewProgramFromID(id)
+		if err != nil {
+			// program could be unloaded in the meantime
+			continue
+		}
+
+		result = append(result, inspectProgram(p, ""))
+		_ = p.Close()
+	}
+}
+
+// tcFilter is a helper struct to parse "tc -json filter show [...]" results
+type tcFilter struct {
+	Kind    string `json:"kind"`
+	Options struct {
+		BPFName string `json:"bpf_name"`
+	} `json:"options"`
+}
+
+func listTCAttachedPrograms() ([]string, error) {
+	iface, err := getNonLoopbackInterface()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	for _, direction := range []string{"ingress", "egress"} {
+		var filters []tcFilter
+
+		stdout, err := runTc("-json", "filter", "show", "dev", iface.Name, direction)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.NewDecoder(&stdout).Decode(&filters); err != nil {
+			return nil, fmt.Errorf("json decoding failed: %v", err)
+		}
+
+		for _, filter := range filters {
+			if filter.Kind == "bpf" && filter.Options.BPFName != "" {
+				result = append(result, fmt.Sprintf("tc/%s/%s: %s",
+					iface.Name, direction, filter.Options.BPFName))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Inspect will gather information about eBPF maps and programs pinned
+// under cfg.Ebpf.BPFFSPath as well as programs loaded to the kernel,
+// to help debugging situations when traffic is being misrouted in eBPF mode
+func Inspect(cfg config.Config) (*Inspection, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	result := &Inspection{
+		BPFFSPath:      cfg.Ebpf.BPFFSPath,
+		LocalPodIPs:    []PodConfigEntry{},
+		Maps:           []MapInspection{},
+		PinnedPrograms: []ProgramInspection{},
+		LoadedPrograms: []ProgramInspection{},
+	}
+
+	localPodIPsMap, err := loadLocalPodIPsMap(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	defer localPodIPsMap.Close()
+
+	if result.LocalPodIPs, err = readLocalPodIPs(localPodIPsMap); err != nil {
+		return nil, err
+	}
+
+	if err := walkPinnedObjects(
+		cfg.Ebpf.BPFFSPath,
+		func(m *ciliumebpf.Map, pinPath string) {
+			result.Maps = append(result.Maps, inspectMap(m, pinPath))
+		},
+		func(p *ciliumebpf.Program, pinPath string) {
+			result.PinnedPrograms = append(result.PinnedPrograms, inspectProgram(p, pinPath))
+		},
+	); err != nil {
+		return nil, fmt.Errorf("walking through the bpf file system failed: %v", err)
+	}
+
+	if result.LoadedPrograms, err = listLoadedPrograms(); err != nil {
+		return nil, err
+	}
+
+	// tc may be not available, or there may be no non-loopback interfaces,
+	// which shouldn't prevent from returning all other information
+	if result.TCAttached, err = listTCAttachedPrograms(); err != nil {
+		_, _ = fmt.Fprintf(cfg.RuntimeStderr,
+			"[WARNING] listing tc attached programs failed: %v\n", err)
+	}
+
+	return result, nil
+}
+
+// CleanupStaleLocalPodIPs will remove entries from the local_pod_ips map
+// for instance IPs which are not present in provided liveInstanceIPs.
+// When cfg.DryRun is set, the map won't be modified, and only IPs which would
+// have been removed are returned
+func CleanupStaleLocalPodIPs(cfg config.Config, liveInstanceIPs []string) ([]string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	live := map[uint32]struct{}{}
+	for _, ipStr := range liveInstanceIPs {
+		ip, err := ipStrToUint32(ipStr)
+		if err != nil {
+			return nil, err
+		}
+
+		live[ip] = struct{}{}
+	}
+
+	localPodIPsMap, err := loadLocalPodIPsMap(cfg, cfg.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer localPodIPsMap.Close()
+
+	var stale []uint32
+	var ip uint32
+	var value []byte
+
+	iter := localPodIPsMap.Iterate()
+	for iter.Next(&ip, &value) {
+		if _, ok := live[ip]; !ok {
+			stale = append(stale, ip)
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over local_pod_ips map failed: %v", err)
+	}
+
+	var removed []string
+	var errs []string
+
+	for _, ip := range stale {
+		ipStr := uint32ToIPStr(ip)
+
+		if !cfg.DryRun {
+			if err := localPodIPsMap.Delete(ip); err != nil && !errors.Is(err, ciliumebpf.ErrKeyNotExist) {
+				errs = append(errs, fmt.Sprintf("%s: %v", ipStr, err))
+				continue
+			}
+		}
+
+		removed = append(removed, ipStr)
+	}
+
+	if cfg.DryRun {
+		_, _ = fmt.Fprintf(cfg.RuntimeStdout,
+			"stale local_pod_ips map entries which would be removed: %s\n",
+			strings.Join(removed, ", "))
+	} else if len(removed) > 0 {
+		_, _ = fmt.Fprintf(cfg.RuntimeStdout,
+			"stale local_pod_ips map entries removed: %s\n",
+			strings.Join(removed, ", "))
+	}
+
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("removing stale local_pod_ips map entries failed:\n\t%s",
+			strings.Join(errs, "\n\t"))
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package ebpf
+
+import (
+	"fmt"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+type Inspection struct{}
+
+func (i *Inspection) JSON() ([]byte, error) {
+	return nil, fmt.Errorf("ebpf is currently supported only on linux")
+}
+
+func Inspect(config.Config) (*Inspection, error) {
+	return nil, fmt.Errorf("ebpf is currently supported only on linux")
+}
+
+func CleanupStaleLocalPodIPs(config.Config, []string) ([]string, error) {
+	return nil, fmt.Errorf("ebpf is currently supported only on linux")
+}
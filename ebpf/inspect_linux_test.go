@@ -0,0 +1,138 @@
+package ebpf
+
+import (
+	"os"
+	"path/filepath"
+
+	ciliumebpf "github.com/cilium/ebpf"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func mustIPStrToUint32(ip string) uint32 {
+	value, err := ipStrToUint32(ip)
+	Expect(err).ToNot(HaveOccurred())
+
+	return value
+}
+
+var _ = Describe("inspect", func() {
+	DescribeTable("uint32ToIPStr should reverse ipStrToUint32",
+		func(ip string) {
+			Expect(uint32ToIPStr(mustIPStrToUint32(ip))).To(Equal(ip))
+		},
+		Entry("private address", "10.0.0.1"),
+		Entry("address with all the octets different", "192.168.1.254"),
+		Entry("zero address", "0.0.0.0"),
+		Entry("broadcast address", "255.255.255.255"),
+	)
+
+	DescribeTable("nonZeroPorts",
+		func(ports []uint16, expected []uint16) {
+			Expect(nonZeroPorts(ports)).To(Equal(expected))
+		},
+		Entry("nil", nil, nil),
+		Entry("only padding", []uint16{0, 0, 0}, nil),
+		Entry("ports with padding", []uint16{80, 0, 443, 0}, []uint16{80, 443}),
+	)
+
+	It("should skip empty cidrs", func() {
+		// given
+		cidrs := []Cidr{
+			{Net: mustIPStrToUint32("10.0.0.0"), Mask: 8},
+			{},
+			{Net: 0, Mask: 0},
+			{Net: 0, Mask: 1},
+		}
+
+		// when
+		entries := nonEmptyCidrs(cidrs)
+
+		// then
+		Expect(entries).To(Equal([]CidrEntry{
+			{Net: "10.0.0.0", Mask: 8},
+			{Net: "0.0.0.0", Mask: 1},
+		}))
+		Expect(entries[0].String()).To(Equal("10.0.0.0/8"))
+	})
+
+	It("should build human-readable pod config entry", func() {
+		// given
+		value := &PodConfigValue{
+			StatusPort:       9901,
+			ExcludeOutRanges: []Cidr{{Net: mustIPStrToUint32("10.0.0.0"), Mask: 8}, {}},
+			IncludeOutRanges: make([]Cidr, MaxItemLen),
+			IncludeInPorts:   []uint16{8080, 0},
+			IncludeOutPorts:  make([]uint16, MaxItemLen),
+			ExcludeInPorts:   []uint16{15006, 15010, 15001, 0},
+			ExcludeOutPorts:  []uint16{22, 0},
+		}
+
+		// when
+		entry := NewPodConfigEntry(mustIPStrToUint32("10.244.0.5"), value)
+
+		// then
+		Expect(entry).To(Equal(PodConfigEntry{
+			InstanceIP:       "10.244.0.5",
+			StatusPort:       9901,
+			ExcludeOutRanges: []CidrEntry{{Net: "10.0.0.0", Mask: 8}},
+			IncludeInPorts:   []uint16{8080},
+			ExcludeInPorts:   []uint16{15006, 15010, 15001},
+			ExcludeOutPorts:  []uint16{22},
+		}))
+	})
+
+	It("should omit empty lists in JSON", func() {
+		// given
+		inspection := &Inspection{
+			BPFFSPath:   "/run/kuma/bpf",
+			LocalPodIPs: []PodConfigEntry{{InstanceIP: "10.244.0.5", StatusPort: 9901}},
+		}
+
+		// when
+		data, err := inspection.JSON()
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(MatchJSON(`{
+			"bpffsPath": "/run/kuma/bpf",
+			"localPodIPs": [{"instanceIP": "10.244.0.5", "statusPort": 9901}],
+			"maps": null,
+			"pinnedPrograms": null,
+			"loadedPrograms": null
+		}`))
+	})
+
+	It("should ignore files which are not pinned objects", func() {
+		// given
+		dir := GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(dir, "tc", "globals"), 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "tc", "globals", "local_pod_ips"), nil, 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "connect"), nil, 0o600)).To(Succeed())
+
+		var found []string
+
+		// when
+		err := walkPinnedObjects(
+			dir,
+			func(_ *ciliumebpf.Map, pinPath string) { found = append(found, pinPath) },
+			func(_ *ciliumebpf.Program, pinPath string) { found = append(found, pinPath) },
+		)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeEmpty())
+	})
+
+	It("should fail walking not existing bpf file system", func() {
+		// when
+		err := walkPinnedObjects(
+			filepath.Join(GinkgoT().TempDir(), "nonexistent"),
+			func(*ciliumebpf.Map, string) {},
+			func(*ciliumebpf.Program, string) {},
+		)
+
+		// then
+		Expect(err).To(HaveOccurred())
+	})
+})
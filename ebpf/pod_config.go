@@ -0,0 +1,312 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	ciliumebpf "github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+)
+
+// PodConfigLayout describes the memory layout of the local_pod_ips map value
+// (struct pod_config in merbridge). All known layouts differ only with
+// the maximal amount of items (ports, IP ranges) per list, which in merbridge
+// is controlled by the MAX_ITEM_LEN macro
+type PodConfigLayout struct {
+	Name       string
+	MaxItemLen int
+}
+
+// nativeEndian is the byte order of the host, as map values are being read
+// by eBPF programs without any conversions
+var nativeEndian = func() binary.ByteOrder {
+	value := uint16(1)
+	if *(*byte)(unsafe.Pointer(&value)) == 1 {
+		return binary.LittleEndian
+	}
+
+	return binary.BigEndian
+}()
+
+// cidrSize is the size of the Cidr struct (4 bytes of network,
+// 1 byte of mask and 3 bytes of padding)
+const cidrSize = uint32(unsafe.Sizeof(Cidr{}))
+
+// ValueSize returns the size of the map value in bytes:
+//
+//	StatusPort:                     2 bytes
+//	pad:                            2 bytes
+//	ExcludeOutRanges (n x Cidr):    n*8 bytes
+//	IncludeOutRanges (n x Cidr):    n*8 bytes
+//	IncludeInPorts   (n x 2 bytes): n*2 bytes
+//	IncludeOutPorts  (n x 2 bytes): n*2 bytes
+//	ExcludeInPorts   (n x 2 bytes): n*2 bytes
+//	ExcludeOutPorts  (n x 2 bytes): n*2 bytes
+func (l PodConfigLayout) ValueSize() uint32 {
+	n := uint32(l.MaxItemLen)
+
+	return 4 + 2*n*cidrSize + 4*n*2
+}
+
+func (l PodConfigLayout) String() string {
+	return fmt.Sprintf("%s (max %d items, %d bytes)", l.Name, l.MaxItemLen, l.ValueSize())
+}
+
+// KnownPodConfigLayouts are all the layouts of the map value we know how
+// to encode and decode. If merbridge will change MAX_ITEM_LEN, the new layout
+// should be added here
+var KnownPodConfigLayouts = []PodConfigLayout{
+	{Name: "merbridge-max-item-len-10", MaxItemLen: MaxItemLen},
+}
+
+// PodConfigValue is a layout independent representation of the map value
+type PodConfigValue struct {
+	StatusPort       uint16
+	ExcludeOutRanges []Cidr
+	IncludeOutRanges []Cidr
+	IncludeInPorts   []uint16
+	IncludeOutPorts  []uint16
+	ExcludeInPorts   []uint16
+	ExcludeOutPorts  []uint16
+}
+
+func writeCidrs(buf *bytes.Buffer, name string, cidrs []Cidr, maxItemLen int) error {
+	if len(cidrs) > maxItemLen {
+		return fmt.Errorf("maximal allowed amount of %s (%d) exceeded (%d): %+v",
+			name, maxItemLen, len(cidrs), cidrs)
+	}
+
+	padded := make([]Cidr, maxItemLen)
+	copy(padded, cidrs)
+
+	return binary.Write(buf, nativeEndian, padded)
+}
+
+func writePorts(buf *bytes.Buffer, name string, ports []uint16, maxItemLen int) error {
+	if len(ports) > maxItemLen {
+		return fmt.Errorf("maximal allowed amount of %s (%d) exceeded (%d): %+v",
+			name, maxItemLen, len(ports), ports)
+	}
+
+	padded := make([]uint16, maxItemLen)
+	copy(padded, ports)
+
+	return binary.Write(buf, nativeEndian, padded)
+}
+
+// Encode returns the map value for provided PodConfigValue, or error if
+// any of the lists contains more items than allowed by the layout
+func (l PodConfigLayout) Encode(value *PodConfigValue) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	if err := binary.Write(buf, nativeEndian, [2]uint16{value.StatusPort}); err != nil {
+		return nil, err
+	}
+
+	for _, w := range []func() error{
+		func() error {
+			return writeCidrs(buf, "exclude outbound ranges", value.ExcludeOutRanges, l.MaxItemLen)
+		},
+		func() error {
+			return writeCidrs(buf, "include outbound ranges", value.IncludeOutRanges, l.MaxItemLen)
+		},
+		func() error {
+			return writePorts(buf, "include inbound ports", value.IncludeInPorts, l.MaxItemLen)
+		},
+		func() error {
+			return writePorts(buf, "include outbound ports", value.IncludeOutPorts, l.MaxItemLen)
+		},
+		func() error {
+			return writePorts(buf, "exclude inbound ports", value.ExcludeInPorts, l.MaxItemLen)
+		},
+		func() error {
+			return writePorts(buf, "exclude outbound ports", value.ExcludeOutPorts, l.MaxItemLen)
+		},
+	} {
+		if err := w(); err != nil {
+			return nil, fmt.Errorf("encoding pod config (layout: %s) failed: %v", l, err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode parses provided map value
+func (l PodConfigLayout) Decode(data []byte) (*PodConfigValue, error) {
+	if uint32(len(data)) != l.ValueSize() {
+		return nil, fmt.Errorf("value size (%d bytes) doesn't match the layout %s",
+			len(data), l)
+	}
+
+	var statusPort [2]uint16
+	excludeOutRanges := make([]Cidr, l.MaxItemLen)
+	includeOutRanges := make([]Cidr, l.MaxItemLen)
+	includeInPorts := make([]uint16, l.MaxItemLen)
+	includeOutPorts := make([]uint16, l.MaxItemLen)
+	excludeInPorts := make([]uint16, l.MaxItemLen)
+	excludeOutPorts := make([]uint16, l.MaxItemLen)
+
+	reader := bytes.NewReader(data)
+	for _, field := range []interface{}{
+		&statusPort,
+		excludeOutRanges,
+		includeOutRanges,
+		includeInPorts,
+		includeOutPorts,
+		excludeInPorts,
+		excludeOutPorts,
+	} {
+		if err := binary.Read(reader, nativeEndian, field); err != nil {
+			return nil, fmt.Errorf("decoding pod config (layout: %s) failed: %v", l, err)
+		}
+	}
+
+	return &PodConfigValue{
+		StatusPort:       statusPort[0],
+		ExcludeOutRanges: excludeOutRanges,
+		IncludeOutRanges: includeOutRanges,
+		IncludeInPorts:   includeInPorts,
+		IncludeOutPorts:  includeOutPorts,
+		ExcludeInPorts:   excludeInPorts,
+		ExcludeOutPorts:  excludeOutPorts,
+	}, nil
+}
+
+// bpfMapInfo mirrors the beginning of struct bpf_map_info from
+// include/uapi/linux/bpf.h, as cilium/ebpf is not exposing BTF related fields
+type bpfMapInfo struct {
+	Type                  uint32
+	ID                    uint32
+	KeySize               uint32
+	ValueSize             uint32
+	MaxEntries            uint32
+	MapFlags              uint32
+	Name                  [unix.BPF_OBJ_NAME_LEN]byte
+	Ifindex               uint32
+	BtfVmlinuxValueTypeID uint32
+	NetnsDev              uint64
+	NetnsIno              uint64
+	BtfID                 uint32
+	BtfKeyTypeID          uint32
+	BtfValueTypeID        uint32
+}
+
+type bpfObjGetInfoByFDAttr struct {
+	BpfFd   uint32
+	InfoLen uint32
+	Info    uint64
+}
+
+func getMapInfo(m *ciliumebpf.Map) (*bpfMapInfo, error) {
+	info := &bpfMapInfo{}
+	attr := bpfObjGetInfoByFDAttr{
+		BpfFd:   uint32(m.FD()),
+		InfoLen: uint32(unsafe.Sizeof(*info)),
+		Info:    uint64(uintptr(unsafe.Pointer(info))),
+	}
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_BPF,
+		unix.BPF_OBJ_GET_INFO_BY_FD,
+		uintptr(unsafe.Pointer(&attr)),
+		unsafe.Sizeof(attr),
+	); errno != 0 {
+		return nil, fmt.Errorf("getting map info failed: %v", errno)
+	}
+
+	runtime.KeepAlive(info)
+
+	return info, nil
+}
+
+// maxItemLenFromBTF returns the length of exclude_out_ranges array from
+// the map's value BTF, or 0 if the map has no BTF information
+func maxItemLenFromBTF(m *ciliumebpf.Map) (int, error) {
+	info, err := getMapInfo(m)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.BtfID == 0 || info.BtfValueTypeID == 0 {
+		return 0, nil
+	}
+
+	handle, err := btf.NewHandleFromID(btf.ID(info.BtfID))
+	if err != nil {
+		return 0, fmt.Errorf("getting map btf handle failed: %v", err)
+	}
+	defer handle.Close()
+
+	spec, err := handle.Spec(nil)
+	if err != nil {
+		return 0, fmt.Errorf("getting map btf spec failed: %v", err)
+	}
+
+	typ, err := spec.TypeByID(btf.TypeID(info.BtfValueTypeID))
+	if err != nil {
+		return 0, fmt.Errorf("getting map value btf type failed: %v", err)
+	}
+
+	value, ok := btf.UnderlyingType(typ).(*btf.Struct)
+	if !ok {
+		return 0, fmt.Errorf("map value btf type is not a struct: %s", typ)
+	}
+
+	for _, member := range value.Members {
+		if member.Name != "exclude_out_ranges" {
+			continue
+		}
+
+		array, ok := btf.UnderlyingType(member.Type).(*btf.Array)
+		if !ok {
+			return 0, fmt.Errorf("exclude_out_ranges btf type is not an array: %s", member.Type)
+		}
+
+		return int(array.Nelems), nil
+	}
+
+	return 0, fmt.Errorf("exclude_out_ranges not found in map value btf type: %s", typ)
+}
+
+// DetectPodConfigLayout will select one of KnownPodConfigLayouts matching
+// the value of provided (local_pod_ips) map. If the map has BTF information
+// it will be used to find MAX_ITEM_LEN, otherwise the layout will be selected
+// by the value size. Unknown layouts will result in error, as writing
+// misaligned structs into the map would silently break the redirection
+func DetectPodConfigLayout(m *ciliumebpf.Map) (PodConfigLayout, error) {
+	maxItemLen, err := maxItemLenFromBTF(m)
+	if err != nil {
+		return PodConfigLayout{}, fmt.Errorf("reading local_pod_ips map btf failed: %v", err)
+	}
+
+	var known []string
+
+	for _, layout := range KnownPodConfigLayouts {
+		known = append(known, layout.String())
+
+		if layout.ValueSize() != m.ValueSize() {
+			continue
+		}
+
+		if maxItemLen != 0 && maxItemLen != layout.MaxItemLen {
+			continue
+		}
+
+		return layout, nil
+	}
+
+	return PodConfigLayout{}, fmt.Errorf(
+		"unknown local_pod_ips map value layout (value size: %d bytes, "+
+			"max item len from btf: %d), known layouts:\n\t%s",
+		m.ValueSize(),
+		maxItemLen,
+		strings.Join(known, "\n\t"),
+	)
+}
@@ -0,0 +1,101 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// legacyPodConfig is the fixed 244 bytes layout of the map value, which was
+// used before layouts were detected at runtime
+type legacyPodConfig struct {
+	StatusPort       uint16
+	_                uint16 // pad
+	ExcludeOutRanges [10]Cidr
+	IncludeOutRanges [10]Cidr
+	IncludeInPorts   [10]uint16
+	IncludeOutPorts  [10]uint16
+	ExcludeInPorts   [10]uint16
+	ExcludeOutPorts  [10]uint16
+}
+
+var _ = Describe("PodConfigLayout", func() {
+	layout := KnownPodConfigLayouts[0]
+
+	value := &PodConfigValue{
+		StatusPort:       9901,
+		ExcludeOutRanges: []Cidr{{Net: 0x0a000000, Mask: 8}},
+		IncludeOutRanges: []Cidr{{Net: 0xc0a80000, Mask: 16}, {Net: 0x7f000001, Mask: 32}},
+		IncludeInPorts:   []uint16{8080},
+		IncludeOutPorts:  []uint16{443, 80},
+		ExcludeInPorts:   []uint16{15006, 15010, 15001},
+		ExcludeOutPorts:  []uint16{22},
+	}
+
+	legacy := legacyPodConfig{
+		StatusPort:       9901,
+		ExcludeOutRanges: [10]Cidr{{Net: 0x0a000000, Mask: 8}},
+		IncludeOutRanges: [10]Cidr{{Net: 0xc0a80000, Mask: 16}, {Net: 0x7f000001, Mask: 32}},
+		IncludeInPorts:   [10]uint16{8080},
+		IncludeOutPorts:  [10]uint16{443, 80},
+		ExcludeInPorts:   [10]uint16{15006, 15010, 15001},
+		ExcludeOutPorts:  [10]uint16{22},
+	}
+
+	It("should have the size of the legacy layout", func() {
+		Expect(layout.ValueSize()).To(BeEquivalentTo(244))
+		Expect(binary.Size(legacy)).To(Equal(244))
+	})
+
+	It("should encode values the same as the legacy layout", func() {
+		// given
+		expected := &bytes.Buffer{}
+		Expect(binary.Write(expected, nativeEndian, legacy)).To(Succeed())
+
+		// when
+		encoded, err := layout.Encode(value)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encoded).To(Equal(expected.Bytes()))
+	})
+
+	It("should decode encoded values", func() {
+		// given
+		encoded, err := layout.Encode(value)
+		Expect(err).ToNot(HaveOccurred())
+
+		// when
+		decoded, err := layout.Decode(encoded)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded.StatusPort).To(Equal(legacy.StatusPort))
+		Expect(decoded.ExcludeOutRanges).To(Equal(legacy.ExcludeOutRanges[:]))
+		Expect(decoded.IncludeOutRanges).To(Equal(legacy.IncludeOutRanges[:]))
+		Expect(decoded.IncludeInPorts).To(Equal(legacy.IncludeInPorts[:]))
+		Expect(decoded.IncludeOutPorts).To(Equal(legacy.IncludeOutPorts[:]))
+		Expect(decoded.ExcludeInPorts).To(Equal(legacy.ExcludeInPorts[:]))
+		Expect(decoded.ExcludeOutPorts).To(Equal(legacy.ExcludeOutPorts[:]))
+	})
+
+	It("should fail to encode more items than the layout allows", func() {
+		// when
+		_, err := layout.Encode(&PodConfigValue{ExcludeOutPorts: make([]uint16, 11)})
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring(
+			"maximal allowed amount of exclude outbound ports (10) exceeded (11)",
+		)))
+	})
+
+	It("should fail to decode values of a different size", func() {
+		// when
+		_, err := layout.Decode(make([]byte, 243))
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring("value size (243 bytes) doesn't match the layout")))
+	})
+})
@@ -0,0 +1,198 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	ciliumebpf "github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// requiredProgramTypes are types of the programs loaded by merbridge
+// (connect, sendmsg, recvmsg and get_sockopts are cgroup sock_addr
+// and sockopt programs, sockops, redir (sk_msg) and tc classifiers)
+var requiredProgramTypes = []ciliumebpf.ProgramType{
+	ciliumebpf.CGroupSockAddr,
+	ciliumebpf.CGroupSockopt,
+	ciliumebpf.SockOps,
+	ciliumebpf.SkMsg,
+	ciliumebpf.SchedCLS,
+}
+
+// requiredMapTypes are types of the maps created by merbridge
+var requiredMapTypes = []ciliumebpf.MapType{
+	ciliumebpf.Hash,
+	ciliumebpf.LRUHash,
+	ciliumebpf.SockHash,
+}
+
+type ProbeCheck struct {
+	Name      string `json:"name"`
+	Supported bool   `json:"supported"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type ProbeResult struct {
+	Checks []ProbeCheck `json:"checks"`
+}
+
+// Supported returns true only if all the checks succeeded
+func (r *ProbeResult) Supported() bool {
+	for _, check := range r.Checks {
+		if !check.Supported {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reasons returns reasons of all failed checks
+func (r *ProbeResult) Reasons() []string {
+	var reasons []string
+
+	for _, check := range r.Checks {
+		if !check.Supported {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", check.Name, check.Reason))
+		}
+	}
+
+	return reasons
+}
+
+func (r *ProbeResult) add(name string, err error) {
+	check := ProbeCheck{Name: name, Supported: err == nil}
+	if err != nil {
+		check.Reason = err.Error()
+	}
+
+	r.Checks = append(r.Checks, check)
+}
+
+func featureErr(err error) error {
+	if errors.Is(err, ciliumebpf.ErrNotSupported) {
+		return fmt.Errorf("not supported by the kernel")
+	}
+
+	return err
+}
+
+// effectiveCapabilities parses CapEff from /proc/self/status
+func effectiveCapabilities() (uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "CapEff:"); value != scanner.Text() {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("CapEff not found in /proc/self/status")
+}
+
+func hasCapability(caps uint64, capability int) bool {
+	return caps&(1<<uint(capability)) != 0
+}
+
+func probeCapabilities(result *ProbeResult) uint64 {
+	caps, err := effectiveCapabilities()
+	if err != nil {
+		result.add("capabilities", fmt.Errorf("reading effective capabilities failed: %v", err))
+		return 0
+	}
+
+	// CAP_BPF was introduced in kernel 5.8, before that CAP_SYS_ADMIN
+	// was necessary to load programs and create maps
+	var bpfErr error
+	if !hasCapability(caps, unix.CAP_BPF) && !hasCapability(caps, unix.CAP_SYS_ADMIN) {
+		bpfErr = fmt.Errorf("neither CAP_BPF nor CAP_SYS_ADMIN is present")
+	}
+	result.add("capability CAP_BPF", bpfErr)
+
+	var netAdminErr error
+	if !hasCapability(caps, unix.CAP_NET_ADMIN) {
+		netAdminErr = fmt.Errorf("CAP_NET_ADMIN is not present")
+	}
+	result.add("capability CAP_NET_ADMIN", netAdminErr)
+
+	return caps
+}
+
+func probeBPFFS(fsPath string, caps uint64) error {
+	var statfs unix.Statfs_t
+
+	if err := unix.Statfs(fsPath, &statfs); err != nil {
+		return fmt.Errorf("checking file system of %s failed: %v", fsPath, err)
+	}
+
+	if statfs.Type == unix.BPF_FS_MAGIC {
+		return nil
+	}
+
+	isEmpty, err := isDirEmpty(fsPath)
+	if err != nil {
+		return fmt.Errorf("checking if %s is empty failed: %v", fsPath, err)
+	}
+
+	// InitBPFFSMaybe won't try to mount bpf file system in non-empty directory
+	if !isEmpty {
+		return fmt.Errorf("%s is not a bpf file system and is not empty", fsPath)
+	}
+
+	if !hasCapability(caps, unix.CAP_SYS_ADMIN) {
+		return fmt.Errorf("%s is not a bpf file system, and it cannot be mounted "+
+			"without CAP_SYS_ADMIN", fsPath)
+	}
+
+	return nil
+}
+
+// Probe checks if the kernel and the current process are able to run
+// the transparent proxy in the eBPF mode
+func Probe(cfg config.Config) *ProbeResult {
+	result := &ProbeResult{}
+
+	var rootErr error
+	if os.Getuid() != 0 {
+		rootErr = fmt.Errorf("root user in required for this process or container")
+	}
+	result.add("root user", rootErr)
+
+	caps := probeCapabilities(result)
+
+	for _, programType := range requiredProgramTypes {
+		result.add(
+			fmt.Sprintf("program type %s", programType),
+			featureErr(features.HaveProgramType(programType)),
+		)
+	}
+
+	for _, mapType := range requiredMapTypes {
+		result.add(
+			fmt.Sprintf("map type %s", mapType),
+			featureErr(features.HaveMapType(mapType)),
+		)
+	}
+
+	result.add("bpf file system", probeBPFFS(cfg.Ebpf.BPFFSPath, caps))
+
+	return result
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package ebpf
+
+import (
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+type ProbeCheck struct {
+	Name      string `json:"name"`
+	Supported bool   `json:"supported"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type ProbeResult struct {
+	Checks []ProbeCheck `json:"checks"`
+}
+
+func (r *ProbeResult) Supported() bool {
+	return false
+}
+
+func (r *ProbeResult) Reasons() []string {
+	return []string{"operating system: ebpf is currently supported only on linux"}
+}
+
+func Probe(config.Config) *ProbeResult {
+	return &ProbeResult{Checks: []ProbeCheck{{
+		Name:   "operating system",
+		Reason: "ebpf is currently supported only on linux",
+	}}}
+}
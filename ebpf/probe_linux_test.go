@@ -0,0 +1,109 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ciliumebpf "github.com/cilium/ebpf"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("probe", func() {
+	Describe("ProbeResult", func() {
+		It("should be supported without checks", func() {
+			result := &ProbeResult{}
+
+			Expect(result.Supported()).To(BeTrue())
+			Expect(result.Reasons()).To(BeEmpty())
+		})
+
+		It("should return reasons of failed checks", func() {
+			// given
+			result := &ProbeResult{}
+
+			// when
+			result.add("root user", nil)
+			result.add("map type SockHash", fmt.Errorf("not supported by the kernel"))
+			result.add("bpf file system", fmt.Errorf("/run/kuma/bpf is not a bpf file system and is not empty"))
+
+			// then
+			Expect(result.Supported()).To(BeFalse())
+			Expect(result.Checks).To(Equal([]ProbeCheck{
+				{Name: "root user", Supported: true},
+				{Name: "map type SockHash", Reason: "not supported by the kernel"},
+				{Name: "bpf file system", Reason: "/run/kuma/bpf is not a bpf file system and is not empty"},
+			}))
+			Expect(result.Reasons()).To(Equal([]string{
+				"map type SockHash: not supported by the kernel",
+				"bpf file system: /run/kuma/bpf is not a bpf file system and is not empty",
+			}))
+		})
+	})
+
+	DescribeTable("featureErr",
+		func(err error, expected string) {
+			Expect(featureErr(err)).To(MatchError(expected))
+		},
+		Entry("not supported", ciliumebpf.ErrNotSupported, "not supported by the kernel"),
+		Entry("wrapped not supported",
+			fmt.Errorf("program type: %w", ciliumebpf.ErrNotSupported),
+			"not supported by the kernel",
+		),
+		Entry("other error", errors.New("permission denied"), "permission denied"),
+	)
+
+	It("should not wrap nil feature error", func() {
+		Expect(featureErr(nil)).To(Succeed())
+	})
+
+	DescribeTable("hasCapability",
+		func(caps uint64, capability int, expected bool) {
+			Expect(hasCapability(caps, capability)).To(Equal(expected))
+		},
+		Entry("no capabilities", uint64(0), unix.CAP_NET_ADMIN, false),
+		Entry("only CAP_NET_ADMIN", uint64(1)<<unix.CAP_NET_ADMIN, unix.CAP_NET_ADMIN, true),
+		Entry("only CAP_NET_ADMIN, checking CAP_BPF", uint64(1)<<unix.CAP_NET_ADMIN, unix.CAP_BPF, false),
+		Entry("all capabilities", ^uint64(0), unix.CAP_BPF, true),
+	)
+
+	DescribeTable("probeBPFFS for not bpf file systems",
+		func(files []string, caps uint64, expected string) {
+			// given
+			dir := GinkgoT().TempDir()
+			for _, file := range files {
+				Expect(os.WriteFile(filepath.Join(dir, file), nil, 0o600)).To(Succeed())
+			}
+
+			// when
+			err := probeBPFFS(dir, caps)
+
+			// then
+			if expected == "" {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expected)))
+			}
+		},
+		Entry("empty directory, which can be mounted",
+			nil, uint64(1)<<unix.CAP_SYS_ADMIN, "",
+		),
+		Entry("empty directory without CAP_SYS_ADMIN",
+			nil, uint64(1)<<unix.CAP_BPF, "cannot be mounted without CAP_SYS_ADMIN",
+		),
+		Entry("not empty directory",
+			[]string{"connect"}, uint64(1)<<unix.CAP_SYS_ADMIN, "is not a bpf file system and is not empty",
+		),
+	)
+
+	It("should fail probing not existing bpf file system", func() {
+		// when
+		err := probeBPFFS(filepath.Join(GinkgoT().TempDir(), "nonexistent"), ^uint64(0))
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring("checking file system of")))
+	})
+})
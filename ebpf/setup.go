@@ -5,6 +5,7 @@ package ebpf
 import (
 	"fmt"
 	"os"
+	"path"
 
 	ciliumebpf "github.com/cilium/ebpf"
 
@@ -16,34 +17,91 @@ var programs = []*Program{
 		PinName:          "connect",
 		MakeLoadTarget:   "load-connect",
 		MakeAttachTarget: "attach-connect",
+		AttachPoint:      "cgroup/connect4",
 	},
 	{
 		PinName:          "sockops",
 		MakeLoadTarget:   "load-sockops",
 		MakeAttachTarget: "attach-sockops",
+		AttachPoint:      "cgroup/sock_ops",
 	},
 	{
 		PinName:          "get_sockopts",
 		MakeLoadTarget:   "load-getsock",
 		MakeAttachTarget: "attach-getsock",
+		AttachPoint:      "cgroup/getsockopt",
 	},
 	{
 		PinName:          "redir",
 		MakeLoadTarget:   "load-redir",
 		MakeAttachTarget: "attach-redir",
+		AttachPoint:      "sk_msg/sock_pair_map",
 	},
 	{
 		PinName:          "sendmsg",
 		MakeLoadTarget:   "load-sendmsg",
 		MakeAttachTarget: "attach-sendmsg",
+		AttachPoint:      "cgroup/sendmsg4",
 	},
 	{
 		PinName:          "recvmsg",
 		MakeLoadTarget:   "load-recvmsg",
 		MakeAttachTarget: "attach-recvmsg",
+		AttachPoint:      "cgroup/recvmsg4",
 	},
 }
 
+// expandPortRanges appends to provided ports all the ports from provided
+// ranges, as eBPF programs are matching only single ports. Ranges expanding
+// to more ports than the layout allows (together with already provided ones,
+// i.e. redirect ports) are rejected
+func expandPortRanges(
+	name string,
+	ports []uint16,
+	portRanges []config.PortRange,
+	maxItemLen int,
+) ([]uint16, error) {
+	reserved := len(ports)
+
+	for _, portRange := range portRanges {
+		if err := portRange.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+
+		if len(ports)+int(portRange.To-portRange.From)+1 > maxItemLen {
+			var taken string
+			if reserved > 0 {
+				taken = fmt.Sprintf(" (%d of %d items are taken by redirect ports)",
+					reserved, maxItemLen)
+			}
+
+			return nil, fmt.Errorf("%s (%v) cannot be expanded to more than %d "+
+				"single ports supported by eBPF programs%s",
+				name, portRanges, maxItemLen-reserved, taken)
+		}
+
+		for port := int(portRange.From); port <= int(portRange.To); port++ {
+			ports = append(ports, uint16(port))
+		}
+	}
+
+	return ports, nil
+}
+
+// Installed returns true if any of the eBPF programs loaded by Setup is pinned
+// in the BPF file system
+func Installed(cfg config.Config) (bool, error) {
+	for _, p := range programs {
+		if _, err := os.Stat(path.Join(cfg.Ebpf.BPFFSPath, p.PinName)); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
 func Setup(cfg config.Config) (string, error) {
 	if os.Getuid() != 0 {
 		return "", fmt.Errorf("root user in required for this process or container")
@@ -78,42 +136,54 @@ func Setup(cfg config.Config) (string, error) {
 		return "", err
 	}
 
+	layout, err := DetectPodConfigLayout(localPodIPsMap)
+	if err != nil {
+		return "", err
+	}
+
 	// exclude inbound ports
 
-	excludeInboundPorts := [MaxItemLen]uint16{
+	excludeInboundPorts := []uint16{
 		cfg.Redirect.Inbound.Port,
 		cfg.Redirect.Inbound.PortIPv6,
 		cfg.Redirect.Outbound.Port,
 	}
 
-	allowedAmountOfExcludeInPorts := MaxItemLen - len(excludeInboundPorts)
+	if cfg.Redirect.Outbound.PortIPv6 != 0 {
+		excludeInboundPorts = append(excludeInboundPorts, cfg.Redirect.Outbound.PortIPv6)
+	}
 
-	if len(cfg.Redirect.Inbound.ExcludePorts) > allowedAmountOfExcludeInPorts {
-		return "", fmt.Errorf(
-			"maximal allowed amound of exclude inbound ports (%d) exceeded (%d): %+v",
-			allowedAmountOfExcludeInPorts,
-			len(cfg.Redirect.Inbound.ExcludePorts),
-			cfg.Redirect.Inbound.ExcludePorts,
-		)
+	excludeInboundPorts, err = expandPortRanges(
+		"exclude inbound ports",
+		excludeInboundPorts,
+		cfg.Redirect.Inbound.GetExcludePortRanges(),
+		layout.MaxItemLen,
+	)
+	if err != nil {
+		return "", err
 	}
 
 	// exclude outbound ports
 
-	excludeOutPorts := [MaxItemLen]uint16{}
-
-	if len(cfg.Redirect.Outbound.ExcludePorts) > MaxItemLen {
-		return "", fmt.Errorf(
-			"maximal allowed amound of exclude outbound ports (%d) exceeded (%d): %+v",
-			MaxItemLen,
-			len(cfg.Redirect.Outbound.ExcludePorts),
-			cfg.Redirect.Outbound.ExcludePorts,
-		)
+	excludeOutPorts, err := expandPortRanges(
+		"exclude outbound ports",
+		nil,
+		cfg.Redirect.Outbound.GetExcludePortRanges(),
+		layout.MaxItemLen,
+	)
+	if err != nil {
+		return "", err
 	}
 
-	if err := localPodIPsMap.Update(ip, &PodConfig{
+	podConfig, err := layout.Encode(&PodConfigValue{
 		ExcludeInPorts:  excludeInboundPorts,
 		ExcludeOutPorts: excludeOutPorts,
-	}, ciliumebpf.UpdateAny); err != nil {
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := localPodIPsMap.Update(ip, podConfig, ciliumebpf.UpdateAny); err != nil {
 		return "", fmt.Errorf(
 			"updating pinned local_pod_ips map with current instance IP (%s) failed: %v",
 			cfg.Ebpf.InstanceIP,
@@ -11,3 +11,7 @@ import (
 func Setup(config.Config) (string, error) {
 	return "", fmt.Errorf("ebpf is currently supported only on linux")
 }
+
+func Installed(config.Config) (bool, error) {
+	return false, nil
+}
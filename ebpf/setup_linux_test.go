@@ -0,0 +1,68 @@
+package ebpf
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("expandPortRanges", func() {
+	redirectPorts := []uint16{15006, 15010, 15001}
+
+	It("should append expanded ranges to redirect ports", func() {
+		// when
+		ports, err := expandPortRanges("exclude inbound ports", redirectPorts, []config.PortRange{
+			{From: 22, To: 22},
+			{From: 8080, To: 8082},
+		}, MaxItemLen)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ports).To(Equal([]uint16{15006, 15010, 15001, 22, 8080, 8081, 8082}))
+	})
+
+	It("should fill the whole layout when there are no redirect ports", func() {
+		// when
+		ports, err := expandPortRanges("exclude outbound ports", nil, []config.PortRange{
+			{From: 9000, To: 9009},
+		}, MaxItemLen)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ports).To(HaveLen(MaxItemLen))
+	})
+
+	It("should count redirect ports", func() {
+		// when
+		_, err := expandPortRanges("exclude inbound ports", redirectPorts, []config.PortRange{
+			{From: 9000, To: 9007},
+		}, MaxItemLen)
+
+		// then
+		Expect(err).To(MatchError("exclude inbound ports ([9000-9007]) cannot be " +
+			"expanded to more than 7 single ports supported by eBPF programs " +
+			"(3 of 10 items are taken by redirect ports)"))
+	})
+
+	It("should fail for too many ports without redirect ports", func() {
+		// when
+		_, err := expandPortRanges("exclude outbound ports", nil, []config.PortRange{
+			{From: 9000, To: 9010},
+		}, MaxItemLen)
+
+		// then
+		Expect(err).To(MatchError("exclude outbound ports ([9000-9010]) cannot be " +
+			"expanded to more than 10 single ports supported by eBPF programs"))
+	})
+
+	It("should fail for invalid range", func() {
+		// when
+		_, err := expandPortRanges("exclude outbound ports", nil, []config.PortRange{
+			{From: 2, To: 1},
+		}, MaxItemLen)
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring("invalid exclude outbound ports")))
+	})
+})
@@ -0,0 +1,268 @@
+package firewalld
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/kumahq/kuma-net/internal/restore"
+)
+
+// As specified in https://firewalld.org/documentation/man-pages/firewalld.dbus.html
+
+const (
+	dbusDestination           = "org.fedoraproject.FirewallD1"
+	dbusPath                  = "/org/fedoraproject/FirewallD1"
+	dbusConfigPath            = "/org/fedoraproject/FirewallD1/config"
+	dbusDirectInterface       = "org.fedoraproject.FirewallD1.direct"
+	dbusConfigDirectInterface = "org.fedoraproject.FirewallD1.config.direct"
+
+	// errors returned by firewalld when the chain or rule is already
+	// present (when adding) or is missing (when removing)
+	dbusErrAlreadyEnabled = "ALREADY_ENABLED"
+	dbusErrNotEnabled     = "NOT_ENABLED"
+)
+
+// DirectClient is the part of the firewalld direct D-Bus interface which
+// is necessary to apply chains and rules without reloading firewalld.
+// When permanent is true, the permanent configuration will be used instead
+// of the runtime one
+type DirectClient interface {
+	GetAllChains(permanent bool) ([]*Chain, error)
+	GetAllRules(permanent bool) ([]*Rule, error)
+	AddChain(permanent bool, chain *Chain) error
+	RemoveChain(permanent bool, chain *Chain) error
+	AddRule(permanent bool, rule *Rule) error
+	RemoveRule(permanent bool, rule *Rule) error
+}
+
+type dbusChain struct {
+	IPv   string
+	Table string
+	Chain string
+}
+
+type dbusRule struct {
+	IPv      string
+	Table    string
+	Chain    string
+	Priority int32
+	Args     []string
+}
+
+type DBusDirectClient struct {
+	conn *dbus.Conn
+}
+
+var _ DirectClient = &DBusDirectClient{}
+
+// NewDBusDirectClient returns DirectClient talking with firewalld over
+// the system bus
+func NewDBusDirectClient() (*DBusDirectClient, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the system bus failed: %v", err)
+	}
+
+	return &DBusDirectClient{conn: conn}, nil
+}
+
+func (c *DBusDirectClient) call(permanent bool, method string, args ...interface{}) *dbus.Call {
+	path, iface := dbusPath, dbusDirectInterface
+	if permanent {
+		path, iface = dbusConfigPath, dbusConfigDirectInterface
+	}
+
+	return c.conn.Object(dbusDestination, dbus.ObjectPath(path)).
+		Call(iface+"."+method, 0, args...)
+}
+
+// ignoreFirewalldError returns nil if provided error is a firewalld
+// exception with the given code
+func ignoreFirewalldError(err error, code string) error {
+	if dbusErr, ok := err.(dbus.Error); ok && len(dbusErr.Body) > 0 {
+		if message, ok := dbusErr.Body[0].(string); ok && strings.HasPrefix(message, code) {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (c *DBusDirectClient) GetAllChains(permanent bool) ([]*Chain, error) {
+	var chains []dbusChain
+
+	if err := c.call(permanent, "getAllChains").Store(&chains); err != nil {
+		return nil, fmt.Errorf("getting firewalld direct chains failed: %v", err)
+	}
+
+	var result []*Chain
+	for _, c := range chains {
+		result = append(result, NewChain(c.IPv, c.Table, c.Chain))
+	}
+
+	return result, nil
+}
+
+func (c *DBusDirectClient) GetAllRules(permanent bool) ([]*Rule, error) {
+	var rules []dbusRule
+
+	if err := c.call(permanent, "getAllRules").Store(&rules); err != nil {
+		return nil, fmt.Errorf("getting firewalld direct rules failed: %v", err)
+	}
+
+	var result []*Rule
+	for _, r := range rules {
+		result = append(result, NewRule(r.IPv, r.Table, int(r.Priority), r.Chain, restore.JoinArgs(r.Args)))
+	}
+
+	return result, nil
+}
+
+func (c *DBusDirectClient) AddChain(permanent bool, chain *Chain) error {
+	err := c.call(permanent, "addChain", chain.IPv, chain.Table, chain.Chain).Err
+	if err = ignoreFirewalldError(err, dbusErrAlreadyEnabled); err != nil {
+		return fmt.Errorf("adding firewalld direct chain %s failed: %v", chain, err)
+	}
+
+	return nil
+}
+
+func (c *DBusDirectClient) RemoveChain(permanent bool, chain *Chain) error {
+	err := c.call(permanent, "removeChain", chain.IPv, chain.Table, chain.Chain).Err
+	if err = ignoreFirewalldError(err, dbusErrNotEnabled); err != nil {
+		return fmt.Errorf("removing firewalld direct chain %s failed: %v", chain, err)
+	}
+
+	return nil
+}
+
+func (c *DBusDirectClient) AddRule(permanent bool, rule *Rule) error {
+	args, err := restore.Tokenize(rule.Body)
+	if err != nil {
+		return fmt.Errorf("adding firewalld direct rule %s failed: %v", rule, err)
+	}
+
+	err = c.call(permanent, "addRule", rule.IPv, rule.Table, rule.Chain, int32(rule.Priority), args).Err
+	if err = ignoreFirewalldError(err, dbusErrAlreadyEnabled); err != nil {
+		return fmt.Errorf("adding firewalld direct rule %s failed: %v", rule, err)
+	}
+
+	return nil
+}
+
+func (c *DBusDirectClient) RemoveRule(permanent bool, rule *Rule) error {
+	args, err := restore.Tokenize(rule.Body)
+	if err != nil {
+		return fmt.Errorf("removing firewalld direct rule %s failed: %v", rule, err)
+	}
+
+	err = c.call(permanent, "removeRule", rule.IPv, rule.Table, rule.Chain, int32(rule.Priority), args).Err
+	if err = ignoreFirewalldError(err, dbusErrNotEnabled); err != nil {
+		return fmt.Errorf("removing firewalld direct rule %s failed: %v", rule, err)
+	}
+
+	return nil
+}
+
+// getDirect returns chains and rules from the runtime or permanent
+// configuration of firewalld
+func getDirect(client DirectClient, permanent bool) (*Direct, error) {
+	chains, err := client.GetAllChains(permanent)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := client.GetAllRules(permanent)
+	if err != nil {
+		return nil, err
+	}
+
+	direct := NewDirect(rules...)
+	direct.Chains = chains
+
+	return direct, nil
+}
+
+// applyDirect adds missing chains and rules from provided direct to the runtime
+// or permanent configuration of firewalld. Existing rules with different
+// priorities are re-added, as firewalld is identifying rules by priorities
+func applyDirect(client DirectClient, permanent bool, direct *Direct) error {
+	current, err := getDirect(client, permanent)
+	if err != nil {
+		return err
+	}
+
+	for _, chain := range direct.Chains {
+		if containsChain(current.Chains, chain) {
+			continue
+		}
+
+		if err := client.AddChain(permanent, chain); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range direct.Rules {
+		if existing := findRuleIgnoringPriority(current.Rules, rule); existing != nil {
+			if existing.Priority == rule.Priority {
+				continue
+			}
+
+			if err := client.RemoveRule(permanent, existing); err != nil {
+				return err
+			}
+		}
+
+		if err := client.AddRule(permanent, rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeDirect removes from the runtime or permanent configuration of firewalld
+// provided chains and rules the same way as Direct.Remove, and returns
+// the resulting configuration. If dryRun is true no changes are being made
+func removeDirect(
+	client DirectClient,
+	permanent bool,
+	owned *Direct,
+	commentPrefix string,
+	dryRun bool,
+) (*Direct, error) {
+	current, err := getDirect(client, permanent)
+	if err != nil {
+		return nil, err
+	}
+
+	result := NewDirect(current.Rules...)
+	result.Chains = current.Chains
+	result.Remove(owned.Chains, owned.Rules, commentPrefix)
+
+	if dryRun {
+		return result, nil
+	}
+
+	// rules have to be removed first, as firewalld won't remove a chain
+	// which is still referenced
+	for _, rule := range current.Rules {
+		if findRuleIgnoringPriority(result.Rules, rule) == nil {
+			if err := client.RemoveRule(permanent, rule); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, chain := range current.Chains {
+		if !containsChain(result.Chains, chain) {
+			if err := client.RemoveChain(permanent, chain); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
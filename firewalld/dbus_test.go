@@ -0,0 +1,553 @@
+package firewalld
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+)
+
+// fakeDirectClient mimics firewalld, which identifies rules by all their
+// attributes including priority, and is refusing to add duplicates
+type fakeDirectClient struct {
+	runtime   *Direct
+	permanent *Direct
+	changes   int
+}
+
+func newFakeDirectClient(direct *Direct) *fakeDirectClient {
+	clone := func() *Direct {
+		result := NewDirect()
+
+		for _, c := range direct.Chains {
+			result.Chains = append(result.Chains, NewChain(c.IPv, c.Table, c.Chain))
+		}
+
+		for _, r := range direct.Rules {
+			result.Rules = append(result.Rules, NewRule(r.IPv, r.Table, r.Priority, r.Chain, r.Body))
+		}
+
+		return result
+	}
+
+	return &fakeDirectClient{runtime: clone(), permanent: clone()}
+}
+
+func (f *fakeDirectClient) direct(permanent bool) *Direct {
+	if permanent {
+		return f.permanent
+	}
+
+	return f.runtime
+}
+
+func (f *fakeDirectClient) GetAllChains(permanent bool) ([]*Chain, error) {
+	return append([]*Chain{}, f.direct(permanent).Chains...), nil
+}
+
+func (f *fakeDirectClient) GetAllRules(permanent bool) ([]*Rule, error) {
+	return append([]*Rule{}, f.direct(permanent).Rules...), nil
+}
+
+func (f *fakeDirectClient) AddChain(permanent bool, chain *Chain) error {
+	direct := f.direct(permanent)
+
+	if containsChain(direct.Chains, chain) {
+		return fmt.Errorf("%s: chain %s", dbusErrAlreadyEnabled, chain.Chain)
+	}
+
+	f.changes++
+	direct.Chains = append(direct.Chains, chain)
+
+	return nil
+}
+
+func (f *fakeDirectClient) RemoveChain(permanent bool, chain *Chain) error {
+	direct := f.direct(permanent)
+
+	for i, c := range direct.Chains {
+		if c.IPv == chain.IPv && c.Table == chain.Table && c.Chain == chain.Chain {
+			f.changes++
+			direct.Chains = append(direct.Chains[:i], direct.Chains[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s: chain %s", dbusErrNotEnabled, chain.Chain)
+}
+
+func (f *fakeDirectClient) AddRule(permanent bool, rule *Rule) error {
+	direct := f.direct(permanent)
+
+	for _, r := range direct.Rules {
+		if *r == *rule {
+			return fmt.Errorf("%s: rule %s", dbusErrAlreadyEnabled, rule.Body)
+		}
+	}
+
+	f.changes++
+	direct.Rules = append(direct.Rules, rule)
+
+	return nil
+}
+
+func (f *fakeDirectClient) RemoveRule(permanent bool, rule *Rule) error {
+	direct := f.direct(permanent)
+
+	for i, r := range direct.Rules {
+		if *r == *rule {
+			f.changes++
+			direct.Rules = append(direct.Rules[:i], direct.Rules[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s: rule %s", dbusErrNotEnabled, rule.Body)
+}
+
+// fakeFirewalldDirect is the firewalld direct object exported on the D-Bus
+// connection, which records calls and identifies rules by all their
+// attributes including priority (as firewalld does)
+type fakeFirewalldDirect struct {
+	mu     sync.Mutex
+	rules  []dbusRule
+	chains []dbusChain
+}
+
+// fakeFirewalldDirectMethods maps methods of fakeFirewalldDirect to names
+// of methods of the firewalld direct interface
+var fakeFirewalldDirectMethods = map[string]string{
+	"GetAllChains": "getAllChains",
+	"GetAllRules":  "getAllRules",
+	"AddRule":      "addRule",
+	"RemoveRule":   "removeRule",
+}
+
+func fakeFirewalldException(code string, rule dbusRule) *dbus.Error {
+	return dbus.NewError("org.fedoraproject.FirewallD1.Exception",
+		[]interface{}{fmt.Sprintf("%s: '%s'", code, strings.Join(rule.Args, " "))})
+}
+
+func (f *fakeFirewalldDirect) GetAllChains() ([]dbusChain, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.chains, nil
+}
+
+func (f *fakeFirewalldDirect) GetAllRules() ([]dbusRule, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.rules, nil
+}
+
+func (f *fakeFirewalldDirect) AddRule(ipv, table, chain string, priority int32, args []string) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rule := dbusRule{IPv: ipv, Table: table, Chain: chain, Priority: priority, Args: args}
+	for _, r := range f.rules {
+		if reflect.DeepEqual(r, rule) {
+			return fakeFirewalldException(dbusErrAlreadyEnabled, rule)
+		}
+	}
+
+	f.rules = append(f.rules, rule)
+
+	return nil
+}
+
+func (f *fakeFirewalldDirect) RemoveRule(ipv, table, chain string, priority int32, args []string) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rule := dbusRule{IPv: ipv, Table: table, Chain: chain, Priority: priority, Args: args}
+	for i, r := range f.rules {
+		if reflect.DeepEqual(r, rule) {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+
+	return fakeFirewalldException(dbusErrNotEnabled, rule)
+}
+
+// serveAnonymousAuth plays the server side of the D-Bus authentication
+// of the client using the ANONYMOUS mechanism, and returns the reader
+// of messages sent after it
+func serveAnonymousAuth(conn net.Conn) (*bufio.Reader, error) {
+	reader := bufio.NewReader(conn)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		var reply string
+
+		switch fields := strings.Fields(strings.TrimLeft(line, "\x00")); {
+		case len(fields) == 0:
+			return nil, fmt.Errorf("unexpected authentication line %q", line)
+		case fields[0] == "BEGIN":
+			return reader, nil
+		case len(fields) == 1 && fields[0] == "AUTH":
+			reply = "REJECTED ANONYMOUS"
+		case len(fields) > 1 && fields[0] == "AUTH" && fields[1] == "ANONYMOUS":
+			reply = "OK 0123456789abcdef0123456789abcdef"
+		default:
+			reply = "ERROR"
+		}
+
+		if _, err := conn.Write([]byte(reply + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// newPeerConns returns two D-Bus connections talking directly with each
+// other (without the message bus), so objects exported on the second one
+// (created with provided options) can be called from the first one
+func newPeerConns(opts ...dbus.ConnOption) (*dbus.Conn, *dbus.Conn) {
+	var conns []*dbus.Conn
+	var peers []net.Conn
+
+	for i := 0; i < 2; i++ {
+		var connOpts []dbus.ConnOption
+		if i == 1 {
+			connOpts = opts
+		}
+
+		local, peer := net.Pipe()
+		conn, err := dbus.NewConn(local, connOpts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		conns = append(conns, conn)
+		peers = append(peers, peer)
+	}
+
+	readers := make([]*bufio.Reader, 2)
+	errs := make(chan error, 4)
+
+	for i := range conns {
+		i := i
+
+		go func() {
+			errs <- conns[i].Auth([]dbus.Auth{dbus.AuthAnonymous()})
+		}()
+
+		go func() {
+			var err error
+			readers[i], err = serveAnonymousAuth(peers[i])
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		Expect(<-errs).ToNot(HaveOccurred())
+	}
+
+	go func() { _, _ = io.Copy(peers[1], readers[0]) }()
+	go func() { _, _ = io.Copy(peers[0], readers[1]) }()
+
+	DeferCleanup(func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	return conns[0], conns[1]
+}
+
+var _ = Describe("firewalld D-Bus direct interface", func() {
+	It("should apply rules to the runtime and permanent configuration", func() {
+		// given
+		rules, err := os.ReadFile(path.Join("testdata", "full_direct.input.txt"))
+		Expect(err).To(Succeed())
+		client := newFakeDirectClient(NewDirect())
+		translator := NewIptablesTranslator().WithDirectClient(client)
+
+		// when
+		_, err = translator.StoreRules(string(rules))
+
+		// then
+		Expect(err).To(Succeed())
+		Expect(client.runtime.String()).To(MatchGoldenXML("testdata", "full_direct.golden.xml"))
+		Expect(client.permanent.String()).To(MatchGoldenXML("testdata", "full_direct.golden.xml"))
+
+		// when
+		changes := client.changes
+		_, err = translator.StoreRules(string(rules))
+
+		// then
+		Expect(err).To(Succeed())
+		Expect(client.changes).To(Equal(changes))
+	})
+
+	It("should re-add existing rules with different priorities", func() {
+		// given
+		rules := "* nat\n-A OUTPUT -j RETURN\n-A OUTPUT -p tcp -j KUMA_MESH_OUTBOUND\nCOMMIT\n"
+		client := newFakeDirectClient(NewDirect(
+			NewIP4Rule("nat", 7, "OUTPUT", "-p tcp -j KUMA_MESH_OUTBOUND"),
+		))
+
+		// when
+		_, err := NewIptablesTranslator().WithDirectClient(client).StoreRules(rules)
+
+		// then
+		Expect(err).To(Succeed())
+		for _, direct := range []*Direct{client.runtime, client.permanent} {
+			Expect(direct.Rules).To(ConsistOf(
+				NewIP4Rule("nat", 0, "OUTPUT", "-j RETURN"),
+				NewIP4Rule("nat", 1, "OUTPUT", "-p tcp -j KUMA_MESH_OUTBOUND"),
+			))
+		}
+	})
+
+	It("should place inserted rules before foreign rules", func() {
+		// given
+		rules := "* nat\n" +
+			"-A OUTPUT -p tcp -j KUMA_MESH_OUTBOUND\n" +
+			"-I OUTPUT 1 -p udp --dport 53 -j REDIRECT --to-ports 15053\n" +
+			"COMMIT\n"
+		foreign := NewIP4Rule("nat", 0, "OUTPUT", "-j DOCKER_OUTPUT")
+		client := newFakeDirectClient(NewDirect(foreign))
+
+		// when
+		_, err := NewIptablesTranslator().WithDirectClient(client).StoreRules(rules)
+
+		// then
+		Expect(err).To(Succeed())
+		for _, direct := range []*Direct{client.runtime, client.permanent} {
+			Expect(direct.Rules).To(ConsistOf(
+				foreign,
+				NewIP4Rule("nat", -1, "OUTPUT", "-p udp --dport 53 -j REDIRECT --to-ports 15053"),
+				NewIP4Rule("nat", 0, "OUTPUT", "-p tcp -j KUMA_MESH_OUTBOUND"),
+			))
+		}
+	})
+
+	It("should remove only kuma-owned entries", func() {
+		// given
+		rules, err := os.ReadFile(path.Join("testdata", "full_direct.input.txt"))
+		Expect(err).To(Succeed())
+		existing, err := os.ReadFile(path.Join("testdata", "remove_rules_direct.existing.xml"))
+		Expect(err).To(Succeed())
+		direct := NewDirect()
+		Expect(xml.Unmarshal(existing, direct)).To(Succeed())
+		client := newFakeDirectClient(direct)
+
+		// when
+		_, err = NewIptablesTranslator().WithDirectClient(client).RemoveRules(string(rules))
+
+		// then
+		Expect(err).To(Succeed())
+		Expect(client.runtime.String()).To(MatchGoldenXML("testdata", "remove_rules_direct.golden.xml"))
+		Expect(client.permanent.String()).To(MatchGoldenXML("testdata", "remove_rules_direct.golden.xml"))
+	})
+
+	It("should not change anything in dry run mode", func() {
+		// given
+		rules, err := os.ReadFile(path.Join("testdata", "full_direct.input.txt"))
+		Expect(err).To(Succeed())
+		existing, err := os.ReadFile(path.Join("testdata", "remove_rules_direct.existing.xml"))
+		Expect(err).To(Succeed())
+		direct := NewDirect()
+		Expect(xml.Unmarshal(existing, direct)).To(Succeed())
+		client := newFakeDirectClient(direct)
+		translator := NewIptablesTranslator().WithDryRun(true).WithDirectClient(client)
+
+		// when
+		_, storeErr := translator.StoreRules(string(rules))
+		removed, removeErr := translator.RemoveRules(string(rules))
+
+		// then
+		Expect(storeErr).To(Succeed())
+		Expect(removeErr).To(Succeed())
+		Expect(removed).To(MatchGoldenXML("testdata", "remove_rules_direct.golden.xml"))
+		Expect(client.changes).To(Equal(0))
+	})
+})
+
+var _ = Describe("ignoreFirewalldError", func() {
+	firewalldError := func(message string) error {
+		return dbus.Error{
+			Name: "org.fedoraproject.FirewallD1.Exception",
+			Body: []interface{}{message},
+		}
+	}
+
+	DescribeTable("should ignore firewalld exceptions with provided code",
+		func(err error, code string, ignored bool) {
+			if ignored {
+				Expect(ignoreFirewalldError(err, code)).To(Succeed())
+			} else {
+				Expect(ignoreFirewalldError(err, code)).To(MatchError(err))
+			}
+		},
+		Entry("already enabled",
+			firewalldError("ALREADY_ENABLED: rule '-j RETURN' already is in 'ipv4:nat:OUTPUT'"),
+			dbusErrAlreadyEnabled, true,
+		),
+		Entry("not enabled",
+			firewalldError("NOT_ENABLED: rule '-j RETURN' is not in 'ipv4:nat:OUTPUT'"),
+			dbusErrNotEnabled, true,
+		),
+		Entry("different code",
+			firewalldError("NOT_ENABLED: rule '-j RETURN' is not in 'ipv4:nat:OUTPUT'"),
+			dbusErrAlreadyEnabled, false,
+		),
+		Entry("code not at the beginning of the message",
+			firewalldError("INVALID_RULE: ALREADY_ENABLED"),
+			dbusErrAlreadyEnabled, false,
+		),
+		Entry("exception without a message",
+			dbus.Error{Name: "org.fedoraproject.FirewallD1.Exception"},
+			dbusErrAlreadyEnabled, false,
+		),
+		Entry("exception with not a string message",
+			dbus.Error{Name: "org.fedoraproject.FirewallD1.Exception", Body: []interface{}{1}},
+			dbusErrAlreadyEnabled, false,
+		),
+		Entry("not a D-Bus error",
+			fmt.Errorf("ALREADY_ENABLED"),
+			dbusErrAlreadyEnabled, false,
+		),
+	)
+
+	It("should not change nil error", func() {
+		Expect(ignoreFirewalldError(nil, dbusErrNotEnabled)).To(Succeed())
+	})
+})
+
+var _ = Describe("DBusDirectClient", func() {
+	var runtime, permanent *fakeFirewalldDirect
+	var client *DBusDirectClient
+	var signatures sync.Map
+
+	BeforeEach(func() {
+		conn, firewalld := newPeerConns(dbus.WithIncomingInterceptor(func(msg *dbus.Message) {
+			if member, ok := msg.Headers[dbus.FieldMember].Value().(string); ok {
+				signatures.Store(member, dbus.SignatureOf(msg.Body...).String())
+			}
+		}))
+		runtime = &fakeFirewalldDirect{
+			chains: []dbusChain{{IPv: IPv4, Table: "nat", Chain: "KUMA_MESH_OUTBOUND"}},
+		}
+		permanent = &fakeFirewalldDirect{}
+
+		Expect(firewalld.ExportWithMap(runtime, fakeFirewalldDirectMethods,
+			dbusPath, dbusDirectInterface)).To(Succeed())
+		Expect(firewalld.ExportWithMap(permanent, fakeFirewalldDirectMethods,
+			dbusConfigPath, dbusConfigDirectInterface)).To(Succeed())
+
+		client = &DBusDirectClient{conn: conn}
+	})
+
+	It("should add, get and remove rules of the runtime configuration", func() {
+		// given
+		rule := NewRule(IPv4, "nat", 1, "OUTPUT",
+			`-p tcp -m comment --comment "kuma mesh/outbound" -j KUMA_MESH_OUTBOUND`)
+
+		// when
+		err := client.AddRule(false, rule)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runtime.rules).To(Equal([]dbusRule{{
+			IPv:      IPv4,
+			Table:    "nat",
+			Chain:    "OUTPUT",
+			Priority: 1,
+			Args:     []string{"-p", "tcp", "-m", "comment", "--comment", "kuma mesh/outbound", "-j", "KUMA_MESH_OUTBOUND"},
+		}}))
+		Expect(permanent.rules).To(BeEmpty())
+
+		// when
+		rules, err := client.GetAllRules(false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rules).To(Equal([]*Rule{rule}))
+
+		// when
+		err = client.RemoveRule(false, rule)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runtime.rules).To(BeEmpty())
+	})
+
+	It("should use the permanent configuration", func() {
+		// given
+		rule := NewRule(IPv6, "mangle", 0, "PREROUTING", "-m conntrack --ctstate INVALID -j DROP")
+
+		// when
+		err := client.AddRule(true, rule)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runtime.rules).To(BeEmpty())
+		Expect(permanent.rules).To(HaveLen(1))
+
+		// when
+		rules, err := client.GetAllRules(true)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rules).To(Equal([]*Rule{rule}))
+	})
+
+	It("should get chains", func() {
+		// when
+		chains, err := client.GetAllChains(false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(chains).To(Equal([]*Chain{NewChain(IPv4, "nat", "KUMA_MESH_OUTBOUND")}))
+	})
+
+	It("should ignore already added and already removed rules", func() {
+		// given
+		rule := NewRule(IPv4, "nat", 0, "OUTPUT", "-j RETURN")
+		Expect(client.AddRule(false, rule)).To(Succeed())
+
+		// expect
+		Expect(client.AddRule(false, rule)).To(Succeed())
+		Expect(client.RemoveRule(false, rule)).To(Succeed())
+		Expect(client.RemoveRule(false, rule)).To(Succeed())
+		Expect(runtime.rules).To(BeEmpty())
+	})
+
+	It("should call methods with signatures of the firewalld direct interface", func() {
+		// given
+		rule := NewRule(IPv4, "nat", 2, "OUTPUT", "-j RETURN")
+
+		// when
+		Expect(client.AddRule(false, rule)).To(Succeed())
+		Expect(client.RemoveRule(false, rule)).To(Succeed())
+
+		// then
+		addRule, _ := signatures.Load("addRule")
+		removeRule, _ := signatures.Load("removeRule")
+		Expect(addRule).To(Equal("sssias"))
+		Expect(removeRule).To(Equal("sssias"))
+		Expect(dbus.SignatureOf([]dbusRule{}).String()).To(Equal("a(sssias)"))
+		Expect(dbus.SignatureOf([]dbusChain{}).String()).To(Equal("a(sss)"))
+	})
+})
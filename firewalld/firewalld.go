@@ -1,35 +1,24 @@
 package firewalld
 
 import (
-	"bufio"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"os"
-	"regexp"
-	"strconv"
-	"strings"
 )
 
 // As specified in https://firewalld.org/documentation/man-pages/firewalld.direct.html
 
 const (
 	defaultFirewalldDirectPath = "/etc/firewalld/direct.xml"
-	defaultRulenum             = 3
 )
 
-type IptablesRule struct {
-	Mode          string
-	Chain         string
-	Rulenum       int
-	Specification string
-}
-
 type IptablesTranslator struct {
 	dryRun         bool
 	output         io.Writer
 	directFilePath string
-	ruleParser     *regexp.Regexp
+	directClient   DirectClient
+	commentPrefix  string
 }
 
 func (t *IptablesTranslator) WithDirectFilePath(filePath string) *IptablesTranslator {
@@ -38,6 +27,26 @@ func (t *IptablesTranslator) WithDirectFilePath(filePath string) *IptablesTransl
 	return t
 }
 
+// WithDirectClient makes the translator apply chains and rules through
+// the firewalld D-Bus direct interface (both to the runtime and permanent
+// configuration) instead of writing the direct.xml file, so firewalld
+// doesn't have to be reloaded
+func (t *IptablesTranslator) WithDirectClient(client DirectClient) *IptablesTranslator {
+	t.directClient = client
+
+	return t
+}
+
+// WithCommentPrefix makes the translator remove also the rules tagged with
+// the comment starting with provided prefix (i.e. "kuma"), so rules
+// in built-in chains stored with a different configuration (ports, excluded
+// addresses etc.) are removed as well
+func (t *IptablesTranslator) WithCommentPrefix(prefix string) *IptablesTranslator {
+	t.commentPrefix = prefix
+
+	return t
+}
+
 func (t *IptablesTranslator) WithDryRun(dryRun bool) *IptablesTranslator {
 	t.dryRun = dryRun
 
@@ -50,82 +59,391 @@ func (t *IptablesTranslator) WithOutput(output io.Writer) *IptablesTranslator {
 	return t
 }
 
-func filterOutEmptyAndCommentLines(lines []string) []string {
-	var result []string
+// StoreRules translates provided iptables-restore input into IPv4 direct
+// chains and rules, and stores them in the direct.xml file
+func (t *IptablesTranslator) StoreRules(rawIptables string) (string, error) {
+	return t.StoreDualStackRules(rawIptables, "")
+}
 
-	for _, line := range lines {
-		if line != "" && !strings.HasPrefix(line, "#") {
-			result = append(result, line)
-		}
+// StoreIPv6Rules translates provided ip6tables-restore input into IPv6 direct
+// chains and rules, and stores them in the direct.xml file
+func (t *IptablesTranslator) StoreIPv6Rules(rawIp6tables string) (string, error) {
+	return t.StoreDualStackRules("", rawIp6tables)
+}
+
+// StoreDualStackRules translates provided iptables-restore and ip6tables-restore
+// inputs, and stores them in the direct.xml file at once
+func (t *IptablesTranslator) StoreDualStackRules(rawIptables, rawIp6tables string) (string, error) {
+	if t.directClient != nil {
+		return t.applyDualStackRules(rawIptables, rawIp6tables)
+	}
+
+	direct, err := t.getPersistentDirect()
+	if err != nil {
+		return "", err
+	}
+
+	if err := t.translate(direct, IPv4, rawIptables); err != nil {
+		return "", err
 	}
 
-	return result
+	if err := t.translate(direct, IPv6, rawIp6tables); err != nil {
+		return "", err
+	}
+
+	return t.store(direct)
 }
 
-func (t *IptablesTranslator) StoreRules(rawIptables string) (string, error) {
-	direct, err := t.getPersistentDirect()
+// RemoveRules removes from the direct.xml file all chains and rules which
+// would be added by StoreRules for provided iptables-restore input, together
+// with any other rules inside these chains or jumping to them (i.e. rules
+// created by previous installations with different ports), and rules tagged
+// with the comment prefix (see WithCommentPrefix). All other, user-authored
+// entries are preserved
+func (t *IptablesTranslator) RemoveRules(rawIptables string) (string, error) {
+	return t.RemoveDualStackRules(rawIptables, "")
+}
+
+// RemoveIPv6Rules is RemoveRules for ip6tables-restore input
+func (t *IptablesTranslator) RemoveIPv6Rules(rawIp6tables string) (string, error) {
+	return t.RemoveDualStackRules("", rawIp6tables)
+}
+
+// RemoveDualStackRules is RemoveRules for iptables-restore and ip6tables-restore
+// inputs at once
+func (t *IptablesTranslator) RemoveDualStackRules(rawIptables, rawIp6tables string) (string, error) {
+	owned := NewDirect()
+
+	if err := t.translate(owned, IPv4, rawIptables); err != nil {
+		return "", err
+	}
+
+	if err := t.translate(owned, IPv6, rawIp6tables); err != nil {
+		return "", err
+	}
+
+	if t.directClient != nil {
+		return t.removeAppliedDualStackRules(owned)
+	}
+
+	// we want to read the existing direct.xml file even in dry run mode,
+	// as otherwise there is nothing to remove entries from
+	direct, err := t.readDirect()
 	if err != nil {
 		return "", err
 	}
 
-	for table, rawRules := range parseIptablesRawInput(rawIptables) {
-		for _, rawRule := range filterOutEmptyAndCommentLines(rawRules) {
-			rule := t.translateRule(rawRule)
+	direct.Remove(owned.Chains, owned.Rules, t.commentPrefix)
 
-			switch rule.Mode {
-			case "N", "new-chain":
-				direct.AddChain(NewIP4Chain(table, rule.Chain))
-			case "A", "append":
-				direct.AddRule(NewIP4Rule(
-					table,
-					rule.Rulenum,
-					rule.Chain,
-					rule.Specification,
-				))
-			default:
-				return "", fmt.Errorf("unsupported iptables mode [%s]", rule.Mode)
+	return t.store(direct)
+}
+
+func (t *IptablesTranslator) applyDualStackRules(rawIptables, rawIp6tables string) (string, error) {
+	direct := NewDirect()
+
+	if err := t.translate(direct, IPv4, rawIptables); err != nil {
+		return "", err
+	}
+
+	if err := t.translate(direct, IPv6, rawIp6tables); err != nil {
+		return "", err
+	}
+
+	content := "\n\n" + direct.String() + "\n\n"
+
+	if !t.dryRun {
+		for _, permanent := range []bool{false, true} {
+			if err := applyDirect(t.directClient, permanent, direct); err != nil {
+				return direct.String(), err
 			}
 		}
+
+		content += "iptables applied with firewalld (runtime and permanent configuration)" + "\n\n"
 	}
 
-	return t.store(direct)
+	_, _ = t.output.Write([]byte(content))
+
+	return direct.String(), nil
 }
 
-func (t *IptablesTranslator) translateRule(rule string) IptablesRule {
-	var result IptablesRule
+// removeAppliedDualStackRules returns the permanent configuration of firewalld
+// after the removal, as it's the equivalent of the direct.xml file
+func (t *IptablesTranslator) removeAppliedDualStackRules(owned *Direct) (string, error) {
+	var direct *Direct
 
-	match := t.ruleParser.FindStringSubmatch(rule)
+	for _, permanent := range []bool{false, true} {
+		var err error
 
-	for i, name := range t.ruleParser.SubexpNames() {
-		if i != 0 && name != "" {
-			switch name {
-			case "mode":
-				result.Mode = match[i]
-			case "chain":
-				result.Chain = match[i]
-			case "rulenum":
-				rulenum, err := strconv.Atoi(match[i])
-				if err != nil || rulenum == 0 {
-					rulenum = defaultRulenum
+		if direct, err = removeDirect(t.directClient, permanent, owned, t.commentPrefix, t.dryRun); err != nil {
+			return "", err
+		}
+	}
+
+	content := "\n\n" + direct.String() + "\n\n"
+
+	if !t.dryRun {
+		content += "iptables removed from firewalld (runtime and permanent configuration)" + "\n\n"
+	}
+
+	_, _ = t.output.Write([]byte(content))
+
+	return direct.String(), nil
+}
+
+// chainRules keeps the order of the rules inside a single chain, so
+// firewalld priorities can be assigned accordingly (rules with the same
+// priority are placed in the chain in the unspecified order)
+type chainRules struct {
+	table string
+	chain string
+	rules []string
+	// inserted contains specifications of the rules inserted into the chain,
+	// which have to be placed before rules added by others
+	inserted map[string]struct{}
+}
+
+// priorities returns firewalld priorities of the rules, increasing in order
+// of the rules. Inserted rules (and rules preceding them) are getting
+// negative priorities, so they are placed before direct rules added by other
+// tools, which are usually using the default priority 0
+func (c *chainRules) priorities() []int {
+	head := 0
+
+	for i, rule := range c.rules {
+		if _, ok := c.inserted[rule]; ok {
+			head = i + 1
+		}
+	}
+
+	priorities := make([]int, len(c.rules))
+	for i := range c.rules {
+		priorities[i] = i - head
+	}
+
+	return priorities
+}
+
+func (c *chainRules) index(specification string) int {
+	for i, rule := range c.rules {
+		if rule == specification {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *chainRules) append(specification string) {
+	// firewalld is not allowing duplicated rules
+	if c.index(specification) == -1 {
+		c.rules = append(c.rules, specification)
+	}
+}
+
+// insert the rule at provided position (starting from 1, as in iptables)
+func (c *chainRules) insert(rulenum int, specification string) error {
+	if rulenum == 0 {
+		rulenum = 1
+	}
+
+	if rulenum > len(c.rules)+1 {
+		return fmt.Errorf("index of insertion (%d) too big for chain %s with %d rules",
+			rulenum, c.chain, len(c.rules))
+	}
+
+	// the duplicate is moved to the new position, as firewalld is not
+	// allowing duplicated rules, so the chain can become shorter than
+	// the position which was valid before
+	if i := c.index(specification); i != -1 {
+		c.rules = append(c.rules[:i], c.rules[i+1:]...)
+	}
+
+	if rulenum > len(c.rules)+1 {
+		rulenum = len(c.rules) + 1
+	}
+
+	c.rules = append(c.rules[:rulenum-1], append([]string{specification}, c.rules[rulenum-1:]...)...)
+
+	if c.inserted == nil {
+		c.inserted = map[string]struct{}{}
+	}
+	c.inserted[specification] = struct{}{}
+
+	return nil
+}
+
+// delete the rule by its position (starting from 1, as in iptables) or,
+// when rulenum is 0, by its specification
+func (c *chainRules) delete(rulenum int, specification string) error {
+	i := rulenum - 1
+
+	if rulenum == 0 {
+		if i = c.index(specification); i == -1 {
+			return fmt.Errorf("rule %q doesn't exist in chain %s", specification, c.chain)
+		}
+	} else if rulenum > len(c.rules) {
+		return fmt.Errorf("index of deletion (%d) too big for chain %s with %d rules",
+			rulenum, c.chain, len(c.rules))
+	}
+
+	delete(c.inserted, c.rules[i])
+	c.rules = append(c.rules[:i], c.rules[i+1:]...)
+
+	return nil
+}
+
+func getOrAppendChainRules(chains *[]*chainRules, table, chain string) *chainRules {
+	for _, c := range *chains {
+		if c.table == table && c.chain == chain {
+			return c
+		}
+	}
+
+	c := &chainRules{table: table, chain: chain}
+	*chains = append(*chains, c)
+
+	return c
+}
+
+// flushChainRules removes all the rules inside provided chain, or inside all
+// the chains of the table if the chain is empty
+func flushChainRules(chains []*chainRules, table, chain string) {
+	for _, c := range chains {
+		if c.table == table && (chain == "" || c.chain == chain) {
+			c.rules = nil
+			c.inserted = nil
+		}
+	}
+}
+
+// deleteChains removes provided custom chain, or all the custom chains
+// of the table if the chain is empty. As in iptables, chains have to be empty
+// and cannot be referenced by any rule
+func deleteChains(direct *Direct, chains *[]*chainRules, ipv, table, chain string) error {
+	var deleted []*Chain
+	for _, c := range direct.Chains {
+		if c.IPv == ipv && c.Table == table && (chain == "" || c.Chain == chain) {
+			deleted = append(deleted, c)
+		}
+	}
+
+	if chain != "" && len(deleted) == 0 {
+		return fmt.Errorf("chain %s doesn't exist", chain)
+	}
+
+	for _, d := range deleted {
+		for _, c := range *chains {
+			if c.table != table {
+				continue
+			}
+
+			for _, specification := range c.rules {
+				if c.chain == d.Chain {
+					return fmt.Errorf("chain %s is not empty", d.Chain)
 				}
 
-				result.Rulenum = rulenum
-			case "specification":
-				result.Specification = match[i]
+				if (&Rule{Body: specification}).target() == d.Chain {
+					return fmt.Errorf("chain %s is referenced by rules in chain %s", d.Chain, c.chain)
+				}
+			}
+		}
+
+		for _, r := range direct.Rules {
+			if r.IPv != ipv || r.Table != table {
+				continue
+			}
+
+			if r.Chain == d.Chain {
+				return fmt.Errorf("chain %s is not empty", d.Chain)
 			}
+
+			if r.target() == d.Chain {
+				return fmt.Errorf("chain %s is referenced by rules in chain %s", d.Chain, r.Chain)
+			}
+		}
+	}
+
+	direct.Remove(deleted, nil, "")
+
+	var result []*chainRules
+	for _, c := range *chains {
+		if c.table != table || !containsChain(deleted, NewChain(ipv, c.table, c.chain)) {
+			result = append(result, c)
 		}
 	}
 
-	return result
+	*chains = result
+
+	return nil
 }
 
-func (t *IptablesTranslator) getPersistentDirect() (*Direct, error) {
-	result := NewDirect()
+func (t *IptablesTranslator) translate(direct *Direct, ipv, rawIptables string) error {
+	tables, err := parseIptablesRestore(rawIptables)
+	if err != nil {
+		return fmt.Errorf("cannot parse iptables-restore input: %s", err)
+	}
+
+	var chains []*chainRules
 
+	for _, table := range tables {
+		for _, rule := range table.rules {
+			var err error
+
+			switch rule.Mode {
+			case "N":
+				direct.AddChain(NewChain(ipv, table.name, rule.Chain))
+			case "A":
+				getOrAppendChainRules(&chains, table.name, rule.Chain).
+					append(rule.Specification)
+			case "I":
+				err = getOrAppendChainRules(&chains, table.name, rule.Chain).
+					insert(rule.Rulenum, rule.Specification)
+			case "D":
+				err = getOrAppendChainRules(&chains, table.name, rule.Chain).
+					delete(rule.Rulenum, rule.Specification)
+			case "F":
+				flushChainRules(chains, table.name, rule.Chain)
+				direct.Flush(ipv, table.name, rule.Chain)
+			case "X":
+				err = deleteChains(direct, &chains, ipv, table.name, rule.Chain)
+			case "P":
+				err = fmt.Errorf("changing policies of the chains is not " +
+					"supported by the firewalld direct interface")
+			default:
+				err = fmt.Errorf("unsupported iptables mode [%s]", rule.Mode)
+			}
+
+			if err != nil {
+				return fmt.Errorf("line %d: cannot translate %q (table: %s): %s",
+					rule.Line, rule.raw, table.name, err)
+			}
+		}
+	}
+
+	// priorities are assigned per chain, so the order of the rules will be
+	// exactly the same as in the input (see chainRules.priorities)
+	for _, c := range chains {
+		priorities := c.priorities()
+
+		for i, specification := range c.rules {
+			direct.AddRule(NewRule(ipv, c.table, priorities[i], c.chain, specification))
+		}
+	}
+
+	return nil
+}
+
+func (t *IptablesTranslator) getPersistentDirect() (*Direct, error) {
 	if t.dryRun {
-		return result, nil
+		return NewDirect(), nil
 	}
 
+	return t.readDirect()
+}
+
+func (t *IptablesTranslator) readDirect() (*Direct, error) {
+	result := NewDirect()
+
 	if _, err := os.Stat(t.directFilePath); err != nil {
 		if os.IsPermission(err) {
 			return nil, err
@@ -167,43 +485,9 @@ func (t *IptablesTranslator) store(direct *Direct) (string, error) {
 	return direct.String(), nil
 }
 
-func parseIptablesRawInput(input string) map[string][]string {
-	tableParser := regexp.MustCompile(`\* (?P<table>\w*)`)
-	scanner := bufio.NewScanner(strings.NewReader(input))
-	rules := map[string][]string{}
-	table := ""
-
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if strings.Contains(line, "COMMIT") {
-			table = ""
-
-			continue
-		}
-
-		if matches := tableParser.FindStringSubmatch(line); len(matches) > 1 {
-			table = matches[tableParser.SubexpIndex("table")]
-
-			continue
-		}
-
-		// filter out empty and comment lines
-		if table != "" && line != "" && !strings.HasPrefix(line, "#") {
-			rules[table] = append(rules[table], line)
-		}
-	}
-
-	return rules
-}
-
 func NewIptablesTranslator() *IptablesTranslator {
 	return &IptablesTranslator{
 		output:         io.Discard,
 		directFilePath: defaultFirewalldDirectPath,
-		ruleParser: regexp.MustCompile(
-			`--?(?P<mode>[A-Za-z-]+)\s*(?P<chain>\w*)\s*(?P<rulenum>\d+)?\s*(?P<specification>.*)?`,
-		),
 	}
 }
@@ -15,6 +15,19 @@ type testCase struct {
 	goldenFile string
 }
 
+type removeTestCase struct {
+	directFile    string
+	inputFile     string
+	commentPrefix string
+	goldenFile    string
+}
+
+type dualStackTestCase struct {
+	inputFile     string
+	ipv6InputFile string
+	goldenFile    string
+}
+
 var _ = Describe("firewalld", func() {
 	DescribeTable("check xml generation",
 		func(given testCase) {
@@ -36,6 +49,144 @@ var _ = Describe("firewalld", func() {
 			inputFile:  "no_duplicates_direct.input.txt",
 			goldenFile: "no_duplicates_direct.golden.xml",
 		}),
+		Entry("should generate xml with priorities respecting inserted and deleted rules", testCase{
+			inputFile:  "insert_delete_direct.input.txt",
+			goldenFile: "insert_delete_direct.golden.xml",
+		}),
+		Entry("should generate xml moving duplicated inserted rules", testCase{
+			inputFile:  "insert_duplicate_direct.input.txt",
+			goldenFile: "insert_duplicate_direct.golden.xml",
+		}),
+		Entry("should generate xml respecting flushed and deleted chains", testCase{
+			inputFile:  "flush_delete_chain_direct.input.txt",
+			goldenFile: "flush_delete_chain_direct.golden.xml",
+		}),
+		Entry("should generate xml for iptables-save output", testCase{
+			inputFile:  "iptables_save_direct.input.txt",
+			goldenFile: "iptables_save_direct.golden.xml",
+		}),
+	)
+
+	DescribeTable("should fail for unsupported or invalid rules",
+		func(rules string, errorSubstring string) {
+			_, err := NewIptablesTranslator().WithDryRun(true).StoreRules(rules)
+
+			Expect(err).To(MatchError(ContainSubstring(errorSubstring)))
+		},
+		Entry("policy",
+			"* nat\n-P OUTPUT ACCEPT\nCOMMIT\n",
+			"changing policies of the chains is not supported",
+		),
+		Entry("insertion index too big",
+			"* nat\n-A OUTPUT -j RETURN\n-I OUTPUT 3 -j RETURN\nCOMMIT\n",
+			"index of insertion (3) too big for chain OUTPUT with 1 rules",
+		),
+		Entry("deletion of not existing rule",
+			"* nat\n-A OUTPUT -j RETURN\n-D OUTPUT -p tcp -j RETURN\nCOMMIT\n",
+			"rule \"-p tcp -j RETURN\" doesn't exist in chain OUTPUT",
+		),
+		Entry("policy in chain declaration",
+			"*filter\n:INPUT DROP [0:0]\nCOMMIT\n",
+			"line 2: cannot translate \":INPUT DROP [0:0]\" (table: filter): changing policies",
+		),
+		Entry("deletion of not existing chain",
+			"* nat\n-X KUMA_MESH_OUTBOUND\nCOMMIT\n",
+			"line 2: cannot translate \"-X KUMA_MESH_OUTBOUND\" (table: nat): chain KUMA_MESH_OUTBOUND doesn't exist",
+		),
+		Entry("deletion of not empty chain",
+			"* nat\n-N KUMA_MESH_OUTBOUND\n-A KUMA_MESH_OUTBOUND -j RETURN\n-X KUMA_MESH_OUTBOUND\nCOMMIT\n",
+			"chain KUMA_MESH_OUTBOUND is not empty",
+		),
+		Entry("deletion of referenced chain",
+			"* nat\n-N KUMA_MESH_OUTBOUND\n-A OUTPUT -j KUMA_MESH_OUTBOUND\n-X KUMA_MESH_OUTBOUND\nCOMMIT\n",
+			"chain KUMA_MESH_OUTBOUND is referenced by rules in chain OUTPUT",
+		),
+		Entry("flush with unexpected arguments",
+			"* nat\n-F OUTPUT -j RETURN\nCOMMIT\n",
+			"line 2: unexpected arguments after chain name",
+		),
+		Entry("unsupported command",
+			"* nat\n-Z OUTPUT\nCOMMIT\n",
+			"line 2: unsupported iptables command \"-Z\"",
+		),
+		Entry("unterminated quoted string",
+			"* nat\n-A OUTPUT -m comment --comment \"kuma -j RETURN\nCOMMIT\n",
+			"line 2: unterminated quoted string",
+		),
+		Entry("negation without option",
+			"* nat\n-A OUTPUT -j RETURN !\nCOMMIT\n",
+			"line 2: negation (!) is not followed by any option",
+		),
+		Entry("rule outside of a table",
+			"# rules\n-A OUTPUT -j RETURN\n",
+			"line 2: \"-A OUTPUT -j RETURN\" is outside of a table",
+		),
+		Entry("nested table",
+			"* nat\n-A OUTPUT -j RETURN\n* raw\nCOMMIT\n",
+			"line 3: table nat declared in line 1 is not committed",
+		),
+		Entry("missing commit",
+			"* nat\n-A OUTPUT -j RETURN\n",
+			"line 1: table nat is not committed (COMMIT is missing)",
+		),
 	)
 
+	DescribeTable("check xml generation for ipv6",
+		func(given testCase) {
+			rules, err := os.ReadFile(path.Join("testdata", given.inputFile))
+			Expect(err).To(Succeed())
+
+			Expect(NewIptablesTranslator().WithDryRun(true).StoreIPv6Rules(string(rules))).
+				To(MatchGoldenXML("testdata", given.goldenFile))
+		},
+		Entry("should generate xml", testCase{
+			inputFile:  "full_direct_ipv6.input.txt",
+			goldenFile: "full_direct_ipv6.golden.xml",
+		}),
+	)
+
+	DescribeTable("check xml generation for both ip families",
+		func(given dualStackTestCase) {
+			rules, err := os.ReadFile(path.Join("testdata", given.inputFile))
+			Expect(err).To(Succeed())
+
+			ipv6Rules, err := os.ReadFile(path.Join("testdata", given.ipv6InputFile))
+			Expect(err).To(Succeed())
+
+			Expect(NewIptablesTranslator().
+				WithDryRun(true).
+				StoreDualStackRules(string(rules), string(ipv6Rules)),
+			).To(MatchGoldenXML("testdata", given.goldenFile))
+		},
+		Entry("should generate xml", dualStackTestCase{
+			inputFile:     "dual_stack_direct.input.txt",
+			ipv6InputFile: "dual_stack_direct_ipv6.input.txt",
+			goldenFile:    "dual_stack_direct.golden.xml",
+		}),
+	)
+
+	DescribeTable("check removing rules from xml",
+		func(given removeTestCase) {
+			rules, err := os.ReadFile(path.Join("testdata", given.inputFile))
+			Expect(err).To(Succeed())
+
+			Expect(NewIptablesTranslator().
+				WithDryRun(true).
+				WithDirectFilePath(path.Join("testdata", given.directFile)).
+				WithCommentPrefix(given.commentPrefix).
+				RemoveRules(string(rules)),
+			).To(MatchGoldenXML("testdata", given.goldenFile))
+		},
+		Entry("should remove only kuma-owned entries", removeTestCase{
+			directFile: "remove_rules_direct.existing.xml",
+			inputFile:  "full_direct.input.txt",
+			goldenFile: "remove_rules_direct.golden.xml",
+		}),
+		Entry("should remove kuma-owned entries stored with different ports", removeTestCase{
+			directFile:    "remove_rules_different_port_direct.existing.xml",
+			inputFile:     "full_direct.input.txt",
+			commentPrefix: "kuma",
+			goldenFile:    "remove_rules_different_port_direct.golden.xml",
+		}),
+	)
 })
@@ -0,0 +1,308 @@
+package firewalld
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kumahq/kuma-net/internal/restore"
+)
+
+// The parser understands the iptables-restore input format, as produced
+// by iptables-save and by the kuma-net iptables builder:
+//
+//	*nat
+//	:PREROUTING ACCEPT [0:0]
+//	:KUMA_MESH_INBOUND - [0:0]
+//	-N KUMA_MESH_OUTBOUND
+//	-A PREROUTING -p tcp -m comment --comment "kuma inbound" -j KUMA_MESH_INBOUND
+//	COMMIT
+
+// maxChainNameLen is the maximal length of the chain name accepted by iptables
+// (XT_EXTENSION_MAXNAMELEN without the terminating null byte)
+const maxChainNameLen = 28
+
+// iptablesCommands maps short and long versions of supported iptables
+// commands to the short one
+var iptablesCommands = map[string]string{
+	"-A":             "A",
+	"--append":       "A",
+	"-I":             "I",
+	"--insert":       "I",
+	"-D":             "D",
+	"--delete":       "D",
+	"-N":             "N",
+	"--new-chain":    "N",
+	"-P":             "P",
+	"--policy":       "P",
+	"-F":             "F",
+	"--flush":        "F",
+	"-X":             "X",
+	"--delete-chain": "X",
+}
+
+type IptablesRule struct {
+	// Mode is the short version of the iptables command ("A", "I", "D", "N",
+	// "P", "F", "X")
+	Mode string
+	// Chain is empty only for "F" and "X" modes, which are then applied
+	// to all the chains in the table
+	Chain   string
+	Rulenum int
+	// Specification contains matches and target of the rule, or the policy
+	// for "P" mode. Arguments containing whitespaces or quotes are quoted
+	Specification string
+
+	// Line is the number of the line in the input (starting from 1)
+	Line int
+
+	raw string
+}
+
+type tableRules struct {
+	name  string
+	rules []IptablesRule
+}
+
+func getOrAppendTable(tables *[]*tableRules, name string) *tableRules {
+	for _, table := range *tables {
+		if table.name == name {
+			return table
+		}
+	}
+
+	table := &tableRules{name: name}
+	*tables = append(*tables, table)
+
+	return table
+}
+
+func validateChainName(chain string) error {
+	switch {
+	case chain == "":
+		return fmt.Errorf("chain name is missing")
+	case strings.HasPrefix(chain, "-") || strings.HasPrefix(chain, "!"):
+		return fmt.Errorf("invalid chain name %q", chain)
+	case len(chain) > maxChainNameLen:
+		return fmt.Errorf("chain name %q is longer than %d characters", chain, maxChainNameLen)
+	}
+
+	return nil
+}
+
+func validateSpecification(args []string) error {
+	for i, arg := range args {
+		if arg != "!" {
+			continue
+		}
+
+		if i == len(args)-1 {
+			return fmt.Errorf("negation (!) is not followed by any option")
+		}
+
+		if args[i+1] == "!" {
+			return fmt.Errorf("multiple consecutive negations (!) are not allowed")
+		}
+	}
+
+	return nil
+}
+
+// parseRulenum returns the rule number if the first argument is a number
+func parseRulenum(args []string) (int, []string, error) {
+	if len(args) == 0 {
+		return 0, args, nil
+	}
+
+	rulenum, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, args, nil
+	}
+
+	if rulenum < 1 {
+		return 0, nil, fmt.Errorf("invalid rule number %d", rulenum)
+	}
+
+	return rulenum, args[1:], nil
+}
+
+// parseCommand parses lines like "-A PREROUTING -p tcp -j RETURN"
+func parseCommand(args []string) (IptablesRule, error) {
+	mode, ok := iptablesCommands[args[0]]
+	if !ok {
+		return IptablesRule{}, fmt.Errorf("unsupported iptables command %q", args[0])
+	}
+
+	if len(args) < 2 {
+		// flushing and deleting chains without the chain name is applied
+		// to all the chains in the table
+		if mode == "F" || mode == "X" {
+			return IptablesRule{Mode: mode}, nil
+		}
+
+		return IptablesRule{}, fmt.Errorf("chain name is missing")
+	}
+
+	rule := IptablesRule{Mode: mode, Chain: args[1]}
+	if err := validateChainName(rule.Chain); err != nil {
+		return IptablesRule{}, err
+	}
+
+	args = args[2:]
+
+	switch mode {
+	case "N", "F", "X":
+		if len(args) > 0 {
+			return IptablesRule{}, fmt.Errorf("unexpected arguments after chain name: %q", args)
+		}
+	case "P":
+		if len(args) != 1 {
+			return IptablesRule{}, fmt.Errorf("policy requires exactly one argument, got %q", args)
+		}
+	case "I", "D":
+		var err error
+
+		if rule.Rulenum, args, err = parseRulenum(args); err != nil {
+			return IptablesRule{}, err
+		}
+
+		if mode == "D" && rule.Rulenum != 0 && len(args) > 0 {
+			return IptablesRule{}, fmt.Errorf("unexpected arguments after rule number: %q", args)
+		}
+
+		if mode == "D" && rule.Rulenum == 0 && len(args) == 0 {
+			return IptablesRule{}, fmt.Errorf("rule number or specification is missing")
+		}
+	}
+
+	if err := validateSpecification(args); err != nil {
+		return IptablesRule{}, err
+	}
+
+	rule.Specification = restore.JoinArgs(args)
+
+	return rule, nil
+}
+
+// parseChainDeclaration parses lines like ":KUMA_MESH_INBOUND - [0:0]".
+// Declarations of custom chains (with "-" policy) are returned as "N" rules,
+// declarations of built-in chains with policies other than ACCEPT (which is
+// the default one) are returned as "P" rules
+func parseChainDeclaration(args []string) (*IptablesRule, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, fmt.Errorf("chain declaration should be in format " +
+			"\":<chain> <policy> [<packets>:<bytes>]\"")
+	}
+
+	if err := validateChainName(args[0]); err != nil {
+		return nil, err
+	}
+
+	switch args[1] {
+	case "-":
+		return &IptablesRule{Mode: "N", Chain: args[0]}, nil
+	case "ACCEPT":
+		return nil, nil
+	default:
+		return &IptablesRule{Mode: "P", Chain: args[0], Specification: args[1]}, nil
+	}
+}
+
+type restoreParser struct {
+	tables    []*tableRules
+	table     *tableRules
+	tableLine int
+}
+
+func (p *restoreParser) parseLine(lineNumber int, line string) error {
+	switch {
+	case line == "" || strings.HasPrefix(line, "#"):
+		return nil
+	case strings.HasPrefix(line, "*"):
+		name := strings.TrimSpace(line[1:])
+
+		if p.table != nil {
+			return fmt.Errorf("table %s declared in line %d is not committed",
+				p.table.name, p.tableLine)
+		}
+
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("invalid table name %q", name)
+		}
+
+		p.table = getOrAppendTable(&p.tables, name)
+		p.tableLine = lineNumber
+
+		return nil
+	case line == "COMMIT":
+		if p.table == nil {
+			return fmt.Errorf("COMMIT outside of a table")
+		}
+
+		p.table = nil
+
+		return nil
+	case p.table == nil:
+		return fmt.Errorf("%q is outside of a table", line)
+	}
+
+	var rule *IptablesRule
+
+	if strings.HasPrefix(line, ":") {
+		args, err := restore.Tokenize(line[1:])
+		if err != nil {
+			return err
+		}
+
+		if rule, err = parseChainDeclaration(args); err != nil {
+			return err
+		}
+	} else {
+		args, err := restore.Tokenize(line)
+		if err != nil {
+			return err
+		}
+
+		parsed, err := parseCommand(args)
+		if err != nil {
+			return err
+		}
+
+		rule = &parsed
+	}
+
+	if rule != nil {
+		rule.Line = lineNumber
+		rule.raw = line
+		p.table.rules = append(p.table.rules, *rule)
+	}
+
+	return nil
+}
+
+// parseIptablesRestore returns rules grouped by tables in the order
+// of the first occurrence of the table in the input
+func parseIptablesRestore(input string) ([]*tableRules, error) {
+	parser := &restoreParser{}
+
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	scanner.Split(bufio.ScanLines)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if err := parser.parseLine(lineNumber, strings.TrimSpace(scanner.Text())); err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if parser.table != nil {
+		return nil, fmt.Errorf("line %d: table %s is not committed (COMMIT is missing)",
+			parser.tableLine, parser.table.name)
+	}
+
+	return parser.tables, nil
+}
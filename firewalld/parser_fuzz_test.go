@@ -0,0 +1,57 @@
+package firewalld
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kumahq/kuma-net/internal/restore"
+)
+
+func FuzzParseIptablesRestore(f *testing.F) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.input.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, input := range inputs {
+		data, err := os.ReadFile(input)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(string(data))
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tables, err := parseIptablesRestore(input)
+		if err != nil {
+			return
+		}
+
+		for _, table := range tables {
+			for _, rule := range table.rules {
+				// flushing and deleting chains can be applied to the whole table
+				chainOptional := rule.Mode == "F" || rule.Mode == "X"
+
+				if err := validateChainName(rule.Chain); err != nil && !(chainOptional && rule.Chain == "") {
+					t.Fatalf("line %d: invalid chain accepted: %v", rule.Line, err)
+				}
+
+				args, err := restore.Tokenize(rule.Specification)
+				if err != nil {
+					t.Fatalf("line %d: specification %q cannot be tokenized: %v",
+						rule.Line, rule.Specification, err)
+				}
+
+				if restore.JoinArgs(args) != rule.Specification {
+					t.Fatalf("line %d: specification %q is not canonical", rule.Line, rule.Specification)
+				}
+			}
+		}
+
+		// translation of successfully parsed input can fail (i.e. for invalid
+		// rule numbers), but it must not panic
+		_, _ = NewIptablesTranslator().WithDryRun(true).StoreRules(input)
+	})
+}
@@ -3,6 +3,15 @@ package firewalld
 import (
 	"encoding/xml"
 	"reflect"
+	"strings"
+
+	"github.com/kumahq/kuma-net/internal/restore"
+)
+
+// ip families supported by the firewalld direct interface which we are using
+const (
+	IPv4 = "ipv4"
+	IPv6 = "ipv6"
 )
 
 type Chain struct {
@@ -24,9 +33,9 @@ func (c *Chain) String() string {
 	return string(data)
 }
 
-func NewIP4Chain(table, chain string) *Chain {
+func NewChain(ipv, table, chain string) *Chain {
 	c := &Chain{
-		IPv:   "ipv4",
+		IPv:   ipv,
 		Table: table,
 		Chain: chain,
 	}
@@ -34,6 +43,14 @@ func NewIP4Chain(table, chain string) *Chain {
 	return c
 }
 
+func NewIP4Chain(table, chain string) *Chain {
+	return NewChain(IPv4, table, chain)
+}
+
+func NewIP6Chain(table, chain string) *Chain {
+	return NewChain(IPv6, table, chain)
+}
+
 type Rule struct {
 	// required, ip family: "ipv4", "ipv6", "eb"
 	IPv string `xml:"ipv,attr"`
@@ -59,19 +76,27 @@ func (r *Rule) String() string {
 	return string(data)
 }
 
-func NewIP4Rule(table string, priority int, chain, body string) *Rule {
+func NewRule(ipv, table string, priority int, chain, body string) *Rule {
 	return &Rule{
 		Priority: priority,
-		IPv:      "ipv4",
+		IPv:      ipv,
 		Table:    table,
 		Chain:    chain,
 		Body:     body,
 	}
 }
 
+func NewIP4Rule(table string, priority int, chain, body string) *Rule {
+	return NewRule(IPv4, table, priority, chain, body)
+}
+
+func NewIP6Rule(table string, priority int, chain, body string) *Rule {
+	return NewRule(IPv6, table, priority, chain, body)
+}
+
 type Direct struct {
-	Chains []*Chain
-	Rules  []*Rule
+	Chains []*Chain `xml:"chain"`
+	Rules  []*Rule  `xml:"rule"`
 
 	XMLName struct{} `xml:"direct"`
 }
@@ -96,9 +121,12 @@ func (d *Direct) AddChain(chain *Chain) {
 	d.Chains = append(d.Chains, chain)
 }
 
+// AddRule adds provided rule, or if the same rule already exists (possibly
+// with a different priority) it updates its priority
 func (d *Direct) AddRule(rule *Rule) {
 	for _, r := range d.Rules {
-		if reflect.DeepEqual(r, rule) {
+		if containsRuleIgnoringPriority([]*Rule{r}, rule) {
+			r.Priority = rule.Priority
 			return
 		}
 	}
@@ -106,6 +134,125 @@ func (d *Direct) AddRule(rule *Rule) {
 	d.Rules = append(d.Rules, rule)
 }
 
+// Remove will remove provided chains and all the rules which are inside them
+// or are jumping to them, provided rules (compared without priorities
+// as they can differ between installations) and, if commentPrefix is not
+// empty, all the rules tagged with the comment starting with it (i.e. rules
+// in built-in chains created by previous installations with different ports)
+func (d *Direct) Remove(chains []*Chain, rules []*Rule, commentPrefix string) {
+	isRemovedChain := func(ipv, table, chain string) bool {
+		for _, c := range chains {
+			if c.IPv == ipv && c.Table == table && c.Chain == chain {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var resultChains []*Chain
+	for _, c := range d.Chains {
+		if !isRemovedChain(c.IPv, c.Table, c.Chain) {
+			resultChains = append(resultChains, c)
+		}
+	}
+
+	var resultRules []*Rule
+	for _, r := range d.Rules {
+		if isRemovedChain(r.IPv, r.Table, r.Chain) ||
+			isRemovedChain(r.IPv, r.Table, r.target()) ||
+			containsRuleIgnoringPriority(rules, r) ||
+			r.tagged(commentPrefix) {
+			continue
+		}
+
+		resultRules = append(resultRules, r)
+	}
+
+	d.Chains = resultChains
+	d.Rules = resultRules
+}
+
+// Flush removes all the rules inside provided chain, or inside all
+// the chains of the table if the chain is empty
+func (d *Direct) Flush(ipv, table, chain string) {
+	var resultRules []*Rule
+	for _, r := range d.Rules {
+		if r.IPv == ipv && r.Table == table && (chain == "" || r.Chain == chain) {
+			continue
+		}
+
+		resultRules = append(resultRules, r)
+	}
+
+	d.Rules = resultRules
+}
+
+func containsRuleIgnoringPriority(rules []*Rule, rule *Rule) bool {
+	return findRuleIgnoringPriority(rules, rule) != nil
+}
+
+func findRuleIgnoringPriority(rules []*Rule, rule *Rule) *Rule {
+	for _, r := range rules {
+		if r.IPv == rule.IPv &&
+			r.Table == rule.Table &&
+			r.Chain == rule.Chain &&
+			strings.TrimSpace(r.Body) == strings.TrimSpace(rule.Body) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+func containsChain(chains []*Chain, chain *Chain) bool {
+	for _, c := range chains {
+		if c.IPv == chain.IPv && c.Table == chain.Table && c.Chain == chain.Chain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// target returns the target of the rule ("-j, --jump" or "-g, --goto" value)
+func (r *Rule) target() string {
+	fields, err := restore.Tokenize(r.Body)
+	if err != nil {
+		return ""
+	}
+
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "-j", "--jump", "-g", "--goto":
+			return fields[i+1]
+		}
+	}
+
+	return ""
+}
+
+// tagged returns true if the rule has the comment starting with provided
+// prefix (i.e. "kuma/dns/redirect" for the "kuma" prefix)
+func (r *Rule) tagged(commentPrefix string) bool {
+	if commentPrefix == "" {
+		return false
+	}
+
+	fields, err := restore.Tokenize(r.Body)
+	if err != nil {
+		return false
+	}
+
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "--comment" && strings.HasPrefix(fields[i+1], commentPrefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func NewDirect(rules ...*Rule) *Direct {
 	return &Direct{
 		Rules: rules,
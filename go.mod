@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/cilium/ebpf v0.9.1
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/miekg/dns v1.1.50
 	github.com/onsi/ginkgo/v2 v2.1.3
 	github.com/onsi/gomega v1.19.0
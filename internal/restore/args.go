@@ -0,0 +1,74 @@
+// Package restore contains helpers of the iptables-restore format shared
+// by iptables and firewalld packages
+package restore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tokenize splits provided line into arguments the same way iptables-restore
+// does: arguments are separated by whitespaces, double quotes are grouping
+// arguments containing whitespaces and backslash is escaping the next character
+func Tokenize(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg, quoted, escaped := false, false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inArg, escaped = true, true
+		case r == '"':
+			inArg, quoted = true, !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n' || r == '\r'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			inArg = true
+			current.WriteRune(r)
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted string")
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// quoteArg quotes provided argument, if necessary, so it will be tokenized
+// back into the same argument
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\r\"\\") {
+		return arg
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	return `"` + replacer.Replace(arg) + `"`
+}
+
+// JoinArgs is the reverse of Tokenize
+func JoinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
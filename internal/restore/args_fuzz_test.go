@@ -0,0 +1,34 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range []string{
+		`-A OUTPUT -p tcp -j RETURN`,
+		`-A OUTPUT -m comment --comment "kuma \"mesh\" outbound" -j RETURN`,
+		`-A OUTPUT ! -d 127.0.0.1/32 -j RETURN`,
+		`-A OUTPUT -m comment --comment "" -j RETURN`,
+		`-A OUTPUT -m comment --comment a\ b -j RETURN`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		args, err := Tokenize(line)
+		if err != nil {
+			return
+		}
+
+		again, err := Tokenize(JoinArgs(args))
+		if err != nil {
+			t.Fatalf("tokenizing joined arguments %q failed: %v", JoinArgs(args), err)
+		}
+
+		if len(args) != 0 && !reflect.DeepEqual(args, again) {
+			t.Fatalf("arguments changed after joining and tokenizing: %q != %q", args, again)
+		}
+	})
+}
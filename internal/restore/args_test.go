@@ -0,0 +1,60 @@
+package restore_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/internal/restore"
+)
+
+var _ = Describe("Tokenize", func() {
+	DescribeTable("should split rules into arguments",
+		func(line string, expected []string) {
+			Expect(restore.Tokenize(line)).To(Equal(expected))
+		},
+		Entry("simple rule",
+			`-A OUTPUT -p tcp -j RETURN`,
+			[]string{"-A", "OUTPUT", "-p", "tcp", "-j", "RETURN"},
+		),
+		Entry("quoted comment with spaces",
+			`-A OUTPUT -m comment --comment "kuma outbound" -j RETURN`,
+			[]string{"-A", "OUTPUT", "-m", "comment", "--comment", "kuma outbound", "-j", "RETURN"},
+		),
+		Entry("quoted comment with escaped quotes",
+			`-A OUTPUT -m comment --comment "kuma \"mesh\" outbound" -j RETURN`,
+			[]string{"-A", "OUTPUT", "-m", "comment", "--comment", `kuma "mesh" outbound`, "-j", "RETURN"},
+		),
+		Entry("empty quoted comment",
+			`-A OUTPUT -m comment --comment "" -j RETURN`,
+			[]string{"-A", "OUTPUT", "-m", "comment", "--comment", "", "-j", "RETURN"},
+		),
+		Entry("comment with escaped space",
+			`-A OUTPUT -m comment --comment kuma\ outbound -j RETURN`,
+			[]string{"-A", "OUTPUT", "-m", "comment", "--comment", "kuma outbound", "-j", "RETURN"},
+		),
+		Entry("quoted part of the argument",
+			`--comment kuma/"dns redirect"`,
+			[]string{"--comment", "kuma/dns redirect"},
+		),
+		Entry("tabs and multiple spaces",
+			"-A OUTPUT \t -j  RETURN ",
+			[]string{"-A", "OUTPUT", "-j", "RETURN"},
+		),
+	)
+
+	DescribeTable("should fail for invalid rules",
+		func(line string, expected string) {
+			_, err := restore.Tokenize(line)
+
+			Expect(err).To(MatchError(expected))
+		},
+		Entry("unterminated quoted comment",
+			`-A OUTPUT -m comment --comment "kuma -j RETURN`,
+			"unterminated quoted string",
+		),
+		Entry("trailing backslash",
+			`-A OUTPUT -j RETURN \`,
+			"trailing backslash",
+		),
+	)
+})
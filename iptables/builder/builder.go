@@ -19,17 +19,20 @@ type IPTables struct {
 	raw    *table.RawTable
 	nat    *table.NatTable
 	mangle *table.MangleTable
+	filter *table.FilterTable
 }
 
 func newIPTables(
 	raw *table.RawTable,
 	nat *table.NatTable,
 	mangle *table.MangleTable,
+	filter *table.FilterTable,
 ) *IPTables {
 	return &IPTables{
 		raw:    raw,
 		nat:    nat,
 		mangle: mangle,
+		filter: filter,
 	}
 }
 
@@ -51,6 +54,11 @@ func (t *IPTables) Build(verbose bool) string {
 		tables = append(tables, mangle)
 	}
 
+	filter := t.filter.Build(verbose)
+	if filter != "" {
+		tables = append(tables, filter)
+	}
+
 	separator := "\n"
 	if verbose {
 		separator = "\n\n"
@@ -62,16 +70,75 @@ func (t *IPTables) Build(verbose bool) string {
 func BuildIPTables(cfg config.Config, dnsServers []string, ipv6 bool) (string, error) {
 	cfg = config.MergeConfigWithDefaults(cfg)
 
+	if err := cfg.Redirect.ValidateRedirectPorts(); err != nil {
+		return "", err
+	}
+
+	if err := cfg.Redirect.ValidateExcludeInterfaces(); err != nil {
+		return "", err
+	}
+
+	if err := cfg.Comment.Validate(); err != nil {
+		return "", err
+	}
+
+	if err := cfg.Trace.Validate(); err != nil {
+		return "", err
+	}
+
+	if err := cfg.Redirect.EgressLockdown.Validate(cfg.Redirect.Outbound); err != nil {
+		return "", err
+	}
+
+	for _, flow := range []config.TrafficFlow{cfg.Redirect.Inbound, cfg.Redirect.Outbound} {
+		if err := flow.ValidatePortRanges(); err != nil {
+			return "", err
+		}
+
+		if _, err := flow.GetExcludeCIDRs(ipv6); err != nil {
+			return "", err
+		}
+	}
+
+	if cfg.Redirect.IPSets.Enabled {
+		if err := validateIPSetNames(cfg); err != nil {
+			return "", err
+		}
+	}
+
+	if cfg.Redirect.DNS.Enabled && cfg.Redirect.DNS.ConntrackZoneSplit {
+		if err := cfg.Redirect.DNS.ValidateConntrackZones(); err != nil {
+			return "", err
+		}
+	}
+
+	return buildIPTables(cfg, dnsServers, ipv6)
+}
+
+// buildIPTables returns rules for provided (already validated and merged
+// with defaults) configuration
+func buildIPTables(cfg config.Config, dnsServers []string, ipv6 bool) (string, error) {
 	loopbackIface, err := getLoopback()
 	if err != nil {
 		return "", fmt.Errorf("cannot obtain loopback interface: %s", err)
 	}
 
-	return newIPTables(
-		buildRawTable(cfg, dnsServers),
-		buildNatTable(cfg, dnsServers, loopbackIface.Name, ipv6),
-		buildMangleTable(cfg),
-	).Build(cfg.Verbose), nil
+	systemdResolvedUID, err := getSystemdResolvedUID(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	raw := buildRawTable(cfg, dnsServers, systemdResolvedUID, ipv6)
+	nat := buildNatTable(cfg, dnsServers, loopbackIface.Name, systemdResolvedUID, ipv6)
+	mangle := buildMangleTable(cfg, ipv6)
+	filter := buildFilterTable(cfg, loopbackIface.Name, systemdResolvedUID, ipv6)
+
+	traceChains(cfg, raw.Chains()...)
+	traceChains(cfg, nat.Chains()...)
+	traceChains(cfg, mangle.Chains()...)
+	traceChains(cfg, filter.Chains()...)
+
+	return newIPTables(raw, nat, mangle, filter).Build(cfg.Verbose), nil
 }
 
 // runtimeOutput is the file (should be os.Stdout by default) where we can dump generated
@@ -129,6 +196,12 @@ func restoreIPTables(cfg config.Config, dnsServers []string, ipv6 bool) (string,
 		return "", err
 	}
 
+	if cfg.ShouldConntrackZoneSplit() {
+		if err := validateConntrackZonesAreNotUsed(cfg, ipv6); err != nil {
+			return "", err
+		}
+	}
+
 	rules, err := BuildIPTables(cfg, dnsServers, ipv6)
 	if err != nil {
 		return "", fmt.Errorf("unable to build iptable rules: %s", err)
@@ -150,6 +223,9 @@ func restoreIPTables(cfg config.Config, dnsServers []string, ipv6 bool) (string,
 // TODO (bartsmykla): add validation if ip{,6}tables are available
 func RestoreIPTables(cfg config.Config) (string, error) {
 	cfg = config.MergeConfigWithDefaults(cfg)
+	// rules are applied without comments, when the comment extension
+	// is not available (see config.Config.ShouldComment)
+	cfg.Comment.Enabled = cfg.ShouldComment()
 
 	_, _ = cfg.RuntimeStdout.Write([]byte("kumactl is about to apply the " +
 		"iptables rules that will enable transparent proxying on the machine. " +
@@ -159,12 +235,18 @@ func RestoreIPTables(cfg config.Config) (string, error) {
 	var dnsIpv6, dnsIpv4 []string
 
 	if cfg.ShouldRedirectDNS() && !cfg.ShouldCaptureAllDNS() {
-		dnsIpv4, dnsIpv6, err = GetDnsServers(cfg.Redirect.DNS.ResolvConfigPath)
+		dnsIpv4, dnsIpv6, err = GetDnsServersFromConfig(cfg)
 		if err != nil {
 			return "", err
 		}
 	}
 
+	// ipsets have to exist before rules referencing them are restored
+	ipSetsOutput, err := RestoreIPSets(cfg)
+	if err != nil {
+		return "", fmt.Errorf("cannot restore ipsets: %s", err)
+	}
+
 	output, err := restoreIPTables(cfg, dnsIpv4, false)
 	if err != nil {
 		return "", fmt.Errorf("cannot restore ipv4 iptable rules: %s", err)
@@ -179,6 +261,8 @@ func RestoreIPTables(cfg config.Config) (string, error) {
 		output += ipv6Output
 	}
 
+	output = ipSetsOutput + output
+
 	_, _ = cfg.RuntimeStdout.Write([]byte("iptables set to diverge the traffic " +
 		"to Envoy.\n"))
 
@@ -0,0 +1,194 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/kumahq/kuma-net/iptables/chain"
+	. "github.com/kumahq/kuma-net/iptables/consts"
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+	"github.com/kumahq/kuma-net/iptables/table"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// When only DNS traffic to selected servers is captured, rules depending
+// on the servers are placed in the dedicated chains, so they can be replaced
+// when the servers change, without touching any other chain
+
+func buildDnsRedirect(
+	chain *Chain,
+	cfg config.Config,
+	dnsServers []string,
+	ipv6 bool,
+) *Chain {
+	dnsRedirectPort := cfg.Redirect.DNS.GetPort(ipv6)
+
+	for _, dnsIp := range dnsServers {
+		chain.
+			Append(
+				Destination(dnsIp),
+				Protocol(Udp(DestinationPort(DNSPort))),
+				comment(cfg.Comment, purposeDNSRedirect),
+				Jump(ToPort(dnsRedirectPort)),
+			).
+			Append(
+				Destination(dnsIp),
+				Protocol(Tcp(DestinationPort(DNSPort))),
+				comment(cfg.Comment, purposeDNSRedirect),
+				Jump(ToPort(dnsRedirectPort)),
+			)
+	}
+
+	return chain
+}
+
+func buildDnsConntrackOutput(chain *Chain, cfg config.Config, dnsServers []string) *Chain {
+	proxyZone := strconv.Itoa(int(cfg.Redirect.DNS.ProxyConntrackZone))
+
+	for _, ip := range dnsServers {
+		chain.Append(
+			Destination(ip),
+			Protocol(Udp(DestinationPort(DNSPort))),
+			comment(cfg.Comment, purposeDNSConntrackZoneProxy),
+			Jump(Ct(Zone(proxyZone))),
+		)
+	}
+
+	return chain
+}
+
+func buildDnsConntrackPrerouting(chain *Chain, cfg config.Config, dnsServers []string) *Chain {
+	upstreamZone := strconv.Itoa(int(cfg.Redirect.DNS.UpstreamConntrackZone))
+
+	for _, ip := range dnsServers {
+		// responses are coming from the DNS server
+		chain.Append(
+			Source(Address(ip)),
+			Protocol(Udp(SourcePort(DNSPort))),
+			comment(cfg.Comment, purposeDNSConntrackZoneUpstream),
+			Jump(Ct(Zone(upstreamZone))),
+		)
+	}
+
+	return chain
+}
+
+// BuildDnsServersUpdate returns iptables-restore input (which should be applied
+// with --noflush flag) replacing all the rules depending on the selected DNS
+// servers. Every table is committed atomically
+func BuildDnsServersUpdate(cfg config.Config, dnsServers []string, ipv6 bool) string {
+	cfg = config.MergeConfigWithDefaults(cfg)
+	prefix := cfg.Redirect.NamePrefix
+
+	var tables []string
+
+	redirect := buildDnsRedirect(
+		NewChain(cfg.Redirect.DNS.RedirectChain.GetFullName(prefix)).Flush(),
+		cfg,
+		dnsServers,
+		ipv6,
+	)
+	traceChains(cfg, redirect)
+
+	nat := table.Update("nat", redirect)
+	tables = append(tables, nat.Build(cfg.Verbose))
+
+	if cfg.ShouldConntrackZoneSplit() {
+		output := buildDnsConntrackOutput(
+			NewChain(cfg.Redirect.DNS.ConntrackOutputChain.GetFullName(prefix)).Flush(),
+			cfg,
+			dnsServers,
+		)
+		prerouting := buildDnsConntrackPrerouting(
+			NewChain(cfg.Redirect.DNS.ConntrackPreroutingChain.GetFullName(prefix)).Flush(),
+			cfg,
+			dnsServers,
+		)
+		traceChains(cfg, output, prerouting)
+
+		raw := table.Update("raw", output, prerouting)
+		tables = append(tables, raw.Build(cfg.Verbose))
+	}
+
+	separator := "\n"
+	if cfg.Verbose {
+		separator = "\n\n"
+	}
+
+	return strings.Join(tables, separator) + "\n"
+}
+
+func restoreDnsServersUpdate(cfg config.Config, dnsServers []string, ipv6 bool) (string, error) {
+	rulesFile, err := createRulesFile(ipv6)
+	if err != nil {
+		return "", err
+	}
+	defer rulesFile.Close()
+	defer os.Remove(rulesFile.Name())
+
+	rules := BuildDnsServersUpdate(cfg, dnsServers, ipv6)
+
+	if err := saveIPTablesRestoreFile(cfg.RuntimeStdout, rulesFile, rules); err != nil {
+		return "", fmt.Errorf("unable to save iptables restore file: %s", err)
+	}
+
+	cmdName := "iptables-restore"
+	if ipv6 {
+		cmdName = "ip6tables-restore"
+	}
+
+	return runRestoreCmd(cmdName, rulesFile)
+}
+
+// UpdateDnsServers re-reads DNS servers (i.e. after the resolv.conf file
+// changed) and replaces rules capturing DNS traffic to them. It's a no-op
+// when all the DNS traffic is captured
+func UpdateDnsServers(cfg config.Config) (string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	if !cfg.ShouldRedirectDNS() || cfg.ShouldCaptureAllDNS() {
+		return "", nil
+	}
+
+	dnsIpv4, dnsIpv6, err := GetDnsServersFromConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return updateDnsServers(cfg, dnsIpv4, dnsIpv6)
+}
+
+func updateDnsServers(cfg config.Config, dnsIpv4 []string, dnsIpv6 []string) (string, error) {
+	if cfg.DryRun {
+		output := BuildDnsServersUpdate(cfg, dnsIpv4, false)
+		if cfg.IPv6 {
+			output += BuildDnsServersUpdate(cfg, dnsIpv6, true)
+		}
+
+		_, _ = cfg.RuntimeStdout.Write([]byte(output))
+
+		return output, nil
+	}
+
+	// the same as in RestoreIPTables, rules are tagged with comments only
+	// when the comment extension is available
+	cfg.Comment.Enabled = cfg.ShouldComment()
+
+	output, err := restoreDnsServersUpdate(cfg, dnsIpv4, false)
+	if err != nil {
+		return "", fmt.Errorf("cannot update ipv4 dns servers rules: %s", err)
+	}
+
+	if cfg.IPv6 {
+		ipv6Output, err := restoreDnsServersUpdate(cfg, dnsIpv6, true)
+		if err != nil {
+			return "", fmt.Errorf("cannot update ipv6 dns servers rules: %s", err)
+		}
+
+		output += ipv6Output
+	}
+
+	return output, nil
+}
@@ -0,0 +1,114 @@
+package builder
+
+import (
+	. "github.com/kumahq/kuma-net/iptables/chain"
+	. "github.com/kumahq/kuma-net/iptables/consts"
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+	. "github.com/kumahq/kuma-net/iptables/parameters/match/conntrack"
+	"github.com/kumahq/kuma-net/iptables/table"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// buildMeshEgress returns the chain which allows outbound traffic of the proxy,
+// traffic redirected to it (which at this point is going through the loopback
+// interface), and traffic explicitly excluded from the redirection. All
+// the remaining traffic is rejected or dropped
+func buildMeshEgress(cfg config.Config, loopback string, systemdResolvedUID string, ipv6 bool) *Chain {
+	prefix := cfg.Redirect.NamePrefix
+	outbound := cfg.Redirect.Outbound
+	comments := cfg.Comment
+
+	meshEgress := NewChain(cfg.Redirect.EgressLockdown.Chain.GetFullName(prefix)).
+		Append(
+			OutInterface(loopback),
+			comment(comments, purposeEgressAllowLoopback),
+			Jump(Return()),
+		).
+		Append(
+			Match(Owner(Uid(cfg.Owner.UID))),
+			comment(comments, purposeEgressAllowProxy),
+			Jump(Return()),
+		).
+		Append(
+			Match(Conntrack(Ctstate(RELATED, ESTABLISHED))),
+			comment(comments, purposeEgressAllowEstablished),
+			Jump(Return()),
+		).
+		// queries forwarded by systemd-resolved to the upstream servers
+		// are not redirected to the proxy
+		AppendIf(func() bool { return cfg.ShouldRedirectDNS() && systemdResolvedUID != "" },
+			Match(Owner(Uid(systemdResolvedUID))),
+			comment(comments, purposeEgressAllowSystemdResolved),
+			Jump(Return()),
+		).
+		// DNS queries which are not captured by the proxy (i.e. to not selected
+		// DNS servers, or when DNS redirection is disabled) have to reach
+		// the DNS servers directly
+		Append(
+			Protocol(Udp(DestinationPort(DNSPort))),
+			comment(comments, purposeEgressAllowDNS),
+			Jump(Return()),
+		).
+		Append(
+			Protocol(Tcp(DestinationPort(DNSPort))),
+			comment(comments, purposeEgressAllowDNS),
+			Jump(Return()),
+		)
+
+	for _, iface := range cfg.Redirect.ExcludeInterfaces {
+		meshEgress.Append(
+			OutInterface(iface),
+			comment(comments, purposeEgressExcludeInterface),
+			Jump(Return()),
+		)
+	}
+
+	appendExcludeCIDRsRules(meshEgress, outbound, prefix, cfg.Redirect.IPSets.Enabled, ipv6, true,
+		comment(comments, purposeEgressExcludeCIDR))
+
+	// excluded ports are ignored when there are included ones
+	if len(outbound.GetIncludePortRanges()) == 0 {
+		appendExcludePortsRules(meshEgress, outbound, prefix, cfg.Redirect.IPSets.Enabled,
+			comment(comments, purposeEgressExcludePort))
+	}
+
+	if cfg.Redirect.EgressLockdown.Target == config.EgressLockdownDrop {
+		return meshEgress.Append(
+			comment(comments, purposeEgressBlock),
+			Jump(Drop()),
+		)
+	}
+
+	return meshEgress.
+		Append(
+			Protocol(Tcp()),
+			comment(comments, purposeEgressBlock),
+			Jump(RejectWith(RejectWithTcpReset)),
+		).
+		Append(
+			comment(comments, purposeEgressBlock),
+			Jump(Reject()),
+		)
+}
+
+func buildFilterTable(
+	cfg config.Config,
+	loopback string,
+	systemdResolvedUID string,
+	ipv6 bool,
+) *table.FilterTable {
+	filter := table.Filter()
+
+	if !cfg.Redirect.EgressLockdown.Enabled {
+		return filter
+	}
+
+	meshEgress := buildMeshEgress(cfg, loopback, systemdResolvedUID, ipv6)
+
+	filter.Output().Append(
+		comment(cfg.Comment, purposeEgress),
+		Jump(ToUserDefinedChain(meshEgress.Name())),
+	)
+
+	return filter.WithChain(meshEgress)
+}
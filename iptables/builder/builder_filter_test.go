@@ -0,0 +1,99 @@
+package builder_test
+
+import (
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("egress lockdown", func() {
+	redirect := func(lockdown config.EgressLockdown) config.Redirect {
+		return config.Redirect{
+			Inbound:        config.TrafficFlow{Enabled: true},
+			Outbound:       config.TrafficFlow{Enabled: true},
+			EgressLockdown: lockdown,
+		}
+	}
+
+	DescribeTable("should generate filter table",
+		func(cfg config.Config, ipv6 bool, goldenFile string) {
+			// given
+			cfg.RuntimeStdout = io.Discard
+
+			// when
+			got, err := builder.BuildIPTables(cfg, nil, ipv6)
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got).To(MatchGoldenEqual("testdata", goldenFile))
+		},
+		Entry("rejecting traffic by default",
+			config.Config{
+				Redirect: redirect(config.EgressLockdown{Enabled: true}),
+			},
+			false,
+			"egress_lockdown_reject.golden.txt",
+		),
+		Entry("rejecting traffic by default (verbose)",
+			config.Config{
+				Redirect: redirect(config.EgressLockdown{Enabled: true}),
+				Verbose:  true,
+			},
+			false,
+			"egress_lockdown_reject_verbose.golden.txt",
+		),
+		Entry("rejecting IPv6 traffic",
+			config.Config{
+				Redirect: redirect(config.EgressLockdown{Enabled: true}),
+				IPv6:     true,
+			},
+			true,
+			"egress_lockdown_reject_ipv6.golden.txt",
+		),
+		Entry("dropping traffic with exclusions",
+			config.Config{
+				Redirect: func() config.Redirect {
+					r := redirect(config.EgressLockdown{
+						Enabled: true,
+						Target:  config.EgressLockdownDrop,
+						Chain:   config.Chain{Name: "EGRESS"},
+					})
+					r.NamePrefix = "KUMA_"
+					r.Outbound.ExcludePorts = []uint16{22}
+					r.Outbound.ExcludeCIDRs = []string{"10.0.0.0/8", "fd00::/8"}
+					r.ExcludeInterfaces = []string{"wg+"}
+
+					return r
+				}(),
+			},
+			false,
+			"egress_lockdown_drop_exclusions.golden.txt",
+		),
+	)
+
+	DescribeTable("should fail for invalid configuration",
+		func(redirect config.Redirect, errorSubstring string) {
+			// when
+			_, err := builder.BuildIPTables(config.Config{Redirect: redirect}, nil, false)
+
+			// then
+			Expect(err).To(MatchError(ContainSubstring(errorSubstring)))
+		},
+		Entry("unsupported target",
+			redirect(config.EgressLockdown{Enabled: true, Target: "ACCEPT"}),
+			`unsupported egress lockdown target "ACCEPT"`,
+		),
+		Entry("disabled outbound redirection",
+			config.Redirect{
+				Inbound:        config.TrafficFlow{Enabled: true},
+				EgressLockdown: config.EgressLockdown{Enabled: true},
+			},
+			"egress lockdown requires outbound traffic redirection to be enabled",
+		),
+	)
+})
@@ -1,20 +1,115 @@
 package builder
 
 import (
+	. "github.com/kumahq/kuma-net/iptables/chain"
 	. "github.com/kumahq/kuma-net/iptables/parameters"
 	. "github.com/kumahq/kuma-net/iptables/parameters/match/conntrack"
 	"github.com/kumahq/kuma-net/iptables/table"
 	"github.com/kumahq/kuma-net/transparent-proxy/config"
 )
 
-func buildMangleTable(cfg config.Config) *table.MangleTable {
-	mangle := table.Mangle()
+// invalidTCPFlags are masks and compared flags (see TcpFlags) of illegal
+// combinations of TCP flags, which are never set by legitimate peers
+var invalidTCPFlags = [][2]string{
+	// NULL packets
+	{"ALL", "NONE"},
+	// XMAS packets
+	{"ALL", "FIN,PSH,URG"},
+	{"SYN,FIN", "SYN,FIN"},
+	{"SYN,RST", "SYN,RST"},
+	{"FIN,RST", "FIN,RST"},
+	// FIN without ACK
+	{"ACK,FIN", "FIN"},
+}
+
+// neighbourDiscoveryTypes are ICMPv6 types of the neighbour discovery
+// packets, which are not tracked by conntrack (so they are in the INVALID
+// state), but without which IPv6 stops working
+var neighbourDiscoveryTypes = []string{
+	"router-solicitation",
+	"router-advertisement",
+	"neighbour-solicitation",
+	"neighbour-advertisement",
+}
+
+// buildMeshHardening returns the chain dropping invalid packets
+func buildMeshHardening(cfg config.Config, ipv6 bool) *Chain {
+	comments := cfg.Comment
 
-	mangle.Prerouting().
-		AppendIf(cfg.ShouldDropInvalidPackets,
+	meshHardening := NewChain(cfg.Hardening.Chain.GetFullName(cfg.Redirect.NamePrefix))
+
+	if cfg.ShouldDropInvalidPackets() {
+		// when scoped to the mesh, only TCP traffic is jumping to the chain
+		if ipv6 && !cfg.Hardening.MeshOnly {
+			for _, icmpType := range neighbourDiscoveryTypes {
+				meshHardening.Append(
+					Protocol(Icmpv6(Icmpv6Type(icmpType))),
+					comment(comments, purposeAllowNeighbourDiscovery),
+					Jump(Return()),
+				)
+			}
+		}
+
+		meshHardening.Append(
 			Match(Conntrack(Ctstate(INVALID))),
+			comment(comments, purposeDropInvalid),
 			Jump(Drop()),
 		)
+	}
+
+	if cfg.Hardening.DropInvalidTCPFlags {
+		for _, flags := range invalidTCPFlags {
+			meshHardening.Append(
+				Protocol(Tcp(TcpFlags(flags[0], flags[1]))),
+				comment(comments, purposeDropInvalidTCPFlags),
+				Jump(Drop()),
+			)
+		}
+	}
+
+	return meshHardening
+}
+
+// hardeningJump returns parameters of the rule jumping to the hardening chain
+// from the built-in chain of provided flow. When the rules are scoped
+// to the mesh, only TCP traffic in the direction with enabled redirection
+// is jumping, as the chains of the nat table cannot jump to the mangle table
+// (so exclusions of the mesh chains are not applied)
+func hardeningJump(cfg config.Config, flow config.TrafficFlow, meshHardening *Chain) []*Parameter {
+	if !cfg.Hardening.MeshOnly {
+		return []*Parameter{
+			comment(cfg.Comment, purposeHardening),
+			Jump(ToUserDefinedChain(meshHardening.Name())),
+		}
+	}
+
+	if !flow.Enabled {
+		return nil
+	}
+
+	return []*Parameter{
+		Protocol(Tcp()),
+		comment(cfg.Comment, purposeHardening),
+		Jump(ToUserDefinedChain(meshHardening.Name())),
+	}
+}
+
+func buildMangleTable(cfg config.Config, ipv6 bool) *table.MangleTable {
+	mangle := table.Mangle()
+
+	if !cfg.ShouldDropInvalidPackets() && !cfg.Hardening.Enabled() {
+		return mangle
+	}
+
+	meshHardening := buildMeshHardening(cfg, ipv6)
+
+	if jump := hardeningJump(cfg, cfg.Redirect.Inbound, meshHardening); jump != nil {
+		mangle.Prerouting().Append(jump...)
+	}
+
+	if jump := hardeningJump(cfg, cfg.Redirect.Outbound, meshHardening); jump != nil {
+		mangle.Output().Append(jump...)
+	}
 
-	return mangle
+	return mangle.WithChain(meshHardening)
 }
@@ -0,0 +1,90 @@
+package builder_test
+
+import (
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("hardening", func() {
+	redirect := config.Redirect{
+		Inbound:  config.TrafficFlow{Enabled: true},
+		Outbound: config.TrafficFlow{Enabled: true},
+	}
+
+	DescribeTable("should generate mangle table",
+		func(cfg config.Config, ipv6 bool, goldenFile string) {
+			// given
+			cfg.RuntimeStdout = io.Discard
+
+			// when
+			got, err := builder.BuildIPTables(cfg, nil, ipv6)
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got).To(MatchGoldenEqual("testdata", goldenFile))
+		},
+		Entry("dropping invalid packets (DropInvalidPackets)",
+			config.Config{
+				Redirect:           redirect,
+				DropInvalidPackets: true,
+			},
+			false,
+			"hardening_drop_invalid.golden.txt",
+		),
+		Entry("dropping invalid IPv6 packets",
+			config.Config{
+				Redirect:  redirect,
+				Hardening: config.Hardening{DropInvalid: true},
+				IPv6:      true,
+			},
+			true,
+			"hardening_drop_invalid_ipv6.golden.txt",
+		),
+		Entry("dropping packets with invalid TCP flags",
+			config.Config{
+				Redirect: redirect,
+				Hardening: config.Hardening{
+					DropInvalid:         true,
+					DropInvalidTCPFlags: true,
+				},
+			},
+			false,
+			"hardening_tcp_flags.golden.txt",
+		),
+		Entry("scoped to the mesh with only outbound redirection",
+			config.Config{
+				Redirect: config.Redirect{
+					NamePrefix: "KUMA_",
+					Outbound:   config.TrafficFlow{Enabled: true},
+				},
+				Hardening: config.Hardening{
+					DropInvalid:         true,
+					DropInvalidTCPFlags: true,
+					MeshOnly:            true,
+					Chain:               config.Chain{Name: "HARDENING"},
+				},
+				IPv6: true,
+			},
+			true,
+			"hardening_mesh_only_ipv6.golden.txt",
+		),
+	)
+
+	It("should not generate mangle table unless enabled", func() {
+		// when
+		got, err := builder.BuildIPTables(config.Config{
+			Redirect:      redirect,
+			RuntimeStdout: io.Discard,
+		}, nil, false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).ToNot(ContainSubstring("* mangle"))
+	})
+})
@@ -8,34 +8,159 @@ import (
 	"github.com/kumahq/kuma-net/transparent-proxy/config"
 )
 
-func buildMeshInbound(cfg config.TrafficFlow, prefix string, meshInboundRedirect string) *Chain {
+// appendPortRangesRules appends rules matching tcp traffic to provided ports
+// and port ranges, which are grouped, so every rule contains as many of them
+// as allowed by the multiport match
+func appendPortRangesRules(
+	chain *Chain,
+	portRanges []config.PortRange,
+	comment *Parameter,
+	jump func() *Parameter,
+) {
+	if len(portRanges) == 1 && portRanges[0].From == portRanges[0].To {
+		chain.Append(
+			Protocol(Tcp(DestinationPort(portRanges[0].From))),
+			comment,
+			jump(),
+		)
+
+		return
+	}
+
+	var ports []string
+	for _, portRange := range portRanges {
+		ports = append(ports, MultiportRange(portRange.From, portRange.To))
+	}
+
+	for _, group := range SplitMultiportPorts(ports) {
+		chain.Append(
+			Protocol(Tcp()),
+			Match(Multiport(DestinationPorts(group...))),
+			comment,
+			jump(),
+		)
+	}
+}
+
+// appendExcludeCIDRsRules appends rules returning from the chain traffic with
+// excluded addresses (destination ones for outbound and source ones
+// for inbound traffic). When ipsets are enabled, all the CIDRs are matched
+// by a single rule
+func appendExcludeCIDRsRules(
+	chain *Chain,
+	cfg config.TrafficFlow,
+	prefix string,
+	ipSets bool,
+	ipv6 bool,
+	outbound bool,
+	comment *Parameter,
+) {
+	// CIDRs are validated before building the rules
+	cidrs, _ := cfg.GetExcludeCIDRs(ipv6)
+	if len(cidrs) == 0 {
+		return
+	}
+
+	if ipSets {
+		setName := getIPSetName(cfg, prefix, ipSetNetSuffix)
+		if ipv6 {
+			setName = getIPSetName(cfg, prefix, ipSetNetIPv6Suffix)
+		}
+
+		flag := SetFlagSrc
+		if outbound {
+			flag = SetFlagDst
+		}
+
+		chain.Append(
+			Match(Set(MatchSet(setName, flag))),
+			comment,
+			Jump(Return()),
+		)
+
+		return
+	}
+
+	for _, cidr := range cidrs {
+		address := Source(Address(cidr))
+		if outbound {
+			address = Destination(cidr)
+		}
+
+		chain.Append(
+			address,
+			comment,
+			Jump(Return()),
+		)
+	}
+}
+
+// appendExcludePortsRules appends rules returning from the chain tcp traffic
+// to excluded ports
+func appendExcludePortsRules(
+	chain *Chain,
+	cfg config.TrafficFlow,
+	prefix string,
+	ipSets bool,
+	comment *Parameter,
+) {
+	excludePorts := cfg.GetExcludePortRanges()
+
+	if ipSets && len(excludePorts) > 0 {
+		chain.Append(
+			Protocol(Tcp()),
+			Match(Set(MatchSet(getIPSetName(cfg, prefix, ipSetPortSuffix), SetFlagDst))),
+			comment,
+			Jump(Return()),
+		)
+
+		return
+	}
+
+	appendPortRangesRules(chain, excludePorts, comment, func() *Parameter {
+		return Jump(Return())
+	})
+}
+
+func buildMeshInbound(
+	cfg config.TrafficFlow,
+	prefix string,
+	meshInboundRedirect string,
+	ipSets bool,
+	ipv6 bool,
+	comments config.Comment,
+) *Chain {
 	meshInbound := NewChain(cfg.Chain.GetFullName(prefix))
 	if !cfg.Enabled {
 		meshInbound.Append(
 			Protocol(Tcp()),
+			comment(comments, purposeInboundDisabled),
 			Jump(Return()),
 		)
 		return meshInbound
 	}
 
+	includePorts := cfg.GetIncludePortRanges()
+
+	// Excluded inbound source addresses
+	appendExcludeCIDRsRules(meshInbound, cfg, prefix, ipSets, ipv6, false,
+		comment(comments, purposeInboundExcludeCIDR))
+
 	// Include inbound ports
-	for _, port := range cfg.IncludePorts {
-		meshInbound.Append(
-			Protocol(Tcp(DestinationPort(port))),
-			Jump(ToUserDefinedChain(meshInboundRedirect)),
-		)
-	}
+	appendPortRangesRules(meshInbound, includePorts,
+		comment(comments, purposeInboundIncludePort),
+		func() *Parameter {
+			return Jump(ToUserDefinedChain(meshInboundRedirect))
+		},
+	)
 
-	if len(cfg.IncludePorts) == 0 {
-		// Excluded outbound ports
-		for _, port := range cfg.ExcludePorts {
-			meshInbound.Append(
-				Protocol(Tcp(DestinationPort(port))),
-				Jump(Return()),
-			)
-		}
+	if len(includePorts) == 0 {
+		// Excluded inbound ports
+		appendExcludePortsRules(meshInbound, cfg, prefix, ipSets,
+			comment(comments, purposeInboundExcludePort))
 		meshInbound.Append(
 			Protocol(Tcp()),
+			comment(comments, purposeInboundRedirect),
 			Jump(ToUserDefinedChain(meshInboundRedirect)),
 		)
 	}
@@ -45,19 +170,20 @@ func buildMeshInbound(cfg config.TrafficFlow, prefix string, meshInboundRedirect
 
 func buildMeshOutbound(
 	cfg config.Config,
-	dnsServers []string,
 	loopback string,
+	systemdResolvedUID string,
 	ipv6 bool,
 ) *Chain {
 	prefix := cfg.Redirect.NamePrefix
 	inboundRedirectChainName := cfg.Redirect.Inbound.RedirectChain.GetFullName(prefix)
 	outboundChainName := cfg.Redirect.Outbound.Chain.GetFullName(prefix)
 	outboundRedirectChainName := cfg.Redirect.Outbound.RedirectChain.GetFullName(prefix)
-	excludePorts := cfg.Redirect.Outbound.ExcludePorts
-	includePorts := cfg.Redirect.Outbound.IncludePorts
+	ipSets := cfg.Redirect.IPSets.Enabled
+	includePorts := cfg.Redirect.Outbound.GetIncludePortRanges()
 	hasIncludedPorts := len(includePorts) > 0
-	dnsRedirectPort := cfg.Redirect.DNS.Port
+	dnsRedirectPort := cfg.Redirect.DNS.GetPort(ipv6)
 	uid := cfg.Owner.UID
+	comments := cfg.Comment
 
 	localhost := LocalhostCIDRIPv4
 	inboundPassthroughSourceAddress := InboundPassthroughSourceAddressCIDRIPv4
@@ -70,19 +196,20 @@ func buildMeshOutbound(
 	if !cfg.Redirect.Outbound.Enabled {
 		meshOutbound.Append(
 			Protocol(Tcp()),
+			comment(comments, purposeOutboundDisabled),
 			Jump(Return()),
 		)
 		return meshOutbound
 	}
 
+	// Excluded outbound destination addresses
+	appendExcludeCIDRsRules(meshOutbound, cfg.Redirect.Outbound, prefix, ipSets, ipv6, true,
+		comment(comments, purposeOutboundExcludeCIDR))
+
 	// Excluded outbound ports
 	if !hasIncludedPorts {
-		for _, port := range excludePorts {
-			meshOutbound.Append(
-				Protocol(Tcp(DestinationPort(port))),
-				Jump(Return()),
-			)
-		}
+		appendExcludePortsRules(meshOutbound, cfg.Redirect.Outbound, prefix, ipSets,
+			comment(comments, purposeOutboundExcludePort))
 	}
 	meshOutbound.
 		// ipv4:
@@ -113,6 +240,7 @@ func buildMeshOutbound(
 		Append(
 			Source(Address(inboundPassthroughSourceAddress)),
 			OutInterface(loopback),
+			comment(comments, purposeOutboundInboundPassthrough),
 			Jump(Return()),
 		).
 		Append(
@@ -120,49 +248,61 @@ func buildMeshOutbound(
 			OutInterface(loopback),
 			NotDestination(localhost),
 			Match(Owner(Uid(uid))),
+			comment(comments, purposeOutboundLocalInbound),
 			Jump(ToUserDefinedChain(inboundRedirectChainName)),
 		).
 		Append(
 			Protocol(Tcp(NotDestinationPortIf(cfg.ShouldRedirectDNS, DNSPort))),
 			OutInterface(loopback),
 			Match(Owner(NotUid(uid))),
+			comment(comments, purposeOutboundSkipLoopback),
 			Jump(Return()),
 		).
 		Append(
 			Match(Owner(Uid(uid))),
+			comment(comments, purposeOutboundSkipProxy),
 			Jump(Return()),
 		)
 	if cfg.ShouldRedirectDNS() {
+		// queries forwarded by systemd-resolved to the upstream servers
+		// cannot be captured, as they would loop back to the proxy
+		meshOutbound.AppendIf(func() bool { return systemdResolvedUID != "" },
+			Protocol(Tcp(DestinationPort(DNSPort))),
+			Match(Owner(Uid(systemdResolvedUID))),
+			comment(comments, purposeDNSSkipSystemdResolved),
+			Jump(Return()),
+		)
 		if cfg.ShouldCaptureAllDNS() {
 			meshOutbound.Append(
 				Protocol(Tcp(DestinationPort(DNSPort))),
+				comment(comments, purposeDNSRedirect),
 				Jump(ToPort(dnsRedirectPort)),
 			)
 		} else {
-			for _, dnsIp := range dnsServers {
-				meshOutbound.Append(
-					Destination(dnsIp),
-					Protocol(Tcp(DestinationPort(DNSPort))),
-					Jump(ToPort(dnsRedirectPort)),
-				)
-			}
+			meshOutbound.Append(
+				Protocol(Tcp(DestinationPort(DNSPort))),
+				comment(comments, purposeDNSRedirect),
+				Jump(ToUserDefinedChain(cfg.Redirect.DNS.RedirectChain.GetFullName(prefix))),
+			)
 		}
 	}
 	meshOutbound.
 		Append(
 			Destination(localhost),
+			comment(comments, purposeOutboundSkipLocalhost),
 			Jump(Return()),
 		)
 
 	if hasIncludedPorts {
-		for _, port := range includePorts {
-			meshOutbound.Append(
-				Protocol(Tcp(DestinationPort(port))),
-				Jump(ToUserDefinedChain(outboundRedirectChainName)),
-			)
-		}
+		appendPortRangesRules(meshOutbound, includePorts,
+			comment(comments, purposeOutboundIncludePort),
+			func() *Parameter {
+				return Jump(ToUserDefinedChain(outboundRedirectChainName))
+			},
+		)
 	} else {
 		meshOutbound.Append(
+			comment(comments, purposeOutboundRedirect),
 			Jump(ToUserDefinedChain(outboundRedirectChainName)),
 		)
 	}
@@ -170,50 +310,64 @@ func buildMeshOutbound(
 	return meshOutbound
 }
 
-func buildMeshRedirect(cfg config.TrafficFlow, prefix string, ipv6 bool) *Chain {
+func buildMeshRedirect(cfg config.TrafficFlow, prefix string, ipv6 bool, comment *Parameter) *Chain {
 	chainName := cfg.RedirectChain.GetFullName(prefix)
 
-	redirectPort := cfg.Port
-	if ipv6 && cfg.PortIPv6 != 0 {
-		redirectPort = cfg.PortIPv6
-	}
-
 	return NewChain(chainName).
 		Append(
 			Protocol(Tcp()),
-			Jump(ToPort(redirectPort)),
+			comment,
+			Jump(ToPort(cfg.GetPort(ipv6))),
 		)
 }
 
-func addOutputRules(cfg config.Config, dnsServers []string, nat *table.NatTable) {
+func addOutputRules(cfg config.Config, nat *table.NatTable, systemdResolvedUID string, ipv6 bool) {
 	outboundChainName := cfg.Redirect.Outbound.Chain.GetFullName(cfg.Redirect.NamePrefix)
-	dnsRedirectPort := cfg.Redirect.DNS.Port
+	dnsRedirectPort := cfg.Redirect.DNS.GetPort(ipv6)
 	uid := cfg.Owner.UID
+	comments := cfg.Comment
+
+	for _, iface := range cfg.Redirect.ExcludeInterfaces {
+		nat.Output().Append(
+			OutInterface(iface),
+			comment(comments, purposeOutboundExcludeInterface),
+			Jump(Return()),
+		)
+	}
 
 	if cfg.ShouldRedirectDNS() {
 		nat.Output().Append(
 			Protocol(Udp(DestinationPort(DNSPort))),
 			Match(Owner(Uid(uid))),
+			comment(comments, purposeDNSSkipProxy),
+			Jump(Return()),
+		)
+		// queries forwarded by systemd-resolved to the upstream servers
+		// cannot be captured, as they would loop back to the proxy
+		nat.Output().AppendIf(func() bool { return systemdResolvedUID != "" },
+			Protocol(Udp(DestinationPort(DNSPort))),
+			Match(Owner(Uid(systemdResolvedUID))),
+			comment(comments, purposeDNSSkipSystemdResolved),
 			Jump(Return()),
 		)
 		if cfg.ShouldCaptureAllDNS() {
 			nat.Output().Append(
 				Protocol(Udp(DestinationPort(DNSPort))),
+				comment(comments, purposeDNSRedirect),
 				Jump(ToPort(dnsRedirectPort)),
 			)
 		} else {
-			for _, dnsIp := range dnsServers {
-				nat.Output().Append(
-					Destination(dnsIp),
-					Protocol(Udp(DestinationPort(DNSPort))),
-					Jump(ToPort(dnsRedirectPort)),
-				)
-			}
+			nat.Output().Append(
+				Protocol(Udp(DestinationPort(DNSPort))),
+				comment(comments, purposeDNSRedirect),
+				Jump(ToUserDefinedChain(cfg.Redirect.DNS.RedirectChain.GetFullName(cfg.Redirect.NamePrefix))),
+			)
 		}
 	}
 	nat.Output().
 		Append(
 			Protocol(Tcp()),
+			comment(comments, purposeOutbound),
 			Jump(ToUserDefinedChain(outboundChainName)),
 		)
 }
@@ -222,6 +376,7 @@ func buildNatTable(
 	cfg config.Config,
 	dnsServers []string,
 	loopback string,
+	systemdResolvedUID string,
 	ipv6 bool,
 ) *table.NatTable {
 	prefix := cfg.Redirect.NamePrefix
@@ -229,28 +384,54 @@ func buildNatTable(
 	inboundChainName := cfg.Redirect.Inbound.Chain.GetFullName(prefix)
 	nat := table.Nat()
 
+	for _, iface := range cfg.Redirect.ExcludeInboundInterfaces {
+		nat.Prerouting().Append(
+			InInterface(iface),
+			comment(cfg.Comment, purposeInboundExcludeInterface),
+			Jump(Return()),
+		)
+	}
+
 	nat.Prerouting().Append(
 		Protocol(Tcp()),
+		comment(cfg.Comment, purposeInbound),
 		Jump(ToUserDefinedChain(inboundChainName)),
 	)
 
-	addOutputRules(cfg, dnsServers, nat)
+	addOutputRules(cfg, nat, systemdResolvedUID, ipv6)
 
 	// MESH_INBOUND
-	meshInbound := buildMeshInbound(cfg.Redirect.Inbound, prefix, inboundRedirectChainName)
+	meshInbound := buildMeshInbound(
+		cfg.Redirect.Inbound,
+		prefix,
+		inboundRedirectChainName,
+		cfg.Redirect.IPSets.Enabled,
+		ipv6,
+		cfg.Comment,
+	)
 
 	// MESH_INBOUND_REDIRECT
-	meshInboundRedirect := buildMeshRedirect(cfg.Redirect.Inbound, prefix, ipv6)
+	meshInboundRedirect := buildMeshRedirect(cfg.Redirect.Inbound, prefix, ipv6,
+		comment(cfg.Comment, purposeInboundRedirectPort))
 
 	// MESH_OUTBOUND
-	meshOutbound := buildMeshOutbound(cfg, dnsServers, loopback, ipv6)
+	meshOutbound := buildMeshOutbound(cfg, loopback, systemdResolvedUID, ipv6)
 
 	// MESH_OUTBOUND_REDIRECT
-	meshOutboundRedirect := buildMeshRedirect(cfg.Redirect.Outbound, prefix, ipv6)
+	meshOutboundRedirect := buildMeshRedirect(cfg.Redirect.Outbound, prefix, ipv6,
+		comment(cfg.Comment, purposeOutboundRedirectPort))
 
-	return nat.
+	nat.
 		WithChain(meshInbound).
 		WithChain(meshOutbound).
 		WithChain(meshInboundRedirect).
 		WithChain(meshOutboundRedirect)
+
+	// MESH_DNS_REDIRECT
+	if cfg.ShouldRedirectDNS() && !cfg.ShouldCaptureAllDNS() {
+		dnsRedirectChainName := cfg.Redirect.DNS.RedirectChain.GetFullName(prefix)
+		nat.WithChain(buildDnsRedirect(NewChain(dnsRedirectChainName), cfg, dnsServers, ipv6))
+	}
+
+	return nat
 }
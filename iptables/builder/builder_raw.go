@@ -1,6 +1,9 @@
 package builder
 
 import (
+	"strconv"
+
+	. "github.com/kumahq/kuma-net/iptables/chain"
 	. "github.com/kumahq/kuma-net/iptables/consts"
 	. "github.com/kumahq/kuma-net/iptables/parameters"
 	"github.com/kumahq/kuma-net/iptables/table"
@@ -10,47 +13,70 @@ import (
 func buildRawTable(
 	cfg config.Config,
 	dnsServers []string,
+	systemdResolvedUID string,
+	ipv6 bool,
 ) *table.RawTable {
 	raw := table.Raw()
+	upstreamZone := strconv.Itoa(int(cfg.Redirect.DNS.UpstreamConntrackZone))
+	proxyZone := strconv.Itoa(int(cfg.Redirect.DNS.ProxyConntrackZone))
+	comments := cfg.Comment
 
 	if cfg.ShouldConntrackZoneSplit() {
 		raw.Output().
 			Append(
 				Protocol(Udp(DestinationPort(DNSPort))),
 				Match(Owner(Uid(cfg.Owner.UID))),
-				Jump(Ct(Zone("1"))),
+				comment(comments, purposeDNSConntrackZoneUpstream),
+				Jump(Ct(Zone(upstreamZone))),
+			).
+			// queries forwarded by systemd-resolved to the upstream servers
+			// are in the same zone as the ones forwarded by the proxy
+			AppendIf(func() bool { return systemdResolvedUID != "" },
+				Protocol(Udp(DestinationPort(DNSPort))),
+				Match(Owner(Uid(systemdResolvedUID))),
+				comment(comments, purposeDNSConntrackZoneUpstream),
+				Jump(Ct(Zone(upstreamZone))),
 			).
 			Append(
-				Protocol(Udp(SourcePort(cfg.Redirect.DNS.Port))),
+				Protocol(Udp(SourcePort(cfg.Redirect.DNS.GetPort(ipv6)))),
 				Match(Owner(Uid(cfg.Owner.UID))),
-				Jump(Ct(Zone("2"))),
+				comment(comments, purposeDNSConntrackZoneProxy),
+				Jump(Ct(Zone(proxyZone))),
 			)
 
 		if cfg.ShouldCaptureAllDNS() {
 			raw.Output().Append(
 				Protocol(Udp(DestinationPort(DNSPort))),
-				Jump(Ct(Zone("2"))),
+				comment(comments, purposeDNSConntrackZoneProxy),
+				Jump(Ct(Zone(proxyZone))),
 			)
 
 			raw.Prerouting().
 				Append(
 					Protocol(Udp(SourcePort(DNSPort))),
-					Jump(Ct(Zone("1"))),
+					comment(comments, purposeDNSConntrackZoneUpstream),
+					Jump(Ct(Zone(upstreamZone))),
 				)
 		} else {
-			for _, ip := range dnsServers {
-				raw.Output().Append(
-					Destination(ip),
-					Protocol(Udp(DestinationPort(DNSPort))),
-					Jump(Ct(Zone("2"))),
-				)
-				raw.Prerouting().
-					Append(
-						Destination(ip),
-						Protocol(Udp(SourcePort(DNSPort))),
-						Jump(Ct(Zone("1"))),
-					)
-			}
+			prefix := cfg.Redirect.NamePrefix
+			ctOutputChainName := cfg.Redirect.DNS.ConntrackOutputChain.GetFullName(prefix)
+			ctPreroutingChainName := cfg.Redirect.DNS.ConntrackPreroutingChain.GetFullName(prefix)
+
+			raw.Output().Append(
+				Protocol(Udp(DestinationPort(DNSPort))),
+				comment(comments, purposeDNSConntrackZone),
+				Jump(ToUserDefinedChain(ctOutputChainName)),
+			)
+
+			raw.Prerouting().Append(
+				Protocol(Udp(SourcePort(DNSPort))),
+				comment(comments, purposeDNSConntrackZone),
+				Jump(ToUserDefinedChain(ctPreroutingChainName)),
+			)
+
+			raw.
+				WithChain(buildDnsConntrackOutput(NewChain(ctOutputChainName), cfg, dnsServers)).
+				WithChain(buildDnsConntrackPrerouting(NewChain(ctPreroutingChainName), cfg, dnsServers))
 		}
 	}
 
@@ -0,0 +1,232 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// getChainNames returns names of all the custom chains, which could be created
+// for provided configuration
+func getChainNames(cfg config.Config) []string {
+	prefix := cfg.Redirect.NamePrefix
+
+	var names []string
+
+	for _, chain := range []config.Chain{
+		cfg.Redirect.Inbound.Chain,
+		cfg.Redirect.Inbound.RedirectChain,
+		cfg.Redirect.Outbound.Chain,
+		cfg.Redirect.Outbound.RedirectChain,
+		cfg.Redirect.DNS.RedirectChain,
+		cfg.Redirect.DNS.ConntrackOutputChain,
+		cfg.Redirect.DNS.ConntrackPreroutingChain,
+		cfg.Redirect.EgressLockdown.Chain,
+		cfg.Hardening.Chain,
+	} {
+		names = append(names, chain.GetFullName(prefix))
+	}
+
+	return names
+}
+
+// InstalledChain is the custom chain created for the configuration, which
+// exists in the table
+type InstalledChain struct {
+	Table string
+	Chain string
+}
+
+// parseInstalledChains returns custom chains created for provided
+// configuration, which are present in provided iptables-save output
+func parseInstalledChains(cfg config.Config, chains []savedChain) []InstalledChain {
+	names := map[string]struct{}{}
+	for _, name := range getChainNames(cfg) {
+		names[name] = struct{}{}
+	}
+
+	var installed []InstalledChain
+
+	for _, chain := range chains {
+		if _, ok := names[chain.chain]; ok {
+			installed = append(installed, InstalledChain{Table: chain.table, Chain: chain.chain})
+		}
+	}
+
+	return installed
+}
+
+// legacyRules returns rules, which were generated for provided configuration
+// into built-in chains by previous versions
+func legacyRules(cfg config.Config) string {
+	if !cfg.ShouldDropInvalidPackets() {
+		return ""
+	}
+
+	return "*mangle\n-A PREROUTING -m conntrack --ctstate INVALID -j DROP\nCOMMIT\n"
+}
+
+// generatedRules returns keys (see savedRule.key) of rules, which would
+// be generated for provided configuration into built-in chains (for both
+// IPv4 and IPv6), so they can be recognized even without comments
+func generatedRules(cfg config.Config) (map[string]struct{}, error) {
+	keys := map[string]struct{}{}
+
+	for _, ipv6 := range []bool{false, true} {
+		rules, err := buildIPTables(cfg, nil, ipv6)
+		if err != nil {
+			return nil, err
+		}
+
+		_, saved, err := parseSave(rules + legacyRules(cfg))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range saved {
+			keys[rule.table+" "+rule.chain+" "+rule.key()] = struct{}{}
+		}
+	}
+
+	return keys, nil
+}
+
+// BuildIPTablesCleanup returns iptables-restore input (which should be applied
+// with --noflush flag) removing rules generated for provided configuration
+// from provided iptables-save output. Rules in other chains are removed when
+// they are tagged with the configured comment prefix, are jumping
+// to the generated chains (which are then flushed and deleted), or are
+// the same as the rules which would be generated for the configuration
+func BuildIPTablesCleanup(cfg config.Config, save string) (string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	chains, rules, err := parseSave(save)
+	if err != nil {
+		return "", err
+	}
+
+	owned, err := generatedRules(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	installed := parseInstalledChains(cfg, chains)
+
+	generated := map[string]struct{}{}
+	for _, chain := range installed {
+		generated[chain.Chain] = struct{}{}
+	}
+
+	var tables []string
+	deletes := map[string][]string{}
+	flushes := map[string][]string{}
+	removes := map[string][]string{}
+
+	seen := map[string]struct{}{}
+
+	addTable := func(table string) {
+		if _, ok := seen[table]; !ok {
+			seen[table] = struct{}{}
+			tables = append(tables, table)
+		}
+	}
+
+	for _, rule := range rules {
+		if _, ok := generated[rule.chain]; ok {
+			continue
+		}
+
+		_, tagged := rule.purpose(cfg.Comment.Prefix + "/")
+		_, jumping := generated[rule.jumpTarget()]
+		_, same := owned[rule.table+" "+rule.chain+" "+rule.key()]
+
+		if (tagged && cfg.Comment.Enabled) || jumping || same {
+			addTable(rule.table)
+			deletes[rule.table] = append(deletes[rule.table],
+				fmt.Sprintf("-D %s %s", rule.chain, rule.specification))
+		}
+	}
+
+	for _, chain := range installed {
+		addTable(chain.Table)
+		flushes[chain.Table] = append(flushes[chain.Table], fmt.Sprintf("-F %s", chain.Chain))
+		removes[chain.Table] = append(removes[chain.Table], fmt.Sprintf("-X %s", chain.Chain))
+	}
+
+	var lines []string
+
+	for _, table := range tables {
+		lines = append(lines, fmt.Sprintf("*%s", table))
+		lines = append(lines, deletes[table]...)
+		lines = append(lines, flushes[table]...)
+		lines = append(lines, removes[table]...)
+		lines = append(lines, "COMMIT")
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func cleanupIPTables(cfg config.Config, ipv6 bool) (string, error) {
+	save, err := runSaveCmd(ipv6)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := BuildIPTablesCleanup(cfg, save)
+	if err != nil || content == "" {
+		return "", err
+	}
+
+	if cfg.DryRun {
+		_, _ = cfg.RuntimeStdout.Write([]byte(content))
+
+		return content, nil
+	}
+
+	rulesFile, err := createRulesFile(ipv6)
+	if err != nil {
+		return "", err
+	}
+	defer rulesFile.Close()
+	defer os.Remove(rulesFile.Name())
+
+	if err := saveIPTablesRestoreFile(cfg.RuntimeStdout, rulesFile, content); err != nil {
+		return "", fmt.Errorf("unable to save iptables restore file: %s", err)
+	}
+
+	cmdName := "iptables-restore"
+	if ipv6 {
+		cmdName = "ip6tables-restore"
+	}
+
+	return runRestoreCmd(cmdName, rulesFile)
+}
+
+// CleanupIPTables removes rules and chains generated for provided
+// configuration (see BuildIPTablesCleanup) from iptables (and ip6tables,
+// when IPv6 is enabled)
+func CleanupIPTables(cfg config.Config) (string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	output, err := cleanupIPTables(cfg, false)
+	if err != nil {
+		return "", fmt.Errorf("cannot cleanup ipv4 iptable rules: %s", err)
+	}
+
+	if cfg.IPv6 {
+		ipv6Output, err := cleanupIPTables(cfg, true)
+		if err != nil {
+			return "", fmt.Errorf("cannot cleanup ipv6 iptable rules: %s", err)
+		}
+
+		output += ipv6Output
+	}
+
+	return output, nil
+}
@@ -0,0 +1,115 @@
+package builder_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("cleanup", func() {
+	redirect := config.Redirect{NamePrefix: "KUMA_"}
+	comment := config.Comment{Enabled: true}
+
+	readInput := func() string {
+		input, err := os.ReadFile(filepath.Join("testdata", "counters.input.txt"))
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(input)
+	}
+
+	It("should remove generated rules and chains", func() {
+		// when
+		cleanup, err := builder.BuildIPTablesCleanup(config.Config{
+			Redirect: redirect,
+			Comment:  comment,
+		}, readInput())
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cleanup).To(MatchGoldenEqual("testdata", "cleanup.golden.txt"))
+	})
+
+	It("should remove rules jumping to generated chains unless comments are enabled", func() {
+		// when
+		cleanup, err := builder.BuildIPTablesCleanup(config.Config{Redirect: redirect}, readInput())
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cleanup).To(MatchGoldenEqual("testdata", "cleanup_comments_disabled.golden.txt"))
+	})
+
+	It("should remove untagged rules generated for the configuration", func() {
+		// given
+		input, err := os.ReadFile(filepath.Join("testdata", "cleanup_comments_disabled.input.txt"))
+		Expect(err).ToNot(HaveOccurred())
+
+		// when
+		cleanup, err := builder.BuildIPTablesCleanup(config.Config{
+			Redirect: config.Redirect{
+				NamePrefix:               "KUMA_",
+				ExcludeInterfaces:        []string{"wg0"},
+				ExcludeInboundInterfaces: []string{"eth1"},
+			},
+			DropInvalidPackets: true,
+		}, string(input))
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cleanup).To(MatchGoldenEqual("testdata", "cleanup_untagged.golden.txt"))
+	})
+
+	It("should return nothing when rules are not installed", func() {
+		// given
+		input := "*nat\n:PREROUTING ACCEPT [0:0]\n-A PREROUTING -j DOCKER\nCOMMIT\n"
+
+		// when
+		cleanup, err := builder.BuildIPTablesCleanup(config.Config{
+			Redirect: redirect,
+			Comment:  comment,
+		}, input)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cleanup).To(BeEmpty())
+	})
+})
+
+var _ = Describe("status", func() {
+	It("should return installed chains and counters", func() {
+		// given
+		input, err := os.ReadFile(filepath.Join("testdata", "counters.input.txt"))
+		Expect(err).ToNot(HaveOccurred())
+
+		// when
+		status, err := builder.ParseStatus(config.Config{
+			Redirect: config.Redirect{NamePrefix: "KUMA_"},
+			Comment:  config.Comment{Enabled: true},
+		}, string(input), false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Installed).To(BeTrue())
+		Expect(status.Chains).To(Equal([]builder.InstalledChain{
+			{Table: "nat", Chain: "KUMA_MESH_INBOUND"},
+			{Table: "nat", Chain: "KUMA_MESH_INBOUND_REDIRECT"},
+			{Table: "nat", Chain: "KUMA_MESH_OUTBOUND"},
+			{Table: "nat", Chain: "KUMA_MESH_OUTBOUND_REDIRECT"},
+		}))
+		Expect(status.Counters).To(HaveLen(10))
+	})
+
+	It("should not be installed without generated chains", func() {
+		// when
+		status, err := builder.ParseStatus(config.Config{}, "*nat\n:PREROUTING ACCEPT [0:0]\nCOMMIT\n", true)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(builder.Status{IPv6: true}))
+	})
+})
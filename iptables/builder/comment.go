@@ -0,0 +1,63 @@
+package builder
+
+import (
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// Purposes of the rules, which are placed in their comments after the configured
+// prefix (i.e. kuma/outbound/exclude-port)
+const (
+	purposeInbound                    = "inbound"
+	purposeInboundDisabled            = "inbound/disabled"
+	purposeInboundExcludeInterface    = "inbound/exclude-interface"
+	purposeInboundExcludeCIDR         = "inbound/exclude-cidr"
+	purposeInboundExcludePort         = "inbound/exclude-port"
+	purposeInboundIncludePort         = "inbound/include-port"
+	purposeInboundRedirect            = "inbound/redirect"
+	purposeInboundRedirectPort        = "inbound/redirect-port"
+	purposeOutbound                   = "outbound"
+	purposeOutboundDisabled           = "outbound/disabled"
+	purposeOutboundExcludeInterface   = "outbound/exclude-interface"
+	purposeOutboundExcludeCIDR        = "outbound/exclude-cidr"
+	purposeOutboundExcludePort        = "outbound/exclude-port"
+	purposeOutboundIncludePort        = "outbound/include-port"
+	purposeOutboundInboundPassthrough = "outbound/inbound-passthrough"
+	purposeOutboundLocalInbound       = "outbound/local-inbound"
+	purposeOutboundSkipLoopback       = "outbound/skip-loopback"
+	purposeOutboundSkipProxy          = "outbound/skip-proxy"
+	purposeOutboundSkipLocalhost      = "outbound/skip-localhost"
+	purposeOutboundRedirect           = "outbound/redirect"
+	purposeOutboundRedirectPort       = "outbound/redirect-port"
+	purposeDNSSkipProxy               = "dns/skip-proxy"
+	purposeDNSSkipSystemdResolved     = "dns/skip-systemd-resolved"
+	purposeDNSRedirect                = "dns/redirect"
+	purposeDNSConntrackZone           = "dns/conntrack-zone"
+	purposeDNSConntrackZoneUpstream   = "dns/conntrack-zone-upstream"
+	purposeDNSConntrackZoneProxy      = "dns/conntrack-zone-proxy"
+	purposeHardening                  = "hardening"
+	purposeAllowNeighbourDiscovery    = "allow-neighbour-discovery"
+	purposeDropInvalid                = "drop-invalid"
+	purposeDropInvalidTCPFlags        = "drop-invalid-tcp-flags"
+	purposeEgress                     = "egress"
+	purposeEgressAllowLoopback        = "egress/allow-loopback"
+	purposeEgressAllowProxy           = "egress/allow-proxy"
+	purposeEgressAllowEstablished     = "egress/allow-established"
+	purposeEgressAllowSystemdResolved = "egress/allow-systemd-resolved"
+	purposeEgressAllowDNS             = "egress/allow-dns"
+	purposeEgressExcludeInterface     = "egress/exclude-interface"
+	purposeEgressExcludeCIDR          = "egress/exclude-cidr"
+	purposeEgressExcludePort          = "egress/exclude-port"
+	purposeEgressBlock                = "egress/block"
+)
+
+// comment returns the comment match tagging the rule with the configured prefix
+// and provided purpose, or nil (which is ignored when the rule is built)
+// unless comments are enabled
+func comment(cfg config.Comment, purpose string) *Parameter {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	return Match(Comment(cfg.Prefix + "/" + purpose))
+}
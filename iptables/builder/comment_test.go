@@ -0,0 +1,116 @@
+package builder_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("comments", func() {
+	redirect := config.Redirect{
+		Inbound: config.TrafficFlow{
+			Enabled:      true,
+			ExcludePorts: []uint16{22},
+		},
+		Outbound: config.TrafficFlow{
+			Enabled:      true,
+			ExcludeCIDRs: []string{"10.0.0.0/8"},
+		},
+		ExcludeInterfaces: []string{"wg0"},
+	}
+
+	DescribeTable("should tag rules",
+		func(comment config.Comment, goldenFile string) {
+			// given
+			cfg := config.Config{
+				Redirect:           redirect,
+				DropInvalidPackets: true,
+				Comment:            comment,
+				RuntimeStdout:      io.Discard,
+			}
+
+			// when
+			got, err := builder.BuildIPTables(cfg, nil, false)
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got).To(MatchGoldenEqual("testdata", goldenFile))
+		},
+		Entry("with the default prefix",
+			config.Comment{Enabled: true},
+			"comments_default.golden.txt",
+		),
+		Entry("with a custom prefix containing whitespaces",
+			config.Comment{Enabled: true, Prefix: "kuma mesh"},
+			"comments_custom_prefix.golden.txt",
+		),
+		Entry("unless enabled",
+			config.Config{}.Comment,
+			"comments_disabled.golden.txt",
+		),
+	)
+
+	It("should tag every appended rule", func() {
+		// when
+		got, err := builder.BuildIPTables(config.Config{
+			Redirect:           redirect,
+			DropInvalidPackets: true,
+			Comment:            config.Comment{Enabled: true},
+			RuntimeStdout:      io.Discard,
+		}, nil, false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		for _, line := range strings.Split(got, "\n") {
+			if strings.HasPrefix(line, "-A ") {
+				Expect(line).To(ContainSubstring("-m comment --comment kuma/"))
+			}
+		}
+	})
+
+	It("should fail for too long prefix", func() {
+		// when
+		_, err := builder.BuildIPTables(config.Config{
+			Redirect: redirect,
+			Comment:  config.Comment{Enabled: true, Prefix: strings.Repeat("k", 201)},
+		}, nil, false)
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring("is longer than 200 characters")))
+	})
+
+	Describe("ShouldComment", func() {
+		It("should not tag rules unless comments are enabled", func() {
+			// given
+			cfg := config.Config{}
+
+			// then
+			Expect(cfg.ShouldComment()).To(BeFalse())
+		})
+
+		It("should fall back to no comments with a warning when the extension is not available", func() {
+			// given
+			GinkgoT().Setenv("PATH", GinkgoT().TempDir())
+			stdout := &bytes.Buffer{}
+			cfg := config.Config{
+				Comment:       config.Comment{Enabled: true},
+				RuntimeStdout: stdout,
+			}
+
+			// when
+			should := cfg.ShouldComment()
+
+			// then
+			Expect(should).To(BeFalse())
+			Expect(stdout.String()).To(ContainSubstring("[WARNING]"))
+			Expect(stdout.String()).To(ContainSubstring("Rules won't be tagged with comments"))
+		})
+	})
+})
@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// ctZoneFlags are the flags of the CT target, which are setting the zone
+var ctZoneFlags = []string{"--zone", "--zone-orig", "--zone-reply"}
+
+// parseConntrackZones returns conntrack zones used by rules in provided
+// iptables-save output, with the first rule using each of the zones. Rules
+// generated for provided configuration (placed in the generated chains,
+// tagged as the DNS conntrack zone ones, or the same as the ones which would
+// be generated) are ignored, so the installation can be repeated
+func parseConntrackZones(cfg config.Config, save string) (map[uint16]string, error) {
+	_, rules, err := parseSave(save)
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := generatedRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	generated := map[string]struct{}{}
+	for _, name := range getChainNames(cfg) {
+		generated[name] = struct{}{}
+	}
+
+	zones := map[uint16]string{}
+
+	for _, rule := range rules {
+		if _, ok := generated[rule.chain]; ok {
+			continue
+		}
+
+		if purpose, ok := rule.purpose(cfg.Comment.Prefix + "/"); ok &&
+			strings.HasPrefix(purpose, purposeDNSConntrackZone) {
+			continue
+		}
+
+		if _, ok := owned[rule.table+" "+rule.chain+" "+rule.key()]; ok {
+			continue
+		}
+
+		for i := 0; i < len(rule.args)-1; i++ {
+			for _, flag := range ctZoneFlags {
+				if rule.args[i] != flag {
+					continue
+				}
+
+				// zone can be also set to "mark" (i.e. --zone mark),
+				// which we are ignoring
+				zone, err := strconv.ParseUint(rule.args[i+1], 10, 16)
+				if err != nil {
+					continue
+				}
+
+				if _, ok := zones[uint16(zone)]; !ok {
+					zones[uint16(zone)] = fmt.Sprintf("-A %s %s", rule.chain, rule.specification)
+				}
+			}
+		}
+	}
+
+	return zones, nil
+}
+
+// validateConntrackZonesAreNotUsed will return an error if conntrack zones
+// configured for DNS zone splitting are already used by existing rules
+// in the raw table (i.e. by other CNI plugins like Calico or Cilium)
+func validateConntrackZonesAreNotUsed(cfg config.Config, ipv6 bool) error {
+	cmdName := "iptables-save"
+	if ipv6 {
+		cmdName = "ip6tables-save"
+	}
+
+	output, err := exec.Command(cmdName, "-t", "raw").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("executing %s failed: %s (with output: %q)", cmdName, err, output)
+	}
+
+	usedZones, err := parseConntrackZones(cfg, string(output))
+	if err != nil {
+		return fmt.Errorf("cannot parse %s output: %s", cmdName, err)
+	}
+
+	for _, zone := range []uint16{
+		cfg.Redirect.DNS.UpstreamConntrackZone,
+		cfg.Redirect.DNS.ProxyConntrackZone,
+	} {
+		if rule, ok := usedZones[zone]; ok {
+			return fmt.Errorf("conntrack zone %d is already used by existing rule "+
+				"in the raw table: %q", zone, rule)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("parseConntrackZones", func() {
+	parse := func(cfg config.Config, save string) map[uint16]string {
+		zones, err := parseConntrackZones(config.MergeConfigWithDefaults(cfg), save)
+		Expect(err).ToNot(HaveOccurred())
+
+		return zones
+	}
+
+	dnsConfig := config.Config{
+		Redirect: config.Redirect{
+			DNS: config.DNS{
+				Enabled:            true,
+				ConntrackZoneSplit: true,
+				CaptureAll:         true,
+			},
+		},
+	}
+
+	It("should return zones used by foreign rules", func() {
+		// given
+		save := "*raw\n" +
+			":OUTPUT ACCEPT [0:0]\n" +
+			"-A OUTPUT -p udp -m udp --dport 53 -j CT --zone 1\n" +
+			"-A OUTPUT -p udp -m udp --sport 53 -j CT --zone 1\n" +
+			"COMMIT\n"
+
+		// when
+		zones := parse(config.Config{}, save)
+
+		// then
+		Expect(zones).To(Equal(map[uint16]string{
+			1: "-A OUTPUT -p udp -m udp --dport 53 -j CT --zone 1",
+		}))
+	})
+
+	It("should return zones set by --zone-orig and --zone-reply", func() {
+		// given
+		save := "*raw\n" +
+			"-A PREROUTING -i cali+ -j CT --zone-orig 5\n" +
+			"-A OUTPUT -o cali+ -j CT --zone-reply 6\n" +
+			"COMMIT\n"
+
+		// when
+		zones := parse(config.Config{}, save)
+
+		// then
+		Expect(zones).To(HaveKey(uint16(5)))
+		Expect(zones).To(HaveKey(uint16(6)))
+	})
+
+	It("should ignore zones set from the mark", func() {
+		// given
+		save := "*raw\n" +
+			"-A PREROUTING -j CT --zone mark\n" +
+			"-A OUTPUT -j CT --zone-orig mark\n" +
+			"COMMIT\n"
+
+		// when
+		zones := parse(config.Config{}, save)
+
+		// then
+		Expect(zones).To(BeEmpty())
+	})
+
+	It("should ignore rules in the generated chains and tagged rules", func() {
+		// given
+		save := "*raw\n" +
+			":OUTPUT ACCEPT [0:0]\n" +
+			":MESH_DNS_CT_OUTPUT - [0:0]\n" +
+			"-A OUTPUT -p udp -m udp --dport 53 -m owner --uid-owner 5678 " +
+			"-m comment --comment kuma/dns/conntrack-zone-upstream -j CT --zone 1\n" +
+			"-A OUTPUT -p udp -m udp --dport 53 -m comment --comment kuma/dns/conntrack-zone " +
+			"-j MESH_DNS_CT_OUTPUT\n" +
+			"-A MESH_DNS_CT_OUTPUT -d 10.0.0.10/32 -j CT --zone 2\n" +
+			"COMMIT\n"
+
+		// when
+		zones := parse(config.Config{}, save)
+
+		// then
+		Expect(zones).To(BeEmpty())
+	})
+
+	It("should ignore untagged rules generated for the configuration", func() {
+		// given
+		cfg := dnsConfig
+		cfg.RuntimeStdout = io.Discard
+
+		// conntrack zone splitting rules are generated only when the conntrack
+		// iptables module is present
+		if !config.MergeConfigWithDefaults(cfg).ShouldConntrackZoneSplit() {
+			Skip("conntrack iptables module is not present")
+		}
+
+		save := "*raw\n" +
+			"-A OUTPUT -p udp -m udp --dport 53 -m owner --uid-owner 5678 -j CT --zone 1\n" +
+			"-A OUTPUT -p udp -m udp --dport 53 -j CT --zone 2\n" +
+			"-A PREROUTING -p udp -m udp --sport 53 -j CT --zone 1\n" +
+			"-A PREROUTING -p udp -m udp --sport 5353 -j CT --zone 2\n" +
+			"COMMIT\n"
+
+		// when
+		zones := parse(cfg, save)
+
+		// then
+		Expect(zones).To(Equal(map[uint16]string{
+			2: "-A PREROUTING -p udp -m udp --sport 5353 -j CT --zone 2",
+		}))
+	})
+})
@@ -0,0 +1,239 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kumahq/kuma-net/internal/restore"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// RuleCounter contains packet and byte counters of the generated rule
+type RuleCounter struct {
+	IPv6  bool
+	Table string
+	Chain string
+	// Purpose is the purpose of the rule placed in its comment after
+	// the configured prefix (i.e. outbound/exclude-port)
+	Purpose string
+	// Rule is the specification of the rule, as printed by iptables-save
+	// (i.e. -p tcp --dport 8080 -m comment --comment kuma/outbound/exclude-port -j RETURN)
+	Rule    string
+	Packets uint64
+	Bytes   uint64
+}
+
+// parseCounters parses counters in format "[packets:bytes]"
+func parseCounters(counters string) (uint64, uint64, error) {
+	if !strings.HasPrefix(counters, "[") || !strings.HasSuffix(counters, "]") {
+		return 0, 0, fmt.Errorf("invalid counters %q", counters)
+	}
+
+	packets, bytes, ok := strings.Cut(counters[1:len(counters)-1], ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid counters %q", counters)
+	}
+
+	p, err := strconv.ParseUint(packets, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid packets counter %q: %s", packets, err)
+	}
+
+	b, err := strconv.ParseUint(bytes, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid bytes counter %q: %s", bytes, err)
+	}
+
+	return p, b, nil
+}
+
+// ParseRuleCounters returns counters of the rules tagged with the configured
+// comment prefix from provided output of iptables-save -c (or ip6tables-save
+// -c when ipv6 is set). Other rules are ignored. Counters of rules printed
+// without them (i.e. by iptables-save without -c, or by BuildIPTables) are zero
+func ParseRuleCounters(cfg config.Config, save string, ipv6 bool) ([]RuleCounter, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	if !cfg.Comment.Enabled {
+		return nil, fmt.Errorf("rule counters cannot be mapped to purposes " +
+			"of the rules unless comments are enabled")
+	}
+
+	_, rules, err := parseSave(save)
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []RuleCounter
+
+	for _, rule := range rules {
+		purpose, ok := rule.purpose(cfg.Comment.Prefix + "/")
+		if !ok {
+			continue
+		}
+
+		var packets, bytes uint64
+		if rule.counters != "" {
+			if packets, bytes, err = parseCounters(rule.counters); err != nil {
+				return nil, fmt.Errorf("chain %s: %s", rule.chain, err)
+			}
+		}
+
+		counters = append(counters, RuleCounter{
+			IPv6:    ipv6,
+			Table:   rule.table,
+			Chain:   rule.chain,
+			Purpose: purpose,
+			Rule:    rule.specification,
+			Packets: packets,
+			Bytes:   bytes,
+		})
+	}
+
+	return counters, nil
+}
+
+func getRuleCounters(cfg config.Config, ipv6 bool) ([]RuleCounter, error) {
+	output, err := runSaveCmd(ipv6, "-c")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRuleCounters(cfg, output, ipv6)
+}
+
+// GetRuleCounters returns packet and byte counters of the rules generated
+// for provided configuration, read from iptables-save (and ip6tables-save,
+// when IPv6 is enabled)
+func GetRuleCounters(cfg config.Config) ([]RuleCounter, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	counters, err := getRuleCounters(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.IPv6 {
+		ipv6Counters, err := getRuleCounters(cfg, true)
+		if err != nil {
+			return nil, err
+		}
+
+		counters = append(counters, ipv6Counters...)
+	}
+
+	return counters, nil
+}
+
+const (
+	metricRulePackets = "kuma_net_rule_packets_total"
+	metricRuleBytes   = "kuma_net_rule_bytes_total"
+)
+
+type metricLabels struct {
+	family  string
+	table   string
+	chain   string
+	purpose string
+	match   string
+}
+
+func (l metricLabels) String() string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	return fmt.Sprintf(`{family="%s",table="%s",chain="%s",purpose="%s",match="%s"}`,
+		replacer.Replace(l.family),
+		replacer.Replace(l.table),
+		replacer.Replace(l.chain),
+		replacer.Replace(l.purpose),
+		replacer.Replace(l.match),
+	)
+}
+
+// ruleMatches returns matches of provided rule specification (without
+// the comment and the target), which tell apart rules with the same purpose
+// (i.e. "-p tcp -m tcp --dport 8080" of the rule excluding the port 8080)
+func ruleMatches(rule string) string {
+	args, err := restore.Tokenize(rule)
+	if err != nil {
+		return rule
+	}
+
+	var matches []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-j", "--jump", "-g", "--goto":
+			return strings.Join(matches, " ")
+		case "-m", "--match":
+			if i+1 < len(args) && args[i+1] == "comment" {
+				i++
+				continue
+			}
+		case "--comment":
+			i++
+			continue
+		}
+
+		matches = append(matches, args[i])
+	}
+
+	return strings.Join(matches, " ")
+}
+
+// WriteRuleCountersMetrics writes provided counters in the Prometheus text
+// exposition format. Rules are told apart by their matches, so only counters
+// of the same rules in the same chain (i.e. duplicated ones) are summed up
+func WriteRuleCountersMetrics(w io.Writer, counters []RuleCounter) error {
+	var labels []metricLabels
+	packets := map[metricLabels]uint64{}
+	bytes := map[metricLabels]uint64{}
+
+	for _, counter := range counters {
+		family := "ipv4"
+		if counter.IPv6 {
+			family = "ipv6"
+		}
+
+		l := metricLabels{
+			family:  family,
+			table:   counter.Table,
+			chain:   counter.Chain,
+			purpose: counter.Purpose,
+			match:   ruleMatches(counter.Rule),
+		}
+
+		if _, ok := packets[l]; !ok {
+			labels = append(labels, l)
+		}
+
+		packets[l] += counter.Packets
+		bytes[l] += counter.Bytes
+	}
+
+	var lines []string
+
+	for _, metric := range []struct {
+		name   string
+		help   string
+		values map[metricLabels]uint64
+	}{
+		{metricRulePackets, "Packets matched by the generated iptables rules", packets},
+		{metricRuleBytes, "Bytes matched by the generated iptables rules", bytes},
+	} {
+		lines = append(lines,
+			fmt.Sprintf("# HELP %s %s", metric.name, metric.help),
+			fmt.Sprintf("# TYPE %s counter", metric.name),
+		)
+
+		for _, l := range labels {
+			lines = append(lines, fmt.Sprintf("%s%s %d", metric.name, l, metric.values[l]))
+		}
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+
+	return err
+}
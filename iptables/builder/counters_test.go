@@ -0,0 +1,108 @@
+package builder_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("rule counters", func() {
+	commented := config.Config{Comment: config.Comment{Enabled: true}}
+
+	readInput := func() string {
+		input, err := os.ReadFile(filepath.Join("testdata", "counters.input.txt"))
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(input)
+	}
+
+	It("should map counters of generated rules to their purposes", func() {
+		// when
+		counters, err := builder.ParseRuleCounters(commented, readInput(), false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(counters).To(HaveLen(10))
+		Expect(counters[4]).To(Equal(builder.RuleCounter{
+			Table:   "nat",
+			Chain:   "KUMA_MESH_INBOUND_REDIRECT",
+			Purpose: "inbound/redirect-port",
+			Rule:    "-p tcp -m comment --comment kuma/inbound/redirect-port -j REDIRECT --to-ports 15006",
+			Packets: 116,
+			Bytes:   6960,
+		}))
+		Expect(counters[9]).To(Equal(builder.RuleCounter{
+			Table:   "mangle",
+			Chain:   "PREROUTING",
+			Purpose: "drop-invalid",
+			Rule:    "-m conntrack --ctstate INVALID -m comment --comment kuma/drop-invalid -j DROP",
+			Packets: 7,
+			Bytes:   420,
+		}))
+	})
+
+	It("should parse counters of IPv6 rules with quoted comments", func() {
+		// given
+		input := "*nat\n" +
+			`[9:720] -A OUTPUT -p tcp -m comment --comment "kuma mesh/outbound" -j KUMA_MESH_OUTBOUND` + "\n" +
+			"COMMIT\n"
+
+		// when
+		counters, err := builder.ParseRuleCounters(config.Config{
+			Comment: config.Comment{Enabled: true, Prefix: "kuma mesh"},
+		}, input, true)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(counters).To(Equal([]builder.RuleCounter{{
+			IPv6:    true,
+			Table:   "nat",
+			Chain:   "OUTPUT",
+			Purpose: "outbound",
+			Rule:    `-p tcp -m comment --comment "kuma mesh/outbound" -j KUMA_MESH_OUTBOUND`,
+			Packets: 9,
+			Bytes:   720,
+		}}))
+	})
+
+	It("should fail unless comments are enabled", func() {
+		// when
+		_, err := builder.ParseRuleCounters(config.Config{}, readInput(), false)
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring("unless comments are enabled")))
+	})
+
+	It("should fail for invalid counters", func() {
+		// when
+		_, err := builder.ParseRuleCounters(commented,
+			"*nat\n[1:x] -A OUTPUT -m comment --comment kuma/outbound -j RETURN\n", false)
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring("invalid bytes counter")))
+	})
+
+	It("should write metrics", func() {
+		// given
+		ipv4, err := builder.ParseRuleCounters(commented, readInput(), false)
+		Expect(err).ToNot(HaveOccurred())
+		ipv6, err := builder.ParseRuleCounters(commented, readInput(), true)
+		Expect(err).ToNot(HaveOccurred())
+
+		buf := &bytes.Buffer{}
+
+		// when
+		err = builder.WriteRuleCountersMetrics(buf, append(ipv4, ipv6...))
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(buf.String()).To(MatchGoldenEqual("testdata", "counters_metrics.golden.txt"))
+	})
+})
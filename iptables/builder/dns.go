@@ -3,10 +3,19 @@ package builder
 import (
 	"fmt"
 	"net"
+	"os"
+	"os/user"
+	"strings"
 
 	"github.com/miekg/dns"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
 )
 
+// systemdResolvedStubAddresses are the addresses of systemd-resolved stub
+// resolvers (127.0.0.54 is the proxy-only one, available since systemd 248)
+var systemdResolvedStubAddresses = []string{"127.0.0.53", "127.0.0.54"}
+
 func GetDnsServers(cfgPath string) ([]string, []string, error) {
 	dnsConfig, err := dns.ClientConfigFromFile(cfgPath)
 	if err != nil {
@@ -16,6 +25,138 @@ func GetDnsServers(cfgPath string) ([]string, []string, error) {
 	return ipv4, ipv6, nil
 }
 
+// GetDnsServersFromConfig returns IPv4 and IPv6 addresses of DNS servers which
+// should be captured when not all the DNS traffic is captured. These are
+// the explicitly configured servers merged with the ones from the resolv.conf
+// file (which can be missing if there are any explicitly configured servers)
+// and, if it's pointing to systemd-resolved stub resolver, with real upstream
+// servers of systemd-resolved, without link-local addresses and stub resolvers if configured so
+func GetDnsServersFromConfig(cfg config.Config) ([]string, []string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+	dnsCfg := cfg.Redirect.DNS
+
+	var servers []string
+
+	for _, server := range dnsCfg.Servers {
+		if parseServerIP(server) == nil {
+			return nil, nil, fmt.Errorf("invalid DNS server address: %q", server)
+		}
+
+		servers = append(servers, server)
+	}
+
+	if dnsCfg.ResolvConfigPath != "" {
+		dnsConfig, err := dns.ClientConfigFromFile(dnsCfg.ResolvConfigPath)
+		switch {
+		case err == nil:
+			servers = append(servers, dnsConfig.Servers...)
+
+			upstreams, err := getSystemdResolvedUpstreams(dnsConfig.Servers, dnsCfg.SystemdResolvedConfigPath)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			servers = append(servers, upstreams...)
+		case os.IsNotExist(err) && len(servers) > 0:
+			// explicitly configured servers are enough
+		default:
+			return nil, nil, fmt.Errorf("unable to read file %s: %s", dnsCfg.ResolvConfigPath, err)
+		}
+	}
+
+	var result []string
+	seen := map[string]struct{}{}
+
+	for _, server := range servers {
+		if _, ok := seen[server]; ok {
+			continue
+		}
+		seen[server] = struct{}{}
+
+		if ip := parseServerIP(server); ip != nil {
+			if dnsCfg.ExcludeLinkLocalServers && ip.IsLinkLocalUnicast() {
+				continue
+			}
+
+			if dnsCfg.ExcludeStubResolvers && ip.IsLoopback() {
+				continue
+			}
+		}
+
+		result = append(result, server)
+	}
+
+	ipv4, ipv6 := groupIps(result)
+
+	return ipv4, ipv6, nil
+}
+
+// getSystemdResolvedUpstreams returns real upstream DNS servers used by
+// systemd-resolved, when provided servers contain its stub resolver. Missing
+// systemd-resolved configuration is not an error, as the stub address can
+// be used by a different resolver
+func getSystemdResolvedUpstreams(servers []string, cfgPath string) ([]string, error) {
+	if cfgPath == "" || !containsSystemdResolvedStub(servers) {
+		return nil, nil
+	}
+
+	dnsConfig, err := dns.ClientConfigFromFile(cfgPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to read file %s: %s", cfgPath, err)
+	}
+
+	var upstreams []string
+	for _, server := range dnsConfig.Servers {
+		// capturing the stub as its own upstream (i.e. when the path
+		// is pointing to the stub-resolv.conf file) would cause a loop
+		if !containsSystemdResolvedStub([]string{server}) {
+			upstreams = append(upstreams, server)
+		}
+	}
+
+	return upstreams, nil
+}
+
+func containsSystemdResolvedStub(servers []string) bool {
+	for _, server := range servers {
+		for _, stub := range systemdResolvedStubAddresses {
+			if server == stub {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// getSystemdResolvedUID returns the uid of the user systemd-resolved is
+// running as, or an empty string if the user is not configured. It fails
+// when the configured user cannot be found, as without its rules queries
+// of systemd-resolved would be redirected back to the proxy
+func getSystemdResolvedUID(cfg config.Config) (string, error) {
+	if cfg.Redirect.DNS.SystemdResolvedUser == "" {
+		return "", nil
+	}
+
+	u, err := user.Lookup(cfg.Redirect.DNS.SystemdResolvedUser)
+	if err != nil {
+		return "", fmt.Errorf("cannot look up the systemd-resolved user %q: %s",
+			cfg.Redirect.DNS.SystemdResolvedUser, err)
+	}
+
+	return u.Uid, nil
+}
+
+// parseServerIP parses the address of DNS server, which in case of IPv6
+// link-local addresses can contain the zone (i.e. fe80::1%eth0)
+func parseServerIP(server string) net.IP {
+	return net.ParseIP(strings.SplitN(server, "%", 2)[0])
+}
+
 func groupIps(addresses []string) ([]string, []string) {
 	var ipv4 []string
 	var ipv6 []string
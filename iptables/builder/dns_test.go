@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("getSystemdResolvedUpstreams", func() {
+	var resolvConf string
+
+	BeforeEach(func() {
+		resolvConf = filepath.Join(GinkgoT().TempDir(), "resolv.conf")
+		Expect(os.WriteFile(resolvConf, []byte(
+			"nameserver 10.0.0.10\nnameserver 127.0.0.53\nnameserver fd00::10\n",
+		), 0o600)).To(Succeed())
+	})
+
+	It("should return upstreams without the stub resolver", func() {
+		// when
+		upstreams, err := getSystemdResolvedUpstreams([]string{"127.0.0.53"}, resolvConf)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(upstreams).To(Equal([]string{"10.0.0.10", "fd00::10"}))
+	})
+
+	It("should return upstreams for the proxy-only stub resolver", func() {
+		// when
+		upstreams, err := getSystemdResolvedUpstreams([]string{"1.1.1.1", "127.0.0.54"}, resolvConf)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(upstreams).To(Equal([]string{"10.0.0.10", "fd00::10"}))
+	})
+
+	DescribeTable("should return nothing",
+		func(servers []string, path func() string) {
+			// when
+			upstreams, err := getSystemdResolvedUpstreams(servers, path())
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+			Expect(upstreams).To(BeEmpty())
+		},
+		Entry("without the stub resolver", []string{"1.1.1.1"}, func() string { return resolvConf }),
+		Entry("without the configuration path", []string{"127.0.0.53"}, func() string { return "" }),
+		Entry("when the configuration is missing", []string{"127.0.0.53"}, func() string {
+			return filepath.Join(GinkgoT().TempDir(), "missing.conf")
+		}),
+	)
+})
+
+var _ = Describe("getSystemdResolvedUID", func() {
+	It("should not look up any user by default", func() {
+		// when
+		uid, err := getSystemdResolvedUID(config.MergeConfigWithDefaults(config.Config{}))
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uid).To(BeEmpty())
+	})
+
+	It("should fail for unknown user", func() {
+		// given
+		cfg := config.Config{
+			Redirect: config.Redirect{
+				DNS: config.DNS{SystemdResolvedUser: "kuma-net-unknown-user"},
+			},
+		}
+
+		// when
+		_, err := getSystemdResolvedUID(config.MergeConfigWithDefaults(cfg))
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring(`cannot look up the systemd-resolved user "kuma-net-unknown-user"`)))
+	})
+
+	It("should fail to build rules for unknown user", func() {
+		// given
+		cfg := config.Config{
+			Redirect: config.Redirect{
+				Inbound:  config.TrafficFlow{Enabled: true},
+				Outbound: config.TrafficFlow{Enabled: true},
+				DNS: config.DNS{
+					Enabled:             true,
+					CaptureAll:          true,
+					SystemdResolvedUser: "kuma-net-unknown-user",
+				},
+			},
+			RuntimeStdout: io.Discard,
+		}
+
+		// when
+		_, err := BuildIPTables(cfg, nil, false)
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring(`cannot look up the systemd-resolved user "kuma-net-unknown-user"`)))
+	})
+})
@@ -0,0 +1,240 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+const (
+	// maxIPSetNameLen is the maximal length of the ipset name
+	// (IPSET_MAXNAMELEN without the terminating null byte)
+	maxIPSetNameLen = 31
+	// defaultIPSetMaxElem is the default maximal amount of entries
+	// in hash:net sets
+	defaultIPSetMaxElem = 65536
+
+	ipSetNetSuffix     = "_EXCL_NET"
+	ipSetNetIPv6Suffix = "_EXCL_NET6"
+	ipSetPortSuffix    = "_EXCL_PORT"
+	// ipSetTmpSuffix is the suffix of temporary sets, which are filled
+	// and then swapped with the sets referenced by iptables rules, so the rules
+	// will never see partially filled sets
+	ipSetTmpSuffix = "_T"
+)
+
+type ipSet struct {
+	name    string
+	create  string
+	entries []string
+}
+
+func getIPSetName(flow config.TrafficFlow, prefix string, suffix string) string {
+	return flow.Chain.GetFullName(prefix) + suffix
+}
+
+func getIPSetNames(cfg config.Config) []string {
+	var names []string
+
+	for _, flow := range []config.TrafficFlow{cfg.Redirect.Inbound, cfg.Redirect.Outbound} {
+		for _, suffix := range []string{ipSetNetSuffix, ipSetNetIPv6Suffix, ipSetPortSuffix} {
+			names = append(names, getIPSetName(flow, cfg.Redirect.NamePrefix, suffix))
+		}
+	}
+
+	return names
+}
+
+func validateIPSetNames(cfg config.Config) error {
+	for _, name := range getIPSetNames(cfg) {
+		if len(name+ipSetTmpSuffix) > maxIPSetNameLen {
+			return fmt.Errorf("ipset name %q (with the temporary set suffix %q) "+
+				"is longer than %d characters, use shorter chain names or prefix",
+				name, ipSetTmpSuffix, maxIPSetNameLen)
+		}
+	}
+
+	return nil
+}
+
+func newNetIPSet(name string, cidrs []string, ipv6 bool) *ipSet {
+	family := "inet"
+	if ipv6 {
+		family = "inet6"
+	}
+
+	create := fmt.Sprintf("hash:net family %s", family)
+	if len(cidrs) > defaultIPSetMaxElem {
+		create = fmt.Sprintf("%s maxelem %d", create, len(cidrs))
+	}
+
+	return &ipSet{name: name, create: create, entries: cidrs}
+}
+
+func newPortIPSet(name string, portRanges []config.PortRange) *ipSet {
+	var entries []string
+	for _, portRange := range portRanges {
+		entries = append(entries, portRange.String())
+	}
+
+	return &ipSet{name: name, create: "bitmap:port range 0-65535", entries: entries}
+}
+
+// getIPSets returns ipsets which should be created for provided configuration
+// (sets for empty exclusion lists are not created)
+func getIPSets(cfg config.Config) ([]*ipSet, error) {
+	if !cfg.Redirect.IPSets.Enabled {
+		return nil, nil
+	}
+
+	if err := validateIPSetNames(cfg); err != nil {
+		return nil, err
+	}
+
+	prefix := cfg.Redirect.NamePrefix
+
+	var sets []*ipSet
+
+	for _, flow := range []config.TrafficFlow{cfg.Redirect.Inbound, cfg.Redirect.Outbound} {
+		if !flow.Enabled {
+			continue
+		}
+
+		ipv4CIDRs, err := flow.GetExcludeCIDRs(false)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ipv4CIDRs) > 0 {
+			sets = append(sets, newNetIPSet(getIPSetName(flow, prefix, ipSetNetSuffix), ipv4CIDRs, false))
+		}
+
+		if cfg.IPv6 {
+			ipv6CIDRs, err := flow.GetExcludeCIDRs(true)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(ipv6CIDRs) > 0 {
+				sets = append(sets, newNetIPSet(getIPSetName(flow, prefix, ipSetNetIPv6Suffix), ipv6CIDRs, true))
+			}
+		}
+
+		// excluded ports are ignored when there are included ones
+		if len(flow.GetIncludePortRanges()) == 0 && len(flow.GetExcludePortRanges()) > 0 {
+			sets = append(sets, newPortIPSet(getIPSetName(flow, prefix, ipSetPortSuffix), flow.GetExcludePortRanges()))
+		}
+	}
+
+	return sets, nil
+}
+
+// BuildIPSets returns the ipset restore input, which is (re)creating sets
+// with excluded CIDRs and ports. Sets are filled as temporary ones and swapped
+// with the final ones, so existing iptables rules referencing them will never
+// match against partially filled sets
+func BuildIPSets(cfg config.Config) (string, error) {
+	sets, err := getIPSets(config.MergeConfigWithDefaults(cfg))
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+
+	for _, set := range sets {
+		tmpName := set.name + ipSetTmpSuffix
+
+		lines = append(lines,
+			fmt.Sprintf("create %s %s -exist", set.name, set.create),
+			fmt.Sprintf("create %s %s -exist", tmpName, set.create),
+			fmt.Sprintf("flush %s", tmpName),
+		)
+
+		for _, entry := range set.entries {
+			lines = append(lines, fmt.Sprintf("add %s %s -exist", tmpName, entry))
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("swap %s %s", tmpName, set.name),
+			fmt.Sprintf("destroy %s", tmpName),
+		)
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// RestoreIPSets creates (or updates already existing) ipsets with excluded
+// CIDRs and ports, which have to exist before iptables rules referencing them
+// are restored
+func RestoreIPSets(cfg config.Config) (string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	content, err := BuildIPSets(cfg)
+	if err != nil || content == "" {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "ipset-rules-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("unable to create ipset rules file: %s", err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	if err := saveIPTablesRestoreFile(cfg.RuntimeStdout, f, content); err != nil {
+		return "", fmt.Errorf("unable to save ipset restore file: %s", err)
+	}
+
+	output, err := exec.Command("ipset", "restore", "-file", f.Name()).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("executing command failed: %s (with output: %q)", err, output)
+	}
+
+	return string(output), nil
+}
+
+// CleanupIPSets destroys all the ipsets which could be created for provided
+// configuration. Sets which are still referenced by iptables rules cannot
+// be destroyed, so the rules have to be removed first
+func CleanupIPSets(cfg config.Config) (string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	var output []string
+
+	for _, name := range getIPSetNames(cfg) {
+		for _, setName := range []string{name + ipSetTmpSuffix, name} {
+			if cfg.DryRun {
+				output = append(output, fmt.Sprintf("ipset destroy %s", setName))
+				continue
+			}
+
+			out, err := exec.Command("ipset", "destroy", setName).CombinedOutput()
+			if err != nil {
+				if strings.Contains(string(out), "does not exist") {
+					continue
+				}
+
+				return "", fmt.Errorf("destroying ipset %s failed: %s (with output: %q)",
+					setName, err, out)
+			}
+
+			output = append(output, fmt.Sprintf("ipset %s destroyed", setName))
+		}
+	}
+
+	result := strings.Join(output, "\n")
+	if result != "" {
+		result += "\n"
+	}
+
+	_, _ = cfg.RuntimeStdout.Write([]byte(result))
+
+	return result, nil
+}
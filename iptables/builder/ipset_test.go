@@ -0,0 +1,68 @@
+package builder_test
+
+import (
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("ipsets", func() {
+	cfg := config.Config{
+		Redirect: config.Redirect{
+			NamePrefix: "KUMA_",
+			Inbound: config.TrafficFlow{
+				Enabled:      true,
+				ExcludePorts: []uint16{8080},
+			},
+			Outbound: config.TrafficFlow{
+				Enabled:           true,
+				ExcludeCIDRs:      []string{"203.0.113.0/24", "100.64.0.1", "2001:db8::/32"},
+				ExcludePortRanges: []config.PortRange{{From: 9000, To: 9010}},
+			},
+			IPSets: config.IPSets{Enabled: true},
+		},
+		IPv6:          true,
+		Comment:       config.Comment{Enabled: true},
+		RuntimeStdout: io.Discard,
+	}
+
+	It("should generate ipset restore input", func() {
+		// when
+		got, err := builder.BuildIPSets(cfg)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(MatchGoldenEqual("testdata", "ipsets.golden.txt"))
+	})
+
+	DescribeTable("should generate rules matching against ipsets",
+		func(ipv6 bool, goldenFile string) {
+			// when
+			got, err := builder.BuildIPTables(cfg, nil, ipv6)
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got).To(MatchGoldenEqual("testdata", goldenFile))
+		},
+		Entry("ipv4", false, "ipsets_rules.golden.txt"),
+		Entry("ipv6", true, "ipsets_rules_ipv6.golden.txt"),
+	)
+
+	It("should not generate ipsets unless enabled", func() {
+		// given
+		disabled := cfg
+		disabled.Redirect.IPSets.Enabled = false
+
+		// when
+		got, err := builder.BuildIPSets(disabled)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(BeEmpty())
+	})
+})
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package builder
+
+import (
+	"fmt"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// WatchResolvConf is only supported on linux
+func WatchResolvConf(cfg config.Config, stop <-chan struct{}) error {
+	return fmt.Errorf("watching resolv.conf is only supported on linux")
+}
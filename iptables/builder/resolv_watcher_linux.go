@@ -0,0 +1,209 @@
+//go:build linux
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+const (
+	// resolvConfWatchMask contains events which are generated when the file
+	// is being rewritten in place, replaced (i.e. by resolvconf or
+	// systemd-resolved, which are writing to the temporary file and renaming
+	// it) or removed
+	resolvConfWatchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_DELETE
+	// resolvConfPollTimeout is the time (in milliseconds) after which
+	// the watcher will check if it should stop
+	resolvConfPollTimeout = 500
+)
+
+// resolvConfPaths returns paths of the files, changes of which can result
+// in changes of the DNS servers. Symlinks (/etc/resolv.conf is often a symlink
+// to the file managed by systemd-resolved) are resolved, as changes of the
+// target file don't generate events for the symlink
+func resolvConfPaths(cfg config.Config) []string {
+	paths := []string{cfg.Redirect.DNS.ResolvConfigPath}
+
+	if target, err := filepath.EvalSymlinks(cfg.Redirect.DNS.ResolvConfigPath); err == nil {
+		paths = append(paths, target)
+	}
+
+	if cfg.Redirect.DNS.SystemdResolvedConfigPath != "" {
+		paths = append(paths, cfg.Redirect.DNS.SystemdResolvedConfigPath)
+	}
+
+	var result []string
+	seen := map[string]struct{}{}
+
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if _, ok := seen[path]; !ok {
+			seen[path] = struct{}{}
+			result = append(result, path)
+		}
+	}
+
+	return result
+}
+
+// readInotifyEvents returns names (joined with watched directories) of files
+// from events in provided buffer
+func readInotifyEvents(buf []byte, directories map[int32]string) []string {
+	var names []string
+
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + unix.SizeofInotifyEvent
+		nameEnd := nameStart + int(event.Len)
+
+		if nameEnd > len(buf) {
+			break
+		}
+
+		if directory, ok := directories[event.Wd]; ok && event.Len > 0 {
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			names = append(names, filepath.Join(directory, name))
+		}
+
+		offset = nameEnd
+	}
+
+	return names
+}
+
+// WatchResolvConf watches the resolv.conf file (and the one maintained
+// by systemd-resolved) and, when DNS servers listed there change, updates
+// rules capturing DNS traffic to the selected servers (see UpdateDnsServers).
+// Rules are updated once when the watcher starts, so changes made between
+// installing the rules and starting the watcher are not lost, and targets
+// of symlinks are resolved again after every change, so the watcher follows
+// the symlink pointed to a different file. It blocks until the stop channel
+// is closed. Errors occurring when updating the rules are not stopping
+// the watcher, and are written to RuntimeStderr
+func WatchResolvConf(cfg config.Config, stop <-chan struct{}) error {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	if !cfg.ShouldRedirectDNS() || cfg.ShouldCaptureAllDNS() {
+		return fmt.Errorf("watching resolv.conf requires DNS redirection " +
+			"without capturing all the DNS traffic")
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("unable to initialize inotify: %s", err)
+	}
+	defer unix.Close(fd)
+
+	var paths map[string]struct{}
+	directories := map[int32]string{}
+
+	// watch adds watches for directories of the (re-resolved) paths, adding
+	// a watch for already watched directory is a no-op
+	watch := func() error {
+		paths = map[string]struct{}{}
+
+		for _, path := range resolvConfPaths(cfg) {
+			paths[path] = struct{}{}
+
+			directory := filepath.Dir(path)
+			wd, err := unix.InotifyAddWatch(fd, directory, resolvConfWatchMask)
+			if err != nil {
+				// directory of the systemd-resolved file won't exist on hosts
+				// without systemd-resolved
+				if os.IsNotExist(err) && path != filepath.Clean(cfg.Redirect.DNS.ResolvConfigPath) {
+					continue
+				}
+
+				return fmt.Errorf("unable to watch %s: %s", directory, err)
+			}
+
+			directories[int32(wd)] = directory
+		}
+
+		return nil
+	}
+
+	if err := watch(); err != nil {
+		return err
+	}
+
+	var dnsIpv4, dnsIpv6 []string
+	updated := false
+
+	update := func() {
+		newDnsIpv4, newDnsIpv6, err := GetDnsServersFromConfig(cfg)
+		if err != nil {
+			_, _ = fmt.Fprintf(cfg.RuntimeStderr, "[WARNING] getting DNS servers failed: %v\n", err)
+			return
+		}
+
+		if updated && reflect.DeepEqual(newDnsIpv4, dnsIpv4) && reflect.DeepEqual(newDnsIpv6, dnsIpv6) {
+			return
+		}
+
+		if _, err := updateDnsServers(cfg, newDnsIpv4, newDnsIpv6); err != nil {
+			_, _ = fmt.Fprintf(cfg.RuntimeStderr, "[WARNING] updating DNS servers rules failed: %v\n", err)
+			return
+		}
+
+		dnsIpv4, dnsIpv6, updated = newDnsIpv4, newDnsIpv6, true
+	}
+
+	update()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	pollFds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		n, err := unix.Poll(pollFds, resolvConfPollTimeout)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("polling inotify events failed: %s", err)
+		}
+
+		read, err := unix.Read(fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("reading inotify events failed: %s", err)
+		}
+
+		changed := false
+		for _, name := range readInotifyEvents(buf[:read], directories) {
+			if _, ok := paths[name]; ok {
+				changed = true
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		// the symlink could be changed to point to a different file
+		if err := watch(); err != nil {
+			_, _ = fmt.Fprintf(cfg.RuntimeStderr, "[WARNING] watching resolv.conf failed: %v\n", err)
+		}
+
+		update()
+	}
+}
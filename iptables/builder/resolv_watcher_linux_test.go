@@ -0,0 +1,67 @@
+package builder
+
+import (
+	"bytes"
+	"unsafe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+// inotifyEvent returns the event in the format read from the inotify file
+// descriptor, with the name padded with null bytes
+func inotifyEvent(wd int32, name string, padding int) []byte {
+	padded := append([]byte(name), make([]byte, padding)...)
+	event := unix.InotifyEvent{Wd: wd, Mask: unix.IN_CLOSE_WRITE, Len: uint32(len(padded))}
+
+	var buf bytes.Buffer
+	buf.Write((*[unix.SizeofInotifyEvent]byte)(unsafe.Pointer(&event))[:])
+	buf.Write(padded)
+
+	return buf.Bytes()
+}
+
+var _ = Describe("readInotifyEvents", func() {
+	directories := map[int32]string{
+		1: "/etc",
+		2: "/run/systemd/resolve",
+	}
+
+	It("should return names of files joined with watched directories", func() {
+		// given
+		buf := append(inotifyEvent(1, "resolv.conf", 5), inotifyEvent(2, "resolv.conf", 1)...)
+
+		// when
+		names := readInotifyEvents(buf, directories)
+
+		// then
+		Expect(names).To(Equal([]string{
+			"/etc/resolv.conf",
+			"/run/systemd/resolve/resolv.conf",
+		}))
+	})
+
+	It("should ignore events of not watched directories and without names", func() {
+		// given
+		buf := append(inotifyEvent(3, "resolv.conf", 5), inotifyEvent(1, "", 0)...)
+		buf = append(buf, inotifyEvent(1, "hosts", 3)...)
+
+		// when
+		names := readInotifyEvents(buf, directories)
+
+		// then
+		Expect(names).To(Equal([]string{"/etc/hosts"}))
+	})
+
+	It("should stop at the truncated event", func() {
+		// given
+		buf := append(inotifyEvent(1, "hosts", 3), inotifyEvent(1, "resolv.conf", 5)...)
+
+		// when
+		names := readInotifyEvents(buf[:len(buf)-2], directories)
+
+		// then
+		Expect(names).To(Equal([]string{"/etc/hosts"}))
+	})
+})
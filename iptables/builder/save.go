@@ -0,0 +1,211 @@
+package builder
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/kumahq/kuma-net/internal/restore"
+)
+
+// savedChain is the chain declared in iptables-save output
+type savedChain struct {
+	table string
+	chain string
+}
+
+// savedRule is the rule appended to the chain in iptables-save output
+type savedRule struct {
+	table string
+	chain string
+	// counters are in format "[packets:bytes]", or are empty when
+	// iptables-save was run without -c flag
+	counters string
+	// specification contains matches and the target of the rule (as printed
+	// by iptables-save), and args are its arguments
+	specification string
+	args          []string
+}
+
+// purpose returns the purpose of the rule placed in its comment after
+// provided prefix (i.e. "kuma/")
+func (r savedRule) purpose(commentPrefix string) (string, bool) {
+	for i := 0; i < len(r.args)-1; i++ {
+		if r.args[i] == "--comment" && strings.HasPrefix(r.args[i+1], commentPrefix) {
+			return strings.TrimPrefix(r.args[i+1], commentPrefix), true
+		}
+	}
+
+	return "", false
+}
+
+// jumpTarget returns the target of the rule (the chain, when the rule is
+// jumping or going to the custom chain)
+func (r savedRule) jumpTarget() string {
+	for i := 0; i < len(r.args)-1; i++ {
+		switch r.args[i] {
+		case "-j", "--jump", "-g", "--goto":
+			return r.args[i+1]
+		}
+	}
+
+	return ""
+}
+
+// shortFlags maps long names of flags (used by generated rules in the verbose
+// mode) to the short ones printed by iptables-save
+var shortFlags = map[string]string{
+	"--protocol":      "-p",
+	"--source":        "-s",
+	"--destination":   "-d",
+	"--in-interface":  "-i",
+	"--out-interface": "-o",
+	"--match":         "-m",
+	"--jump":          "-j",
+	"--goto":          "-g",
+}
+
+// implicitMatches are matches of protocol options, which iptables-save
+// is adding to rules (i.e. -p tcp -m tcp --dport 22)
+var implicitMatches = map[string]struct{}{
+	"tcp":   {},
+	"udp":   {},
+	"sctp":  {},
+	"icmp":  {},
+	"icmp6": {},
+}
+
+func shortFlag(arg string) string {
+	if short, ok := shortFlags[arg]; ok {
+		return short
+	}
+
+	return arg
+}
+
+// key returns the normalized specification of the rule, so generated rules
+// can be compared with the ones printed by iptables-save, which is moving
+// addresses, interfaces and the protocol to the front, and adding implicit
+// protocol matches and masks of addresses
+func (r savedRule) key() string {
+	var header, rest []string
+
+	for i := 0; i < len(r.args); i++ {
+		arg := shortFlag(r.args[i])
+		negation := ""
+
+		if arg == "!" && i+1 < len(r.args) {
+			switch next := shortFlag(r.args[i+1]); next {
+			case "-s", "-d", "-i", "-o", "-p":
+				negation, arg = "! ", next
+				i++
+			}
+		}
+
+		switch arg {
+		case "-s", "-d", "-i", "-o", "-p":
+			if i+1 < len(r.args) {
+				value := r.args[i+1]
+				i++
+
+				if (arg == "-s" || arg == "-d") && !strings.Contains(value, "/") {
+					if strings.Contains(value, ":") {
+						value += "/128"
+					} else {
+						value += "/32"
+					}
+				}
+
+				header = append(header, negation+arg+" "+value)
+				continue
+			}
+		case "-m":
+			if i+1 < len(r.args) {
+				if _, ok := implicitMatches[r.args[i+1]]; ok {
+					i++
+					continue
+				}
+			}
+		}
+
+		rest = append(rest, arg)
+	}
+
+	sort.Strings(header)
+
+	return strings.Join(append(header, rest...), " ")
+}
+
+// parseSave returns chains and rules from provided iptables-save output (or
+// iptables-restore input, i.e. generated by BuildIPTables)
+func parseSave(save string) ([]savedChain, []savedRule, error) {
+	var chains []savedChain
+	var rules []savedRule
+	var tableName string
+
+	for i, line := range strings.Split(save, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "*"):
+			tableName = strings.TrimSpace(line[1:])
+			continue
+		case strings.HasPrefix(line, ":"):
+			if fields := strings.Fields(line[1:]); len(fields) > 0 {
+				chains = append(chains, savedChain{table: tableName, chain: fields[0]})
+			}
+
+			continue
+		}
+
+		var counters string
+		rule := line
+
+		if strings.HasPrefix(line, "[") {
+			counters, rule, _ = strings.Cut(line, " ")
+		}
+
+		args, err := restore.Tokenize(rule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+
+		switch {
+		case len(args) >= 2 && (args[0] == "-N" || args[0] == "--new-chain"):
+			chains = append(chains, savedChain{table: tableName, chain: args[1]})
+			continue
+		case len(args) < 2 || (args[0] != "-A" && args[0] != "--append"):
+			continue
+		}
+
+		_, specification, _ := strings.Cut(strings.TrimSpace(rule), " ")
+		_, specification, _ = strings.Cut(strings.TrimSpace(specification), " ")
+
+		rules = append(rules, savedRule{
+			table:         tableName,
+			chain:         args[1],
+			counters:      counters,
+			specification: strings.TrimSpace(specification),
+			args:          args[2:],
+		})
+	}
+
+	return chains, rules, nil
+}
+
+// runSaveCmd returns the output of iptables-save (or ip6tables-save)
+// run with provided arguments
+func runSaveCmd(ipv6 bool, args ...string) (string, error) {
+	cmdName := "iptables-save"
+	if ipv6 {
+		cmdName = "ip6tables-save"
+	}
+
+	output, err := exec.Command(cmdName, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("executing %s failed: %s", cmdName, err)
+	}
+
+	return string(output), nil
+}
@@ -0,0 +1,66 @@
+package builder
+
+import (
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// Status describes rules generated for the configuration, which are installed
+// in iptables (or ip6tables)
+type Status struct {
+	IPv6      bool
+	Installed bool
+	Chains    []InstalledChain
+	// Counters are empty unless comments are enabled
+	Counters []RuleCounter
+}
+
+// ParseStatus returns the status of rules generated for provided configuration
+// from provided output of iptables-save -c (or ip6tables-save -c)
+func ParseStatus(cfg config.Config, save string, ipv6 bool) (Status, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	chains, _, err := parseSave(save)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{IPv6: ipv6, Chains: parseInstalledChains(cfg, chains)}
+	status.Installed = len(status.Chains) > 0
+
+	if cfg.Comment.Enabled {
+		if status.Counters, err = ParseRuleCounters(cfg, save, ipv6); err != nil {
+			return Status{}, err
+		}
+	}
+
+	return status, nil
+}
+
+// GetStatus returns statuses of rules generated for provided configuration
+// in iptables (and ip6tables, when IPv6 is enabled)
+func GetStatus(cfg config.Config) ([]Status, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	families := []bool{false}
+	if cfg.IPv6 {
+		families = append(families, true)
+	}
+
+	var statuses []Status
+
+	for _, ipv6 := range families {
+		save, err := runSaveCmd(ipv6, "-c")
+		if err != nil {
+			return nil, err
+		}
+
+		status, err := ParseStatus(cfg, save, ipv6)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
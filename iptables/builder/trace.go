@@ -0,0 +1,113 @@
+package builder
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	. "github.com/kumahq/kuma-net/iptables/chain"
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+	"github.com/kumahq/kuma-net/iptables/trace"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// purposeTrace prefixes purposes of the traced rules in comments of the rules
+// logging packets (i.e. kuma/trace/outbound/redirect), so they can be told
+// apart from the traced ones
+const purposeTrace = "trace"
+
+// tracedDecisions contains targets of the rules which are preceded by
+// the logging rules in the tracing mode
+var tracedDecisions = map[string]struct{}{
+	trace.DecisionReturn:   {},
+	trace.DecisionRedirect: {},
+	trace.DecisionCT:       {},
+	trace.DecisionDrop:     {},
+	trace.DecisionReject:   {},
+}
+
+func traceJump(cfg config.Trace, decision string, purpose string, id string) *Parameter {
+	if cfg.Target == config.TraceTargetNflog {
+		return Jump(Nflog(
+			cfg.NflogGroup,
+			trace.FormatPrefix(cfg.Prefix, decision, purpose, NflogPrefixMaxLen),
+		))
+	}
+
+	// prefixes of the LOG target are too short to fit purposes of all rules,
+	// so the truncated prefix is followed by the identifier of the traced rule
+	// to tell them apart, and kernel log messages are placed right after it,
+	// so the space is separating them
+	suffix := "#" + id
+	prefix := trace.FormatPrefix(cfg.Prefix, decision, purpose, LogPrefixMaxLen-1-len(suffix))
+
+	return Jump(Log(prefix + suffix + " "))
+}
+
+// traceID returns the short identifier of the traced rule, derived from its
+// chain and parameters, so it's the same for every build of the rule (i.e.
+// when only the DNS servers are updated)
+func traceID(chainName string, parameters []*Parameter) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(chainName))
+
+	for _, parameter := range parameters {
+		if parameter != nil {
+			hash.Write([]byte(" " + parameter.Build(false)))
+		}
+	}
+
+	// up to 5 characters
+	return strconv.FormatUint(uint64(hash.Sum32()%60466176), 36)
+}
+
+// tracer returns the trace function which, for parameters of rules with
+// the terminal decision, returns parameters of the rate-limited rule logging
+// packets matched by the same matches (or nil for other rules)
+func tracer(cfg config.Config, chainName string) func(parameters []*Parameter) []*Parameter {
+	return func(parameters []*Parameter) []*Parameter {
+		var matches []*Parameter
+		var decision string
+		var purpose string
+
+		for _, parameter := range parameters {
+			if parameter == nil {
+				continue
+			}
+
+			if target, ok := parameter.JumpTarget(); ok {
+				decision = target
+				continue
+			}
+
+			if text, ok := parameter.Comment(); ok {
+				purpose = strings.TrimPrefix(text, cfg.Comment.Prefix+"/")
+				continue
+			}
+
+			matches = append(matches, parameter)
+		}
+
+		if _, ok := tracedDecisions[decision]; !ok {
+			return nil
+		}
+
+		return append(matches,
+			Match(Limit(Rate(cfg.Trace.Limit), Burst(cfg.Trace.LimitBurst))),
+			comment(cfg.Comment, purposeTrace+"/"+purpose),
+			traceJump(cfg.Trace, decision, purpose, traceID(chainName, parameters)),
+		)
+	}
+}
+
+// traceChains precedes rules of provided chains with terminal decisions
+// by rules logging matching packets, when the tracing mode is enabled
+func traceChains(cfg config.Config, chains ...*Chain) {
+	if !cfg.Trace.Enabled {
+		return
+	}
+
+	for _, chain := range chains {
+		chain.Trace(tracer(cfg, chain.Name()))
+	}
+}
@@ -0,0 +1,184 @@
+package builder_test
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/iptables/builder"
+	. "github.com/kumahq/kuma-net/test/framework/gomega_matchers"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("trace", func() {
+	redirect := config.Redirect{
+		Inbound: config.TrafficFlow{
+			Enabled:      true,
+			ExcludePorts: []uint16{22},
+		},
+		Outbound: config.TrafficFlow{
+			Enabled:      true,
+			ExcludeCIDRs: []string{"10.0.0.0/8"},
+		},
+	}
+
+	DescribeTable("should log packets before terminal decisions",
+		func(trace config.Trace, goldenFile string) {
+			// given
+			cfg := config.Config{
+				Redirect:           redirect,
+				DropInvalidPackets: true,
+				Trace:              trace,
+				// purposes of the traced rules are read from comments
+				Comment:       config.Comment{Enabled: true},
+				RuntimeStdout: io.Discard,
+			}
+
+			// when
+			got, err := builder.BuildIPTables(cfg, nil, false)
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got).To(MatchGoldenEqual("testdata", goldenFile))
+		},
+		Entry("with LOG target",
+			config.Trace{Enabled: true},
+			"trace_log.golden.txt",
+		),
+		Entry("with NFLOG target",
+			config.Trace{
+				Enabled:    true,
+				Target:     config.TraceTargetNflog,
+				Prefix:     "mesh",
+				NflogGroup: 5,
+				Limit:      "1/second",
+				LimitBurst: 3,
+			},
+			"trace_nflog.golden.txt",
+		),
+	)
+
+	DescribeTable("should generate unique prefixes of logged messages in every table",
+		func(prefix string, ipv6 bool) {
+			// given
+			cfg := config.Config{
+				Redirect: config.Redirect{
+					Inbound: config.TrafficFlow{
+						Enabled:      true,
+						ExcludePorts: []uint16{22, 80, 443},
+					},
+					Outbound: config.TrafficFlow{
+						Enabled:      true,
+						ExcludePorts: []uint16{8080, 8443},
+						ExcludeCIDRs: []string{"10.0.0.0/8", "192.168.0.0/16"},
+					},
+					DNS: config.DNS{
+						Enabled:    true,
+						CaptureAll: true,
+					},
+				},
+				DropInvalidPackets: true,
+				Trace:              config.Trace{Enabled: true, Prefix: prefix},
+				RuntimeStdout:      io.Discard,
+			}
+			logPrefix := regexp.MustCompile(`--log-prefix "([^"]*)"`)
+
+			// when
+			got, err := builder.BuildIPTables(cfg, []string{"1.1.1.1", "8.8.8.8"}, ipv6)
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+
+			seen := map[string]map[string]string{}
+			table := ""
+			for _, line := range strings.Split(got, "\n") {
+				if strings.HasPrefix(line, "* ") {
+					table = strings.TrimPrefix(line, "* ")
+					seen[table] = map[string]string{}
+					continue
+				}
+
+				match := logPrefix.FindStringSubmatch(line)
+				if match == nil {
+					continue
+				}
+
+				Expect(len(match[1])).To(BeNumerically("<=", 29))
+				Expect(seen[table]).ToNot(HaveKey(match[1]), "duplicated in %s: %s", table, line)
+				seen[table][match[1]] = line
+			}
+			Expect(seen["nat"]).ToNot(BeEmpty())
+		},
+		Entry("default prefix", "kuma", false),
+		Entry("default prefix (IPv6)", "kuma", true),
+		Entry("the longest prefix", strings.Repeat("k", 16), false),
+	)
+
+	It("should generate the same prefixes when updating DNS servers", func() {
+		// given
+		cfg := config.Config{
+			Redirect: config.Redirect{
+				Inbound:  config.TrafficFlow{Enabled: true},
+				Outbound: config.TrafficFlow{Enabled: true},
+				DNS:      config.DNS{Enabled: true},
+			},
+			Trace:         config.Trace{Enabled: true},
+			RuntimeStdout: io.Discard,
+		}
+		dnsServers := []string{"1.1.1.1"}
+		logPrefix := regexp.MustCompile(`--log-prefix "[^"]*"`)
+
+		// when
+		rules, err := builder.BuildIPTables(cfg, dnsServers, false)
+		update := builder.BuildDnsServersUpdate(cfg, dnsServers, false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		prefixes := logPrefix.FindAllString(update, -1)
+		Expect(prefixes).ToNot(BeEmpty())
+		for _, prefix := range prefixes {
+			Expect(rules).To(ContainSubstring(prefix))
+		}
+	})
+
+	It("should not log packets unless enabled", func() {
+		// when
+		got, err := builder.BuildIPTables(config.Config{
+			Redirect:      redirect,
+			RuntimeStdout: io.Discard,
+		}, nil, false)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).ToNot(ContainSubstring("-j LOG"))
+		Expect(got).ToNot(ContainSubstring("-j NFLOG"))
+	})
+
+	DescribeTable("should fail for invalid configuration",
+		func(trace config.Trace, want string) {
+			// when
+			_, err := builder.BuildIPTables(config.Config{
+				Redirect: redirect,
+				Trace:    trace,
+			}, nil, false)
+
+			// then
+			Expect(err).To(MatchError(ContainSubstring(want)))
+		},
+		Entry("unsupported target",
+			config.Trace{Enabled: true, Target: "ULOG"},
+			"unsupported trace target",
+		),
+		Entry("too long prefix",
+			config.Trace{Enabled: true, Prefix: strings.Repeat("k", 17)},
+			"is longer than 16 characters",
+		),
+		Entry("prefix with colon",
+			config.Trace{Enabled: true, Prefix: "kuma:mesh"},
+			"cannot contain colons",
+		),
+	)
+})
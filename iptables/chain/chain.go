@@ -28,6 +28,82 @@ func (b *Chain) AppendIf(predicate func() bool, parameters ...*Parameter) *Chain
 	return b
 }
 
+// Insert will insert the rule into the chain as the given rule number
+// (starting from 1, 0 means the head of the chain)
+func (b *Chain) Insert(rulenum uint, parameters ...*Parameter) *Chain {
+	b.commands = append(b.commands, commands.Insert(b.name, rulenum, parameters))
+
+	return b
+}
+
+func (b *Chain) InsertIf(predicate func() bool, rulenum uint, parameters ...*Parameter) *Chain {
+	if predicate() {
+		return b.Insert(rulenum, parameters...)
+	}
+
+	return b
+}
+
+// Delete will remove the rule matching provided parameters from the chain
+func (b *Chain) Delete(parameters ...*Parameter) *Chain {
+	b.commands = append(b.commands, commands.Delete(b.name, parameters))
+
+	return b
+}
+
+// DeleteRulenum will remove the rule with the given number (starting from 1)
+// from the chain
+func (b *Chain) DeleteRulenum(rulenum uint) *Chain {
+	b.commands = append(b.commands, commands.DeleteRulenum(b.name, rulenum))
+
+	return b
+}
+
+// Flush will remove all the rules from the chain before applying rules
+// appended after it (i.e. when the chain already exists and is being updated)
+func (b *Chain) Flush() *Chain {
+	b.commands = append(b.commands, commands.Flush(b.name))
+
+	return b
+}
+
+// DeleteChain will delete the chain, which has to be empty (flushed)
+// and cannot be referenced by any rule
+func (b *Chain) DeleteChain() *Chain {
+	b.commands = append(b.commands, commands.DeleteChain(b.name))
+
+	return b
+}
+
+// Policy will set the policy of the built-in chain to the given target
+// (ACCEPT or DROP)
+func (b *Chain) Policy(target string) *Chain {
+	b.commands = append(b.commands, commands.Policy(b.name, target))
+
+	return b
+}
+
+// Trace will place before every appended rule the rule with parameters
+// returned by the trace function for parameters of the appended rule (i.e.
+// the rule logging packets, which are matched by the appended one), unless
+// the function returns nil. It should be called after all the rules
+// are appended
+func (b *Chain) Trace(trace func(parameters []*Parameter) []*Parameter) *Chain {
+	var cmds []*commands.Command
+
+	for _, cmd := range b.commands {
+		if traced := cmd.Traced(trace); traced != nil {
+			cmds = append(cmds, traced)
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	b.commands = cmds
+
+	return b
+}
+
 func (b *Chain) Build(verbose bool) []string {
 	var cmds []string
 
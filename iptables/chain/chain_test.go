@@ -0,0 +1,92 @@
+package chain_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/chain"
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+)
+
+var _ = Describe("Chain", func() {
+	DescribeTable("Build",
+		func(chain *Chain, verbose bool, want []string) {
+			// when
+			got := chain.Build(verbose)
+
+			// then
+			Expect(got).To(Equal(want))
+		},
+		Entry("append",
+			NewChain("OUTPUT").Append(Protocol(Tcp()), Jump(Return())), false,
+			[]string{"-A OUTPUT -p tcp -j RETURN"},
+		),
+		Entry("append - verbose",
+			NewChain("OUTPUT").Append(Protocol(Tcp()), Jump(Return())), true,
+			[]string{"--append OUTPUT --protocol tcp --jump RETURN"},
+		),
+		Entry("insert at the head of the chain",
+			NewChain("OUTPUT").Insert(0, Jump(Return())), false,
+			[]string{"-I OUTPUT -j RETURN"},
+		),
+		Entry("insert as the rule number",
+			NewChain("OUTPUT").Insert(3, Jump(Return())), false,
+			[]string{"-I OUTPUT 3 -j RETURN"},
+		),
+		Entry("insert as the rule number - verbose",
+			NewChain("OUTPUT").Insert(3, Jump(Return())), true,
+			[]string{"--insert OUTPUT 3 --jump RETURN"},
+		),
+		Entry("insert if predicate is false",
+			NewChain("OUTPUT").InsertIf(func() bool { return false }, 1, Jump(Return())), false,
+			nil,
+		),
+		Entry("delete",
+			NewChain("OUTPUT").Delete(Protocol(Tcp()), Jump(Return())), false,
+			[]string{"-D OUTPUT -p tcp -j RETURN"},
+		),
+		Entry("delete - verbose",
+			NewChain("OUTPUT").Delete(Protocol(Tcp()), Jump(Return())), true,
+			[]string{"--delete OUTPUT --protocol tcp --jump RETURN"},
+		),
+		Entry("delete rule number",
+			NewChain("OUTPUT").DeleteRulenum(2), false,
+			[]string{"-D OUTPUT 2"},
+		),
+		Entry("flush and delete chain",
+			NewChain("MESH_OUTBOUND").Flush().DeleteChain(), false,
+			[]string{"-F MESH_OUTBOUND", "-X MESH_OUTBOUND"},
+		),
+		Entry("flush and delete chain - verbose",
+			NewChain("MESH_OUTBOUND").Flush().DeleteChain(), true,
+			[]string{"--flush MESH_OUTBOUND", "--delete-chain MESH_OUTBOUND"},
+		),
+		Entry("policy",
+			NewChain("OUTPUT").Policy("DROP"), false,
+			[]string{"-P OUTPUT DROP"},
+		),
+		Entry("policy - verbose",
+			NewChain("OUTPUT").Policy("DROP"), true,
+			[]string{"--policy OUTPUT DROP"},
+		),
+		Entry("trace appended rules",
+			NewChain("OUTPUT").
+				Flush().
+				Append(Protocol(Tcp()), Jump(Return())).
+				Append(Jump(ToUserDefinedChain("MESH_OUTBOUND"))).
+				Trace(func(parameters []*Parameter) []*Parameter {
+					if target, _ := parameters[len(parameters)-1].JumpTarget(); target != "RETURN" {
+						return nil
+					}
+
+					return append(parameters[:len(parameters)-1:len(parameters)-1], Jump(Log("trace ")))
+				}), false,
+			[]string{
+				"-F OUTPUT",
+				"-A OUTPUT -p tcp -j LOG --log-prefix \"trace \"",
+				"-A OUTPUT -p tcp -j RETURN",
+				"-A OUTPUT -j MESH_OUTBOUND",
+			},
+		),
+	)
+})
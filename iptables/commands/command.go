@@ -1,15 +1,22 @@
 package commands
 
 import (
+	"strconv"
 	"strings"
 
+	. "github.com/kumahq/kuma-net/iptables/consts"
 	"github.com/kumahq/kuma-net/iptables/parameters"
 )
 
 type Command struct {
-	long       string
-	short      string
-	chainName  string
+	// flag is the key of the command in consts.Flags (i.e. "append")
+	flag      string
+	long      string
+	short     string
+	chainName string
+	// arguments are placed between the chain name and parameters
+	// (i.e. the rule number of the insert command, or the policy target)
+	arguments  []string
 	parameters []*parameters.Parameter
 }
 
@@ -26,6 +33,8 @@ func (c *Command) Build(verbose bool) string {
 		cmd = append(cmd, c.chainName)
 	}
 
+	cmd = append(cmd, c.arguments...)
+
 	for _, parameter := range c.parameters {
 		if parameter != nil {
 			cmd = append(cmd, parameter.Build(verbose))
@@ -35,11 +44,77 @@ func (c *Command) Build(verbose bool) string {
 	return strings.Join(cmd, " ")
 }
 
-func Append(chainName string, parameters []*parameters.Parameter) *Command {
+func newCommand(flag string, chainName string, arguments []string, parameters []*parameters.Parameter) *Command {
 	return &Command{
-		long:       "--append",
-		short:      "-A",
+		flag:       flag,
+		long:       Flags[flag][Long],
+		short:      Flags[flag][Short],
 		chainName:  chainName,
+		arguments:  arguments,
 		parameters: parameters,
 	}
 }
+
+// Traced returns the command appending the rule with parameters returned
+// by the trace function for parameters of the command (i.e. the rule logging
+// packets matched by the rule), or nil when the command is not appending
+// a rule or the trace function returns nil
+func (c *Command) Traced(trace func([]*parameters.Parameter) []*parameters.Parameter) *Command {
+	if c.flag != "append" {
+		return nil
+	}
+
+	traced := trace(c.parameters)
+	if traced == nil {
+		return nil
+	}
+
+	return Append(c.chainName, traced)
+}
+
+// Append one or more rules to the end of the selected chain
+func Append(chainName string, parameters []*parameters.Parameter) *Command {
+	return newCommand("append", chainName, nil, parameters)
+}
+
+// Insert one or more rules in the selected chain as the given rule number
+// (starting from 1). If the rule number is 0, the rules are inserted
+// at the head of the chain (the same as with the rule number 1, which is
+// the default one)
+func Insert(chainName string, rulenum uint, parameters []*parameters.Parameter) *Command {
+	var arguments []string
+	if rulenum > 0 {
+		arguments = []string{strconv.Itoa(int(rulenum))}
+	}
+
+	return newCommand("insert", chainName, arguments, parameters)
+}
+
+// Delete the rule matching provided parameters from the selected chain
+func Delete(chainName string, parameters []*parameters.Parameter) *Command {
+	return newCommand("delete", chainName, nil, parameters)
+}
+
+// DeleteRulenum deletes the rule with given number (starting from 1) from
+// the selected chain
+func DeleteRulenum(chainName string, rulenum uint) *Command {
+	return newCommand("delete", chainName, []string{strconv.Itoa(int(rulenum))}, nil)
+}
+
+// Flush the selected chain (all the chains in the table if none is given)
+func Flush(chainName string) *Command {
+	return newCommand("flush", chainName, nil, nil)
+}
+
+// DeleteChain deletes the optional user-defined chain specified (all
+// the non-builtin chains in the table if none is given). The chain must
+// be empty and there must be no references to it
+func DeleteChain(chainName string) *Command {
+	return newCommand("delete-chain", chainName, nil, nil)
+}
+
+// Policy sets the policy for the built-in chain to the given target
+// (ACCEPT or DROP)
+func Policy(chainName string, target string) *Command {
+	return newCommand("policy", chainName, []string{target}, nil)
+}
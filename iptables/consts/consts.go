@@ -27,6 +27,26 @@ var Flags = map[string]map[bool]string{
 		Long:  "--new-chain",
 		Short: "-N",
 	},
+	"insert": {
+		Long:  "--insert",
+		Short: "-I",
+	},
+	"delete": {
+		Long:  "--delete",
+		Short: "-D",
+	},
+	"flush": {
+		Long:  "--flush",
+		Short: "-F",
+	},
+	"delete-chain": {
+		Long:  "--delete-chain",
+		Short: "-X",
+	},
+	"policy": {
+		Long:  "--policy",
+		Short: "-P",
+	},
 
 	// parameters
 	"jump": {
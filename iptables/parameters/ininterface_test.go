@@ -0,0 +1,54 @@
+package parameters_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+)
+
+var _ = Describe("InInterfaceParameter", func() {
+	DescribeTable("InInterface",
+		func(name string, verbose bool, want string) {
+			// when
+			got := InInterface(name).Build(verbose)
+
+			// then
+			Expect(got).To(Equal(want))
+		},
+		Entry("eth0",
+			"eth0", false,
+			"-i eth0",
+		),
+		Entry("eth0 - verbose",
+			"eth0", true,
+			"--in-interface eth0",
+		),
+		Entry("wildcard",
+			"wg+", false,
+			"-i wg+",
+		),
+		Entry("wildcard - verbose",
+			"wg+", true,
+			"--in-interface wg+",
+		),
+	)
+
+	DescribeTable("NotInInterface",
+		func(name string, verbose bool, want string) {
+			// when
+			got := NotInInterface(name).Build(verbose)
+
+			// then
+			Expect(got).To(Equal(want))
+		},
+		Entry("eth0",
+			"eth0", false,
+			"! -i eth0",
+		),
+		Entry("eth0 - verbose",
+			"eth0", true,
+			"! --in-interface eth0",
+		),
+	)
+})
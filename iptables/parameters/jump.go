@@ -44,3 +44,44 @@ func Return() *JumpParameter {
 func Drop() *JumpParameter {
 	return &JumpParameter{parameters: []string{"DROP"}}
 }
+
+// RejectType is the type of the ICMP error (or TCP RST) packet sent back
+// by the REJECT target
+type RejectType string
+
+const (
+	RejectWithIcmpPortUnreachable  RejectType = "icmp-port-unreachable"
+	RejectWithIcmp6PortUnreachable RejectType = "icmp6-port-unreachable"
+	RejectWithIcmpAdminProhibited  RejectType = "icmp-admin-prohibited"
+	RejectWithIcmp6AdmProhibited   RejectType = "icmp6-adm-prohibited"
+	// RejectWithTcpReset can be used only with rules matching tcp protocol
+	RejectWithTcpReset RejectType = "tcp-reset"
+)
+
+// Reject sends back an error packet in response to the matched packet
+// (icmp-port-unreachable, or icmp6-port-unreachable for IPv6 by default),
+// and drops the packet
+func Reject() *JumpParameter {
+	return &JumpParameter{parameters: []string{"REJECT"}}
+}
+
+// RejectWith is Reject with the explicitly provided type of the error packet
+func RejectWith(rejectType RejectType) *JumpParameter {
+	return &JumpParameter{parameters: []string{
+		"REJECT",
+		"--reject-with",
+		string(rejectType),
+	}}
+}
+
+// JumpTarget returns the target of the jump parameter (i.e. RETURN for
+// Jump(Return()))
+func (p *Parameter) JumpTarget() (string, bool) {
+	for _, parameter := range p.parameters {
+		if jump, ok := parameter.(*JumpParameter); ok && len(jump.parameters) > 0 {
+			return jump.parameters[0], true
+		}
+	}
+
+	return "", false
+}
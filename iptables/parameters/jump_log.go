@@ -0,0 +1,36 @@
+package parameters
+
+import (
+	"strconv"
+)
+
+const (
+	// LogPrefixMaxLen is the maximal length of the LOG target prefix
+	LogPrefixMaxLen = 29
+	// NflogPrefixMaxLen is the maximal length of the NFLOG target prefix
+	// (without the terminating null byte)
+	NflogPrefixMaxLen = 63
+)
+
+// Log turns on kernel logging of matching packets, with provided prefix
+// (up to LogPrefixMaxLen characters) placed before the log message
+func Log(prefix string) *JumpParameter {
+	return &JumpParameter{parameters: []string{
+		"LOG",
+		"--log-prefix",
+		quoteArgument(prefix),
+	}}
+}
+
+// Nflog logs matching packets by passing them to the nfnetlink_log backend,
+// from which userspace processes subscribed to provided group can read them.
+// Provided prefix can be up to NflogPrefixMaxLen characters long
+func Nflog(group uint16, prefix string) *JumpParameter {
+	return &JumpParameter{parameters: []string{
+		"NFLOG",
+		"--nflog-group",
+		strconv.Itoa(int(group)),
+		"--nflog-prefix",
+		quoteArgument(prefix),
+	}}
+}
@@ -0,0 +1,63 @@
+package parameters_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+)
+
+var _ = Describe("JumpParameter", func() {
+	DescribeTable("Jump",
+		func(parameter *JumpParameter, verbose bool, want string) {
+			// when
+			got := Jump(parameter).Build(verbose)
+
+			// then
+			Expect(got).To(Equal(want))
+		},
+		Entry("drop",
+			Drop(), false,
+			"-j DROP",
+		),
+		Entry("reject",
+			Reject(), false,
+			"-j REJECT",
+		),
+		Entry("reject - verbose",
+			Reject(), true,
+			"--jump REJECT",
+		),
+		Entry("reject with tcp-reset",
+			RejectWith(RejectWithTcpReset), false,
+			"-j REJECT --reject-with tcp-reset",
+		),
+		Entry("reject with icmp6-adm-prohibited - verbose",
+			RejectWith(RejectWithIcmp6AdmProhibited), true,
+			"--jump REJECT --reject-with icmp6-adm-prohibited",
+		),
+		Entry("log",
+			Log("kuma:RETURN:outbound "), false,
+			`-j LOG --log-prefix "kuma:RETURN:outbound "`,
+		),
+		Entry("nflog",
+			Nflog(5, "kuma:REDIRECT:outbound/redirect-port"), true,
+			"--jump NFLOG --nflog-group 5 --nflog-prefix kuma:REDIRECT:outbound/redirect-port",
+		),
+	)
+
+	DescribeTable("JumpTarget",
+		func(parameter *Parameter, want string, wantOk bool) {
+			// when
+			got, ok := parameter.JumpTarget()
+
+			// then
+			Expect(got).To(Equal(want))
+			Expect(ok).To(Equal(wantOk))
+		},
+		Entry("return", Jump(Return()), "RETURN", true),
+		Entry("redirect", Jump(ToPort(15001)), "REDIRECT", true),
+		Entry("user defined chain", Jump(ToUserDefinedChain("MESH_OUTBOUND")), "MESH_OUTBOUND", true),
+		Entry("not a jump", Match(Comment("kuma/outbound")), "", false),
+	)
+})
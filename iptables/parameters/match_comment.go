@@ -0,0 +1,52 @@
+package parameters
+
+// Comment
+//       Allows you to add comments (up to 256 characters) to any rule.
+//
+//       --comment comment
+//
+// ref. iptables-extensions(8) > comment
+
+import (
+	"fmt"
+)
+
+type CommentParameter struct {
+	comment string
+}
+
+func (p *CommentParameter) Build(bool) string {
+	return fmt.Sprintf("--comment %s", quoteArgument(p.comment))
+}
+
+// Negate is a no-op, as comments cannot be negated
+func (p *CommentParameter) Negate() ParameterBuilder {
+	return p
+}
+
+// Comment allows to add comment to any rule, which is visible i.e. in the output
+// of iptables-save, so the rule can be identified
+func Comment(comment string) *MatchParameter {
+	return &MatchParameter{
+		name:       "comment",
+		parameters: []ParameterBuilder{&CommentParameter{comment: comment}},
+	}
+}
+
+// Comment returns the comment of the comment match (i.e. Match(Comment(...)))
+func (p *Parameter) Comment() (string, bool) {
+	for _, parameter := range p.parameters {
+		match, ok := parameter.(*MatchParameter)
+		if !ok || match.name != "comment" {
+			continue
+		}
+
+		for _, matchParameter := range match.parameters {
+			if comment, ok := matchParameter.(*CommentParameter); ok {
+				return comment.comment, true
+			}
+		}
+	}
+
+	return "", false
+}
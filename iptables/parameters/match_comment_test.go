@@ -0,0 +1,53 @@
+package parameters_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+)
+
+var _ = Describe("CommentParameter", func() {
+	DescribeTable("Comment",
+		func(comment string, verbose bool, want string) {
+			// when
+			got := Match(Comment(comment)).Build(verbose)
+
+			// then
+			Expect(got).To(Equal(want))
+		},
+		Entry("simple comment",
+			"kuma/outbound/redirect", false,
+			"-m comment --comment kuma/outbound/redirect",
+		),
+		Entry("simple comment - verbose",
+			"kuma/outbound/redirect", true,
+			"--match comment --comment kuma/outbound/redirect",
+		),
+		Entry("comment with whitespaces",
+			"kuma mesh/outbound/redirect", false,
+			`-m comment --comment "kuma mesh/outbound/redirect"`,
+		),
+		Entry("comment with quotes and backslashes",
+			`kuma "mesh"\outbound`, false,
+			`-m comment --comment "kuma \"mesh\"\\outbound"`,
+		),
+		Entry("empty comment",
+			"", false,
+			`-m comment --comment ""`,
+		),
+	)
+	DescribeTable("Parameter.Comment",
+		func(parameter *Parameter, want string, wantOk bool) {
+			// when
+			got, ok := parameter.Comment()
+
+			// then
+			Expect(got).To(Equal(want))
+			Expect(ok).To(Equal(wantOk))
+		},
+		Entry("comment", Match(Comment("kuma/outbound")), "kuma/outbound", true),
+		Entry("not a comment", Match(Owner(Uid("5678"))), "", false),
+		Entry("jump", Jump(Return()), "", false),
+	)
+})
@@ -0,0 +1,65 @@
+package parameters
+
+// Limit
+//       This module matches at a limited rate using a token bucket filter.
+//       A rule using this extension will match until this limit is reached.
+//
+//       --limit rate[/second|/minute|/hour|/day]
+//              Maximum average matching rate: specified as a number, with
+//              an optional `/second', `/minute', `/hour', or `/day' suffix;
+//              the default is 3/hour.
+//
+//       --limit-burst number
+//              Maximum initial number of packets to match: this number gets
+//              recharged by one every time the limit specified above is not
+//              reached, up to this number; the default is 5.
+//
+// ref. iptables-extensions(8) > limit
+
+import (
+	"fmt"
+)
+
+type LimitParameter struct {
+	flag  string
+	value string
+}
+
+func (p *LimitParameter) Build(bool) string {
+	return fmt.Sprintf("%s %s", p.flag, p.value)
+}
+
+// Negate is a no-op, as the rate is a limit of matching packets itself
+func (p *LimitParameter) Negate() ParameterBuilder {
+	return p
+}
+
+// Rate is the maximum average matching rate (i.e. "10/second")
+func Rate(rate string) *LimitParameter {
+	return &LimitParameter{
+		flag:  "--limit",
+		value: rate,
+	}
+}
+
+// Burst is the maximum initial number of packets to match
+func Burst(burst uint) *LimitParameter {
+	return &LimitParameter{
+		flag:  "--limit-burst",
+		value: fmt.Sprint(burst),
+	}
+}
+
+// Limit matches at a limited rate using a token bucket filter
+func Limit(limitParameters ...*LimitParameter) *MatchParameter {
+	var parameters []ParameterBuilder
+
+	for _, parameter := range limitParameters {
+		parameters = append(parameters, parameter)
+	}
+
+	return &MatchParameter{
+		name:       "limit",
+		parameters: parameters,
+	}
+}
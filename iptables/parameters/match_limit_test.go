@@ -0,0 +1,32 @@
+package parameters_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+)
+
+var _ = Describe("LimitParameter", func() {
+	DescribeTable("Limit",
+		func(parameters []*LimitParameter, verbose bool, want string) {
+			// when
+			got := Match(Limit(parameters...)).Build(verbose)
+
+			// then
+			Expect(got).To(Equal(want))
+		},
+		Entry("rate",
+			[]*LimitParameter{Rate("10/second")}, false,
+			"-m limit --limit 10/second",
+		),
+		Entry("rate and burst",
+			[]*LimitParameter{Rate("10/second"), Burst(20)}, false,
+			"-m limit --limit 10/second --limit-burst 20",
+		),
+		Entry("rate and burst - verbose",
+			[]*LimitParameter{Rate("3/hour"), Burst(5)}, true,
+			"--match limit --limit 3/hour --limit-burst 5",
+		),
+	)
+})
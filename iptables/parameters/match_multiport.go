@@ -0,0 +1,138 @@
+package parameters
+
+// Multiport
+//       This module matches a set of source or destination ports. Up to 15 ports
+//       can be specified. A port range (port:port) counts as two ports. It can only
+//       be used in conjunction with one of the following protocols: tcp, udp,
+//       udplite, dccp and sctp.
+//
+//       [!] --source-ports,--sports port[,port|,port:port]...
+//              Match if the source port is one of the given ports.
+//
+//       [!] --destination-ports,--dports port[,port|,port:port]...
+//              Match if the destination port is one of the given ports.
+//
+// ref. iptables-extensions(8) > multiport
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultiportMaxPorts is the maximal amount of ports in a single multiport match
+// (ranges are counted as two ports)
+const MultiportMaxPorts = 15
+
+type MultiportParameter struct {
+	long     string
+	short    string
+	values   []string
+	negative bool
+}
+
+func (p *MultiportParameter) Negate() ParameterBuilder {
+	p.negative = !p.negative
+
+	return p
+}
+
+func (p *MultiportParameter) Build(verbose bool) string {
+	flag := p.short
+	if verbose {
+		flag = p.long
+	}
+
+	value := strings.Join(p.values, ",")
+
+	if p.negative {
+		return fmt.Sprintf("! %s %s", flag, value)
+	}
+
+	return fmt.Sprintf("%s %s", flag, value)
+}
+
+// MultiportRange returns the port (when from and to are equal) or the port
+// range in the format accepted by the multiport match
+func MultiportRange(from uint16, to uint16) string {
+	if from == to {
+		return fmt.Sprintf("%d", from)
+	}
+
+	return fmt.Sprintf("%d:%d", from, to)
+}
+
+func multiportWeight(port string) int {
+	if strings.Contains(port, ":") {
+		return 2
+	}
+
+	return 1
+}
+
+// SplitMultiportPorts groups provided ports (or port ranges) so every group
+// can be used in a single multiport match
+func SplitMultiportPorts(ports []string) [][]string {
+	var groups [][]string
+	var group []string
+	weight := 0
+
+	for _, port := range ports {
+		if weight+multiportWeight(port) > MultiportMaxPorts {
+			groups = append(groups, group)
+			group, weight = nil, 0
+		}
+
+		group = append(group, port)
+		weight += multiportWeight(port)
+	}
+
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+func destinationPorts(ports []string, negative bool) *MultiportParameter {
+	return &MultiportParameter{
+		long:     "--destination-ports",
+		short:    "--dports",
+		values:   ports,
+		negative: negative,
+	}
+}
+
+// DestinationPorts matches if the destination port is one of the given ports
+// or port ranges (see MultiportRange)
+func DestinationPorts(ports ...string) *MultiportParameter {
+	return destinationPorts(ports, false)
+}
+
+func NotDestinationPorts(ports ...string) *MultiportParameter {
+	return destinationPorts(ports, true)
+}
+
+// SourcePorts matches if the source port is one of the given ports or port
+// ranges (see MultiportRange)
+func SourcePorts(ports ...string) *MultiportParameter {
+	return &MultiportParameter{
+		long:   "--source-ports",
+		short:  "--sports",
+		values: ports,
+	}
+}
+
+// Multiport matches a set of source or destination ports. It can only be used
+// in conjunction with tcp or udp protocols
+func Multiport(multiportParameters ...*MultiportParameter) *MatchParameter {
+	var parameters []ParameterBuilder
+
+	for _, parameter := range multiportParameters {
+		parameters = append(parameters, parameter)
+	}
+
+	return &MatchParameter{
+		name:       "multiport",
+		parameters: parameters,
+	}
+}
@@ -0,0 +1,134 @@
+package parameters_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+)
+
+var _ = Describe("MultiportParameter", func() {
+	Describe("MultiportRange", func() {
+		DescribeTable("should build port or port range",
+			func(from, to int, want string) {
+				// when
+				got := MultiportRange(uint16(from), uint16(to))
+
+				// then
+				Expect(got).To(Equal(want))
+			},
+			Entry("single port", 8080, 8080, "8080"),
+			Entry("port range", 30000, 32767, "30000:32767"),
+		)
+	})
+
+	Describe("DestinationPorts", func() {
+		DescribeTable("should build valid destination ports parameter",
+			func(ports []string, verbose bool, want string) {
+				// when
+				got := DestinationPorts(ports...).Build(verbose)
+
+				// then
+				Expect(got).To(Equal(want))
+			},
+			Entry("single port",
+				[]string{"22"}, false,
+				"--dports 22",
+			),
+			Entry("single port - verbose",
+				[]string{"22"}, true,
+				"--destination-ports 22",
+			),
+			Entry("ports and ranges",
+				[]string{"22", "8080:8090", "30000:32767"}, false,
+				"--dports 22,8080:8090,30000:32767",
+			),
+			Entry("ports and ranges - verbose",
+				[]string{"22", "8080:8090", "30000:32767"}, true,
+				"--destination-ports 22,8080:8090,30000:32767",
+			),
+		)
+
+		DescribeTable("should build valid negated destination ports parameter",
+			func(ports []string, verbose bool, want string) {
+				// when
+				got := NotDestinationPorts(ports...).Build(verbose)
+
+				// then
+				Expect(got).To(Equal(want))
+			},
+			Entry("ports and ranges",
+				[]string{"22", "30000:32767"}, false,
+				"! --dports 22,30000:32767",
+			),
+			Entry("ports and ranges - verbose",
+				[]string{"22", "30000:32767"}, true,
+				"! --destination-ports 22,30000:32767",
+			),
+		)
+	})
+
+	Describe("SourcePorts", func() {
+		DescribeTable("should build valid source ports parameter",
+			func(ports []string, verbose bool, want string) {
+				// when
+				got := SourcePorts(ports...).Build(verbose)
+
+				// then
+				Expect(got).To(Equal(want))
+			},
+			Entry("ports and ranges",
+				[]string{"53", "1024:65535"}, false,
+				"--sports 53,1024:65535",
+			),
+			Entry("ports and ranges - verbose",
+				[]string{"53", "1024:65535"}, true,
+				"--source-ports 53,1024:65535",
+			),
+		)
+	})
+
+	Describe("SplitMultiportPorts", func() {
+		It("should not split ports which fit in a single match", func() {
+			// given
+			ports := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15"}
+
+			// when
+			got := SplitMultiportPorts(ports)
+
+			// then
+			Expect(got).To(Equal([][]string{ports}))
+		})
+
+		It("should count ranges as two ports", func() {
+			// given
+			ports := []string{
+				"1:2", "3:4", "5:6", "7:8", "9:10", "11:12", "13:14",
+				"15", "16:17", "18",
+			}
+
+			// when
+			got := SplitMultiportPorts(ports)
+
+			// then
+			Expect(got).To(Equal([][]string{
+				{"1:2", "3:4", "5:6", "7:8", "9:10", "11:12", "13:14", "15"},
+				{"16:17", "18"},
+			}))
+		})
+
+		It("should return no groups for no ports", func() {
+			Expect(SplitMultiportPorts(nil)).To(BeEmpty())
+		})
+	})
+
+	Describe("Multiport", func() {
+		It("should build valid multiport match", func() {
+			// when
+			got := Match(Multiport(DestinationPorts("80", "30000:32767"))).Build(false)
+
+			// then
+			Expect(got).To(Equal("-m multiport --dports 80,30000:32767"))
+		})
+	})
+})
@@ -0,0 +1,92 @@
+package parameters
+
+// Set
+//       This module matches IP sets which can be defined by ipset(8).
+//
+//       [!] --match-set setname flag[,flag]...
+//              where flags are the comma separated list of src and/or dst
+//              specifications and there can be no more than six of them.
+//              Hence the command
+//
+//                     iptables -A FORWARD -m set --match-set test src,dst
+//
+//              will match packets, for which (if the set type is ipportmap)
+//              the source address and destination port pair can be found
+//              in the specified set.
+//
+// ref. iptables-extensions(8) > set
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SetFlag string
+
+const (
+	// SetFlagSrc will match the set against the source address or port
+	SetFlagSrc SetFlag = "src"
+	// SetFlagDst will match the set against the destination address or port
+	SetFlagDst SetFlag = "dst"
+)
+
+type SetParameter struct {
+	name     string
+	flags    []string
+	negative bool
+}
+
+func (p *SetParameter) Negate() ParameterBuilder {
+	p.negative = !p.negative
+
+	return p
+}
+
+func (p *SetParameter) Build(bool) string {
+	result := fmt.Sprintf("--match-set %s %s", p.name, strings.Join(p.flags, ","))
+
+	if p.negative {
+		return fmt.Sprintf("! %s", result)
+	}
+
+	return result
+}
+
+func matchSet(name string, flag SetFlag, flags []SetFlag, negative bool) *SetParameter {
+	values := []string{string(flag)}
+
+	for _, f := range flags {
+		values = append(values, string(f))
+	}
+
+	return &SetParameter{
+		name:     name,
+		flags:    values,
+		negative: negative,
+	}
+}
+
+// MatchSet matches packets, addresses or ports of which (depending on provided
+// flags) can be found in the ipset with given name. At least one flag
+// is necessary, so that's the reason for split of parameters
+func MatchSet(name string, flag SetFlag, flags ...SetFlag) *SetParameter {
+	return matchSet(name, flag, flags, false)
+}
+
+func NotMatchSet(name string, flag SetFlag, flags ...SetFlag) *SetParameter {
+	return matchSet(name, flag, flags, true)
+}
+
+// Set matches IP sets which can be defined by ipset(8)
+func Set(setParameters ...*SetParameter) *MatchParameter {
+	var parameters []ParameterBuilder
+
+	for _, parameter := range setParameters {
+		parameters = append(parameters, parameter)
+	}
+
+	return &MatchParameter{
+		name:       "set",
+		parameters: parameters,
+	}
+}
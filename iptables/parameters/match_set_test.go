@@ -0,0 +1,56 @@
+package parameters_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/parameters"
+)
+
+var _ = Describe("SetParameter", func() {
+	Describe("MatchSet", func() {
+		DescribeTable("should build valid match-set parameter",
+			func(parameter *SetParameter, verbose bool, want string) {
+				// when
+				got := parameter.Build(verbose)
+
+				// then
+				Expect(got).To(Equal(want))
+			},
+			Entry("destination",
+				MatchSet("MESH_OUTBOUND_EXCL_NET", SetFlagDst), false,
+				"--match-set MESH_OUTBOUND_EXCL_NET dst",
+			),
+			Entry("destination - verbose",
+				MatchSet("MESH_OUTBOUND_EXCL_NET", SetFlagDst), true,
+				"--match-set MESH_OUTBOUND_EXCL_NET dst",
+			),
+			Entry("source and destination",
+				MatchSet("foo", SetFlagSrc, SetFlagDst), false,
+				"--match-set foo src,dst",
+			),
+			Entry("negated source",
+				NotMatchSet("foo", SetFlagSrc), false,
+				"! --match-set foo src",
+			),
+		)
+	})
+
+	Describe("Set", func() {
+		It("should build valid set match", func() {
+			// when
+			got := Match(Set(MatchSet("foo", SetFlagDst))).Build(false)
+
+			// then
+			Expect(got).To(Equal("-m set --match-set foo dst"))
+		})
+
+		It("should negate nested match-set parameters", func() {
+			// when
+			got := Match(Set(MatchSet("foo", SetFlagDst))).Negate().Build(false)
+
+			// then
+			Expect(got).To(Equal("-m set ! --match-set foo dst"))
+		})
+	})
+})
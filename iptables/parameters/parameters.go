@@ -62,3 +62,15 @@ func (p *Parameter) Negate() ParameterBuilder {
 
 	return p.negate(p)
 }
+
+// quoteArgument quotes the argument if it contains characters, which would
+// split it into multiple arguments when parsed by iptables-restore
+func quoteArgument(argument string) string {
+	if argument != "" && !strings.ContainsAny(argument, " \t\"\\") {
+		return argument
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	return `"` + replacer.Replace(argument) + `"`
+}
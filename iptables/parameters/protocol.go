@@ -104,6 +104,17 @@ func SourcePort(port uint16) *TcpUdpParameter {
 	return sourcePort(port, false)
 }
 
+// TcpFlags matches when the TCP flags listed in the mask (i.e. SYN,RST,ACK,FIN,
+// or ALL and NONE) are set as listed in comp, i.e. TcpFlags("SYN,FIN", "SYN,FIN")
+// matches packets with both SYN and FIN flags set
+func TcpFlags(mask string, comp string) *TcpUdpParameter {
+	return &TcpUdpParameter{
+		long:  "--tcp-flags",
+		short: "--tcp-flags",
+		value: mask + " " + comp,
+	}
+}
+
 func tcpUdp(proto string, params []*TcpUdpParameter) *ProtocolParameter {
 	var parameters []ParameterBuilder
 
@@ -127,6 +138,61 @@ func Tcp(tcpParameters ...*TcpUdpParameter) *ProtocolParameter {
 	return tcpUdp("tcp", tcpParameters)
 }
 
+type IcmpParameter struct {
+	long     string
+	short    string
+	value    string
+	negative bool
+}
+
+func (p *IcmpParameter) Build(verbose bool) string {
+	flag := p.short
+
+	if verbose {
+		flag = p.long
+	}
+
+	result := []string{flag}
+
+	if p.negative {
+		result = append([]string{"!"}, result...)
+	}
+
+	result = append(result, p.value)
+
+	return strings.Join(result, " ")
+}
+
+func (p *IcmpParameter) Negate() ParameterBuilder {
+	p.negative = !p.negative
+
+	return p
+}
+
+// Icmpv6Type matches the ICMPv6 type by its name (i.e. neighbour-solicitation)
+func Icmpv6Type(icmpType string) *IcmpParameter {
+	return &IcmpParameter{
+		long:  "--icmpv6-type",
+		short: "--icmpv6-type",
+		value: icmpType,
+	}
+}
+
+func Icmpv6(icmpParameters ...*IcmpParameter) *ProtocolParameter {
+	var parameters []ParameterBuilder
+
+	for _, parameter := range icmpParameters {
+		if parameter != nil {
+			parameters = append(parameters, parameter)
+		}
+	}
+
+	return &ProtocolParameter{
+		name:       "ipv6-icmp",
+		parameters: parameters,
+	}
+}
+
 func Protocol(parameter *ProtocolParameter) *Parameter {
 	return &Parameter{
 		long:       "--protocol",
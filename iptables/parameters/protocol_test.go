@@ -190,5 +190,45 @@ var _ = Describe("ProtocolParameter", func() {
 				"udp ! --destination-port 53",
 			),
 		)
+
+		DescribeTable("TcpFlags",
+			func(mask string, comp string, verbose bool, want string) {
+				// when
+				got := Tcp(TcpFlags(mask, comp)).Build(verbose)
+
+				// then
+				Expect(got).To(Equal(want))
+			},
+			Entry("SYN,FIN SYN,FIN",
+				"SYN,FIN", "SYN,FIN", false,
+				"tcp --tcp-flags SYN,FIN SYN,FIN",
+			),
+			Entry("ALL NONE - verbose",
+				"ALL", "NONE", true,
+				"tcp --tcp-flags ALL NONE",
+			),
+		)
+
+		DescribeTable("Icmpv6",
+			func(parameters []*IcmpParameter, verbose bool, want string) {
+				// when
+				got := Icmpv6(parameters...).Build(verbose)
+
+				// then
+				Expect(got).To(Equal(want))
+			},
+			Entry("no parameters",
+				nil, false,
+				"ipv6-icmp",
+			),
+			Entry("1 parameter (Icmpv6Type(neighbour-solicitation))",
+				[]*IcmpParameter{Icmpv6Type("neighbour-solicitation")}, false,
+				"ipv6-icmp --icmpv6-type neighbour-solicitation",
+			),
+			Entry("1 parameter (Icmpv6Type(neighbour-solicitation)) - verbose",
+				[]*IcmpParameter{Icmpv6Type("neighbour-solicitation")}, true,
+				"ipv6-icmp --icmpv6-type neighbour-solicitation",
+			),
+		)
 	})
 })
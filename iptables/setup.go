@@ -7,16 +7,41 @@ import (
 
 func Setup(cfg config.Config) (string, error) {
 	if cfg.DryRun {
+		var dnsIpv4, dnsIpv6 []string
+
+		if cfg.ShouldRedirectDNS() && !cfg.ShouldCaptureAllDNS() {
+			var err error
+
+			if dnsIpv4, dnsIpv6, err = builder.GetDnsServersFromConfig(cfg); err != nil {
+				return "", err
+			}
+		}
+
 		// TODO (bartsmykla): we should generate IPv4 and IPv6 when cfg.IPv6 is
 		//  set, but currently in DryRun mode we would just display IPv6
 		//  configuration when cfg.IPv6 is set
-		// TODO (bartsmykla): I think dns servers should be provided as a config
-		//  value instead of explicit function parameter here
-		output, err := builder.BuildIPTables(cfg, nil, cfg.IPv6)
+		dnsServers := dnsIpv4
+		if cfg.IPv6 {
+			dnsServers = dnsIpv6
+		}
+
+		ipSets, err := builder.BuildIPSets(cfg)
+		if err != nil {
+			return "", err
+		}
+
+		output, err := builder.BuildIPTables(cfg, dnsServers, cfg.IPv6)
 		if err != nil {
 			return "", err
 		}
 
+		// returned output is the iptables-restore input, so ipsets referenced
+		// by the rules are only printed, separated by the comments with
+		// names of the commands
+		if ipSets != "" {
+			_, _ = cfg.RuntimeStdout.Write([]byte("# ipset restore\n" + ipSets + "# iptables-restore\n"))
+		}
+
 		_, _ = cfg.RuntimeStdout.Write([]byte(output))
 
 		return output, nil
@@ -24,3 +49,27 @@ func Setup(cfg config.Config) (string, error) {
 
 	return builder.RestoreIPTables(cfg)
 }
+
+// Uninstall removes rules and chains created by Setup, and then ipsets
+// referenced by them (sets which are still referenced by iptables rules
+// cannot be destroyed)
+func Uninstall(cfg config.Config) (string, error) {
+	output, err := builder.CleanupIPTables(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	ipSetsOutput, err := builder.CleanupIPSets(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return output + ipSetsOutput, nil
+}
+
+// Cleanup removes rules, chains and ipsets created by Setup
+//
+// Deprecated: use Uninstall instead
+func Cleanup(cfg config.Config) (string, error) {
+	return Uninstall(cfg)
+}
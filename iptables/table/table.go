@@ -15,6 +15,16 @@ type TableBuilder struct {
 	chains    []*chain.Chain
 }
 
+// Update returns the table builder which is not creating any chains, so it can
+// be used to update rules of already existing chains (with iptables-restore
+// run with --noflush flag)
+func Update(name string, chains ...*chain.Chain) *TableBuilder {
+	return &TableBuilder{
+		name:   name,
+		chains: chains,
+	}
+}
+
 // Build
 // TODO (bartsmykla): refactor
 // TODO (bartsmykla): add tests
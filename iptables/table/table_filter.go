@@ -0,0 +1,63 @@
+package table
+
+import (
+	"github.com/kumahq/kuma-net/iptables/chain"
+)
+
+type FilterTable struct {
+	input   *chain.Chain
+	forward *chain.Chain
+	output  *chain.Chain
+
+	// custom chains
+	chains []*chain.Chain
+}
+
+func (t *FilterTable) Input() *chain.Chain {
+	return t.input
+}
+
+func (t *FilterTable) Forward() *chain.Chain {
+	return t.forward
+}
+
+func (t *FilterTable) Output() *chain.Chain {
+	return t.output
+}
+
+func (t *FilterTable) WithChain(chain *chain.Chain) *FilterTable {
+	t.chains = append(t.chains, chain)
+
+	return t
+}
+
+// Chains returns built-in and custom chains of the table
+func (t *FilterTable) Chains() []*chain.Chain {
+	return append([]*chain.Chain{
+		t.input,
+		t.forward,
+		t.output,
+	}, t.chains...)
+}
+
+func (t *FilterTable) Build(verbose bool) string {
+	table := &TableBuilder{
+		name:      "filter",
+		newChains: t.chains,
+		chains: []*chain.Chain{
+			t.input,
+			t.forward,
+			t.output,
+		},
+	}
+
+	return table.Build(verbose)
+}
+
+func Filter() *FilterTable {
+	return &FilterTable{
+		input:   chain.NewChain("INPUT"),
+		forward: chain.NewChain("FORWARD"),
+		output:  chain.NewChain("OUTPUT"),
+	}
+}
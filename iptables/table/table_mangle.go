@@ -10,6 +10,9 @@ type MangleTable struct {
 	forward     *chain.Chain
 	output      *chain.Chain
 	postrouting *chain.Chain
+
+	// custom chains
+	chains []*chain.Chain
 }
 
 func (t *MangleTable) Prerouting() *chain.Chain {
@@ -32,9 +35,27 @@ func (t *MangleTable) Postrouting() *chain.Chain {
 	return t.postrouting
 }
 
+func (t *MangleTable) WithChain(chain *chain.Chain) *MangleTable {
+	t.chains = append(t.chains, chain)
+
+	return t
+}
+
+// Chains returns built-in and custom chains of the table
+func (t *MangleTable) Chains() []*chain.Chain {
+	return append([]*chain.Chain{
+		t.prerouting,
+		t.input,
+		t.forward,
+		t.output,
+		t.postrouting,
+	}, t.chains...)
+}
+
 func (t *MangleTable) Build(verbose bool) string {
 	table := &TableBuilder{
-		name: "mangle",
+		name:      "mangle",
+		newChains: t.chains,
 		chains: []*chain.Chain{
 			t.prerouting,
 			t.input,
@@ -36,6 +36,16 @@ func (t *NatTable) WithChain(chain *chain.Chain) *NatTable {
 	return t
 }
 
+// Chains returns built-in and custom chains of the table
+func (t *NatTable) Chains() []*chain.Chain {
+	return append([]*chain.Chain{
+		t.prerouting,
+		t.input,
+		t.output,
+		t.postrouting,
+	}, t.chains...)
+}
+
 func (t *NatTable) Build(verbose bool) string {
 	table := &TableBuilder{
 		name:      "nat",
@@ -7,6 +7,9 @@ import (
 type RawTable struct {
 	prerouting *chain.Chain
 	output     *chain.Chain
+
+	// custom chains
+	chains []*chain.Chain
 }
 
 func (t *RawTable) Prerouting() *chain.Chain {
@@ -17,9 +20,24 @@ func (t *RawTable) Output() *chain.Chain {
 	return t.output
 }
 
+func (t *RawTable) WithChain(chain *chain.Chain) *RawTable {
+	t.chains = append(t.chains, chain)
+
+	return t
+}
+
+// Chains returns built-in and custom chains of the table
+func (t *RawTable) Chains() []*chain.Chain {
+	return append([]*chain.Chain{
+		t.prerouting,
+		t.output,
+	}, t.chains...)
+}
+
 func (t *RawTable) Build(verbose bool) string {
 	table := &TableBuilder{
-		name: "raw",
+		name:      "raw",
+		newChains: t.chains,
 		chains: []*chain.Chain{
 			t.prerouting,
 			t.output,
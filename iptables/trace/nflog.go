@@ -0,0 +1,154 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// nfnetlink_log message types, attributes and configuration commands
+// ref. include/uapi/linux/netfilter/nfnetlink_log.h
+const (
+	nfnlSubsysUlog = 4
+
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaIfindexIndev  = 4
+	nfulaIfindexOutdev = 5
+	nfulaPayload       = 9
+	nfulaPrefix        = 10
+	nfulaUid           = 11
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind = 1
+
+	nfulnlCopyPacket = 2
+
+	// nfgenmsgLen is the length of the nfnetlink header (struct nfgenmsg)
+	nfgenmsgLen = 4
+	// nlaHeaderLen is the length of the netlink attribute header
+	nlaHeaderLen = 4
+	// nlaTypeMask masks out nested and byte order flags of attribute types
+	nlaTypeMask = 0x3fff
+)
+
+func nlaAlign(length int) int {
+	return (length + 3) &^ 3
+}
+
+// parseAttributes returns values of netlink attributes by their types
+func parseAttributes(data []byte) (map[uint16][]byte, error) {
+	attributes := map[uint16][]byte{}
+
+	for len(data) >= nlaHeaderLen {
+		length := int(nl.NativeEndian().Uint16(data[0:2]))
+		kind := nl.NativeEndian().Uint16(data[2:4]) & nlaTypeMask
+
+		if length < nlaHeaderLen || length > len(data) {
+			return nil, fmt.Errorf("invalid length of attribute %d: %d", kind, length)
+		}
+
+		attributes[kind] = data[nlaHeaderLen:length]
+
+		if nlaAlign(length) >= len(data) {
+			break
+		}
+
+		data = data[nlaAlign(length):]
+	}
+
+	return attributes, nil
+}
+
+// parsePayload fills addresses, protocol and ports of the event from
+// the IPv4 or IPv6 header of the logged packet (IPv6 extension headers
+// are not followed)
+func parsePayload(event *Event, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+
+	var transport []byte
+
+	switch payload[0] >> 4 {
+	case 4:
+		headerLen := int(payload[0]&0x0f) * 4
+		if len(payload) < 20 || len(payload) < headerLen {
+			return
+		}
+
+		event.Protocol = payload[9]
+		event.Source = net.IP(payload[12:16])
+		event.Destination = net.IP(payload[16:20])
+		transport = payload[headerLen:]
+	case 6:
+		if len(payload) < 40 {
+			return
+		}
+
+		event.IPv6 = true
+		event.Protocol = payload[6]
+		event.Source = net.IP(payload[8:24])
+		event.Destination = net.IP(payload[24:40])
+		transport = payload[40:]
+	default:
+		return
+	}
+
+	// tcp, udp and sctp are starting with the source and destination ports
+	switch event.Protocol {
+	case 6, 17, 132:
+		if len(transport) >= 4 {
+			event.SourcePort = binary.BigEndian.Uint16(transport[0:2])
+			event.DestinationPort = binary.BigEndian.Uint16(transport[2:4])
+		}
+	}
+}
+
+// ParseNflogMessage parses the nfnetlink_log packet message (the data
+// of the netlink message, which starts with the nfnetlink header followed
+// by attributes) into the event. Messages logged by rules other than traced
+// ones are returned with false
+func ParseNflogMessage(data []byte) (*Event, bool, error) {
+	if len(data) < nfgenmsgLen {
+		return nil, false, fmt.Errorf("message is too short (%d bytes)", len(data))
+	}
+
+	attributes, err := parseAttributes(data[nfgenmsgLen:])
+	if err != nil {
+		return nil, false, err
+	}
+
+	prefix, ok := attributes[nfulaPrefix]
+	if !ok {
+		return nil, false, nil
+	}
+
+	event, ok := ParsePrefix(strings.TrimRight(string(prefix), "\x00"))
+	if !ok {
+		return nil, false, nil
+	}
+
+	if uid, ok := attributes[nfulaUid]; ok && len(uid) >= 4 {
+		event.UID = binary.BigEndian.Uint32(uid)
+		event.HasUID = true
+	}
+
+	if index, ok := attributes[nfulaIfindexIndev]; ok && len(index) >= 4 {
+		event.InInterface = binary.BigEndian.Uint32(index)
+	}
+
+	if index, ok := attributes[nfulaIfindexOutdev]; ok && len(index) >= 4 {
+		event.OutInterface = binary.BigEndian.Uint32(index)
+	}
+
+	parsePayload(&event, attributes[nfulaPayload])
+
+	return &event, true, nil
+}
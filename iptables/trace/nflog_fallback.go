@@ -0,0 +1,12 @@
+//go:build !linux
+
+package trace
+
+import (
+	"fmt"
+)
+
+// ListenNflog is only supported on linux
+func ListenNflog(group uint16, events chan<- Event, stop <-chan struct{}) error {
+	return fmt.Errorf("listening for nflog messages is only supported on linux")
+}
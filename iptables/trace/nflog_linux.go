@@ -0,0 +1,178 @@
+//go:build linux
+
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// nflogPollTimeout is the time (in milliseconds) after which
+	// the listener will check if it should stop
+	nflogPollTimeout = 500
+	// nflogCopyRange is the amount of bytes of logged packets copied
+	// to the userspace (headers are enough to build events)
+	nflogCopyRange = 128
+)
+
+// nfgenmsg is the nfnetlink header (struct nfgenmsg)
+type nfgenmsg struct {
+	family  uint8
+	version uint8
+	resID   uint16
+}
+
+func (m *nfgenmsg) Len() int {
+	return nfgenmsgLen
+}
+
+func (m *nfgenmsg) Serialize() []byte {
+	b := make([]byte, nfgenmsgLen)
+	b[0] = m.family
+	b[1] = m.version
+	binary.BigEndian.PutUint16(b[2:], m.resID)
+
+	return b
+}
+
+func newConfigRequest(group uint16, attributes ...*nl.RtAttr) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(nfnlSubsysUlog<<8|nfulnlMsgConfig, unix.NLM_F_ACK)
+	req.AddData(&nfgenmsg{family: unix.AF_UNSPEC, resID: group})
+
+	for _, attribute := range attributes {
+		req.AddData(attribute)
+	}
+
+	return req
+}
+
+// execute sends the request and waits for its acknowledgment
+func execute(fd int, req *nl.NetlinkRequest) error {
+	if err := unix.Sendto(fd, req.Serialize(), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err == unix.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, m := range messages {
+			if m.Header.Seq != req.Seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+
+			if len(m.Data) < 4 {
+				return fmt.Errorf("invalid acknowledgment")
+			}
+
+			if code := int32(nl.NativeEndian().Uint32(m.Data[0:4])); code != 0 {
+				return syscall.Errno(-code)
+			}
+
+			return nil
+		}
+	}
+}
+
+// ListenNflog binds to the provided nflog group and sends events logged
+// by traced rules to the events channel. It blocks until the stop channel
+// is closed. Only one listener can be bound to the group at the same time
+func ListenNflog(group uint16, events chan<- Event, stop <-chan struct{}) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("unable to open netfilter netlink socket: %s", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("unable to bind netfilter netlink socket: %s", err)
+	}
+
+	bind := newConfigRequest(group, nl.NewRtAttr(nfulaCfgCmd, []byte{nfulnlCfgCmdBind}))
+	if err := execute(fd, bind); err != nil {
+		return fmt.Errorf("unable to bind to nflog group %d: %s", group, err)
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode[0:4], nflogCopyRange)
+	mode[4] = nfulnlCopyPacket
+
+	if err := execute(fd, newConfigRequest(group, nl.NewRtAttr(nfulaCfgMode, mode))); err != nil {
+		return fmt.Errorf("unable to set copy mode of nflog group %d: %s", group, err)
+	}
+
+	buf := make([]byte, 64*unix.Getpagesize())
+	pollFds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		n, err := unix.Poll(pollFds, nflogPollTimeout)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("polling nflog messages failed: %s", err)
+		}
+
+		read, _, err := unix.Recvfrom(fd, buf, unix.MSG_DONTWAIT)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+
+		// messages were dropped as the socket buffer was full, which can
+		// happen with high limits of traced rules
+		if err == unix.ENOBUFS {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("reading nflog messages failed: %s", err)
+		}
+
+		messages, err := syscall.ParseNetlinkMessage(buf[:read])
+		if err != nil {
+			continue
+		}
+
+		for _, m := range messages {
+			if m.Header.Type != nfnlSubsysUlog<<8|nfulnlMsgPacket {
+				continue
+			}
+
+			event, ok, err := ParseNflogMessage(m.Data)
+			if err != nil || !ok {
+				continue
+			}
+
+			select {
+			case events <- *event:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
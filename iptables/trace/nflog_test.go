@@ -0,0 +1,156 @@
+package trace_test
+
+import (
+	"encoding/binary"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink/nl"
+
+	. "github.com/kumahq/kuma-net/iptables/trace"
+)
+
+func attribute(kind uint16, value []byte) []byte {
+	length := 4 + len(value)
+	b := make([]byte, (length+3)&^3)
+	nl.NativeEndian().PutUint16(b[0:2], uint16(length))
+	nl.NativeEndian().PutUint16(b[2:4], kind)
+	copy(b[4:], value)
+
+	return b
+}
+
+func uint32Value(value uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+
+	return b
+}
+
+func message(attributes ...[]byte) []byte {
+	// nfgenmsg: AF_INET, NFNETLINK_V0, group 0
+	data := []byte{2, 0, 0, 0}
+	for _, a := range attributes {
+		data = append(data, a...)
+	}
+
+	return data
+}
+
+func ipv4TCPPacket(src, dst string, srcPort, dstPort uint16) []byte {
+	b := make([]byte, 24)
+	b[0] = 0x45
+	b[9] = 6
+	copy(b[12:16], net.ParseIP(src).To4())
+	copy(b[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(b[20:22], srcPort)
+	binary.BigEndian.PutUint16(b[22:24], dstPort)
+
+	return b
+}
+
+func ipv6UDPPacket(src, dst string, srcPort, dstPort uint16) []byte {
+	b := make([]byte, 44)
+	b[0] = 0x60
+	b[6] = 17
+	copy(b[8:24], net.ParseIP(src).To16())
+	copy(b[24:40], net.ParseIP(dst).To16())
+	binary.BigEndian.PutUint16(b[40:42], srcPort)
+	binary.BigEndian.PutUint16(b[42:44], dstPort)
+
+	return b
+}
+
+var _ = Describe("ParseNflogMessage", func() {
+	It("should parse IPv4 packet", func() {
+		// given
+		data := message(
+			attribute(10, []byte("kuma:REDIRECT:outbound/redirect\x00")),
+			attribute(5, uint32Value(2)),
+			attribute(11, uint32Value(1000)),
+			attribute(9, ipv4TCPPacket("10.0.0.1", "10.0.0.2", 43210, 80)),
+		)
+
+		// when
+		event, ok, err := ParseNflogMessage(data)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(event.Prefix).To(Equal("kuma"))
+		Expect(event.Decision).To(Equal(DecisionRedirect))
+		Expect(event.Purpose).To(Equal("outbound/redirect"))
+		Expect(event.IPv6).To(BeFalse())
+		Expect(event.Protocol).To(BeEquivalentTo(6))
+		Expect(event.Source.String()).To(Equal("10.0.0.1"))
+		Expect(event.Destination.String()).To(Equal("10.0.0.2"))
+		Expect(event.SourcePort).To(BeEquivalentTo(43210))
+		Expect(event.DestinationPort).To(BeEquivalentTo(80))
+		Expect(event.HasUID).To(BeTrue())
+		Expect(event.UID).To(BeEquivalentTo(1000))
+		Expect(event.InInterface).To(BeZero())
+		Expect(event.OutInterface).To(BeEquivalentTo(2))
+	})
+
+	It("should parse IPv6 packet", func() {
+		// given
+		data := message(
+			attribute(10, []byte("kuma:RETURN:inbound/exclude-port\x00")),
+			attribute(4, uint32Value(3)),
+			attribute(9, ipv6UDPPacket("fd00::1", "fd00::2", 5353, 53)),
+		)
+
+		// when
+		event, ok, err := ParseNflogMessage(data)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(event.Decision).To(Equal(DecisionReturn))
+		Expect(event.Purpose).To(Equal("inbound/exclude-port"))
+		Expect(event.IPv6).To(BeTrue())
+		Expect(event.Protocol).To(BeEquivalentTo(17))
+		Expect(event.Source.String()).To(Equal("fd00::1"))
+		Expect(event.Destination.String()).To(Equal("fd00::2"))
+		Expect(event.SourcePort).To(BeEquivalentTo(5353))
+		Expect(event.DestinationPort).To(BeEquivalentTo(53))
+		Expect(event.HasUID).To(BeFalse())
+		Expect(event.InInterface).To(BeEquivalentTo(3))
+	})
+
+	It("should ignore messages not logged by traced rules", func() {
+		// given
+		data := message(
+			attribute(10, []byte("dropped\x00")),
+			attribute(9, ipv4TCPPacket("10.0.0.1", "10.0.0.2", 43210, 80)),
+		)
+
+		// when
+		event, ok, err := ParseNflogMessage(data)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(event).To(BeNil())
+	})
+
+	It("should return error for malformed attributes", func() {
+		// given
+		data := message([]byte{0xff, 0x00, 0x0a, 0x00})
+
+		// when
+		_, _, err := ParseNflogMessage(data)
+
+		// then
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return error for too short message", func() {
+		// when
+		_, _, err := ParseNflogMessage([]byte{2, 0})
+
+		// then
+		Expect(err).To(HaveOccurred())
+	})
+})
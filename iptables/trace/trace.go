@@ -0,0 +1,78 @@
+package trace
+
+import (
+	"net"
+	"strings"
+)
+
+// Decisions of the traced rules (targets of the rules), which are placed
+// in prefixes of logged messages
+const (
+	DecisionReturn   = "RETURN"
+	DecisionRedirect = "REDIRECT"
+	DecisionCT       = "CT"
+	DecisionDrop     = "DROP"
+	DecisionReject   = "REJECT"
+)
+
+// prefixSeparator separates parts of the prefix of logged messages
+const prefixSeparator = ":"
+
+// Event is the packet logged by the traced rule
+type Event struct {
+	// Prefix, Decision and Purpose are parsed from the prefix of the logged
+	// message (Purpose is empty when rules are not commented)
+	Prefix   string
+	Decision string
+	Purpose  string
+
+	IPv6 bool
+	// Protocol is the IP protocol number (i.e. unix.IPPROTO_TCP)
+	Protocol        uint8
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      uint16
+	DestinationPort uint16
+	// UID is the owner of the socket which created the packet, when HasUID
+	// is set (it's not known i.e. for incoming packets)
+	UID    uint32
+	HasUID bool
+	// InInterface and OutInterface are indexes of the input and output
+	// interfaces (0 when not known)
+	InInterface  uint32
+	OutInterface uint32
+}
+
+// FormatPrefix returns the prefix of logged messages in format
+// "<prefix>:<decision>:<purpose>", truncated to maxLen characters
+// (as prefixes of LOG and NFLOG targets are limited)
+func FormatPrefix(prefix, decision, purpose string, maxLen int) string {
+	result := strings.Join([]string{prefix, decision, purpose}, prefixSeparator)
+
+	if len(result) > maxLen {
+		return result[:maxLen]
+	}
+
+	return result
+}
+
+// ParsePrefix parses the prefix of the logged message (in format returned
+// by FormatPrefix) into the event. The message is not the one logged
+// by the traced rule, when false is returned
+func ParsePrefix(logPrefix string) (Event, bool) {
+	parts := strings.SplitN(strings.TrimSpace(logPrefix), prefixSeparator, 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Event{}, false
+	}
+
+	event := Event{
+		Prefix:   parts[0],
+		Decision: parts[1],
+	}
+
+	if len(parts) == 3 {
+		event.Purpose = parts[2]
+	}
+
+	return event, true
+}
@@ -0,0 +1,63 @@
+package trace_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kumahq/kuma-net/iptables/trace"
+)
+
+var _ = Describe("Prefix", func() {
+	DescribeTable("FormatPrefix",
+		func(prefix, decision, purpose string, maxLen int, want string) {
+			// when
+			got := FormatPrefix(prefix, decision, purpose, maxLen)
+
+			// then
+			Expect(got).To(Equal(want))
+		},
+		Entry("fits",
+			"kuma", DecisionRedirect, "outbound/redirect", 63,
+			"kuma:REDIRECT:outbound/redirect",
+		),
+		Entry("without purpose",
+			"kuma", DecisionReturn, "", 63,
+			"kuma:RETURN:",
+		),
+		Entry("truncated",
+			"kuma", DecisionReturn, "outbound/exclude-port", 20,
+			"kuma:RETURN:outbound",
+		),
+	)
+
+	DescribeTable("ParsePrefix",
+		func(logPrefix string, want Event, wantOk bool) {
+			// when
+			got, ok := ParsePrefix(logPrefix)
+
+			// then
+			Expect(ok).To(Equal(wantOk))
+			Expect(got).To(Equal(want))
+		},
+		Entry("with purpose",
+			"kuma:REDIRECT:outbound/redirect",
+			Event{Prefix: "kuma", Decision: DecisionRedirect, Purpose: "outbound/redirect"}, true,
+		),
+		Entry("with trailing space (LOG prefix)",
+			"kuma:DROP:egress/block ",
+			Event{Prefix: "kuma", Decision: DecisionDrop, Purpose: "egress/block"}, true,
+		),
+		Entry("without purpose",
+			"kuma:RETURN:",
+			Event{Prefix: "kuma", Decision: DecisionReturn}, true,
+		),
+		Entry("not traced",
+			"IN=eth0 OUT= MAC=",
+			Event{}, false,
+		),
+		Entry("empty",
+			"",
+			Event{}, false,
+		),
+	)
+})
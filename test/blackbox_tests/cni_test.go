@@ -0,0 +1,109 @@
+package blackbox_tests_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/cni"
+	"github.com/kumahq/kuma-net/iptables/builder"
+	cni_framework "github.com/kumahq/kuma-net/test/framework/cni"
+	"github.com/kumahq/kuma-net/test/framework/ip"
+	"github.com/kumahq/kuma-net/test/framework/netns"
+	"github.com/kumahq/kuma-net/test/framework/socket"
+	"github.com/kumahq/kuma-net/test/framework/tcp"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+var _ = Describe("CNI plugin", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	It("should install the transparent proxy in the pod network namespace", func() {
+		// given
+		ports := socket.GenerateRandomPortsSlice(2)
+		serverPort, randomPort := ports[0], ports[1]
+
+		tproxyConfig := config.Config{
+			Redirect: config.Redirect{
+				Inbound: config.TrafficFlow{
+					Enabled: true,
+				},
+				Outbound: config.TrafficFlow{
+					Enabled: true,
+					Port:    serverPort,
+				},
+			},
+			// counters are mapped to purposes of the rules from comments
+			Comment: config.Comment{Enabled: true},
+		}
+
+		tcpReadyC, tcpErrC := tcp.UnsafeStartTCPServer(
+			ns,
+			fmt.Sprintf(":%d", serverPort),
+			tcp.ReplyWithOriginalDstIPv4,
+			tcp.CloseConn,
+		)
+		Eventually(tcpReadyC).Should(BeClosed())
+		Consistently(tcpErrC).ShouldNot(Receive())
+
+		// when
+		_, err = cni_framework.InvokeInNetNS(ns, cni.CommandAdd, tproxyConfig)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cni_framework.InvokeInNetNS(ns, cni.CommandCheck, tproxyConfig)).Error().To(Succeed())
+
+		// when ADD is retried
+		_, err = cni_framework.InvokeInNetNS(ns, cni.CommandAdd, tproxyConfig)
+
+		// then rules are not duplicated
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(ns.UnsafeExec(func() {
+			statuses, err := builder.GetStatus(tproxyConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			var outboundJumps int
+			for _, counter := range statuses[0].Counters {
+				if counter.Table == "nat" && counter.Chain == "OUTPUT" && counter.Purpose == "outbound" {
+					outboundJumps++
+				}
+			}
+			Expect(outboundJumps).To(Equal(1))
+		})).Should(BeClosed())
+
+		Eventually(ns.UnsafeExec(func() {
+			address := ip.GenRandomIPv4()
+
+			Expect(tcp.DialIPWithPortAndGetReply(address, randomPort)).
+				To(Equal(fmt.Sprintf("%s:%d", address, randomPort)))
+		})).Should(BeClosed())
+
+		Eventually(tcpErrC).Should(BeClosed())
+
+		// when
+		_, err = cni_framework.InvokeInNetNS(ns, cni.CommandDel, tproxyConfig)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cni_framework.InvokeInNetNS(ns, cni.CommandCheck, tproxyConfig)).
+			Error().To(MatchError(ContainSubstring("not installed")))
+
+		Eventually(ns.UnsafeExec(func() {
+			statuses, err := builder.GetStatus(tproxyConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(HaveLen(1))
+			Expect(statuses[0].Installed).To(BeFalse())
+		})).Should(BeClosed())
+	})
+})
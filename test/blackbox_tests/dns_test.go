@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"time"
@@ -196,14 +198,15 @@ var _ = Describe("Outbound IPv6 DNS/UDP traffic to port 53", func() {
 	})
 
 	DescribeTable("should be redirected to provided port",
-		func(randomPort uint16) {
+		func(port, portIPv6 uint16) {
 			// given
 			address := udp.GenRandomAddressIPv6(consts.DNSPort)
 			tproxyConfig := config.Config{
 				Redirect: config.Redirect{
 					DNS: config.DNS{
 						Enabled:    true,
-						Port:       randomPort,
+						Port:       port,
+						PortIPv6:   portIPv6,
 						CaptureAll: true,
 					},
 					Inbound: config.TrafficFlow{
@@ -216,7 +219,7 @@ var _ = Describe("Outbound IPv6 DNS/UDP traffic to port 53", func() {
 				IPv6:          true,
 				RuntimeStdout: ioutil.Discard,
 			}
-			serverAddress := fmt.Sprintf("%s:%d", consts.LocalhostIPv6, randomPort)
+			serverAddress := fmt.Sprintf("%s:%d", consts.LocalhostIPv6, tproxyConfig.Redirect.DNS.GetPort(true))
 
 			readyC, errC := udp.UnsafeStartUDPServer(ns, serverAddress, udp.ReplyWithReceivedMsg)
 			Consistently(errC).ShouldNot(Receive())
@@ -241,12 +244,23 @@ var _ = Describe("Outbound IPv6 DNS/UDP traffic to port 53", func() {
 			lockedPorts := []uint16{consts.DNSPort}
 
 			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
-				randomPorts := socket.GenerateRandomPortsSlice(1, lockedPorts...)
+				// The first port will be used for both IPv4 and IPv6 traffic,
+				// when the second one (IPv6 specific) is not set
+				randomPorts := socket.GenerateRandomPortsSlice(2, lockedPorts...)
 				// This gives us more entropy as all generated ports will be
 				// different from each other
 				lockedPorts = append(lockedPorts, randomPorts...)
-				desc := fmt.Sprintf("to port %%d, from port %d", consts.DNSPort)
-				entry := Entry(EntryDescription(desc), randomPorts[0])
+				desc := fmt.Sprintf("to port %d, from port %d", randomPorts[0], consts.DNSPort)
+				entry := Entry(EntryDescription(desc), randomPorts[0], uint16(0))
+				entries = append(entries, entry)
+
+				desc = fmt.Sprintf(
+					"to ipv6 specific port %d (ipv4 port: %d), from port %d",
+					randomPorts[1],
+					randomPorts[0],
+					consts.DNSPort,
+				)
+				entry = Entry(EntryDescription(desc), randomPorts[0], randomPorts[1])
 				entries = append(entries, entry)
 			}
 
@@ -269,7 +283,7 @@ var _ = Describe("Outbound IPv6 DNS/TCP traffic to port 53", func() {
 	})
 
 	DescribeTable("should be redirected to provided port",
-		func(dnsPort, outboundPort uint16) {
+		func(dnsPort, dnsPortIPv6, outboundPort uint16) {
 			// given
 			address := tcp.GenRandomAddressIPv6(consts.DNSPort)
 			tproxyConfig := config.Config{
@@ -277,6 +291,7 @@ var _ = Describe("Outbound IPv6 DNS/TCP traffic to port 53", func() {
 					DNS: config.DNS{
 						Enabled:    true,
 						Port:       dnsPort,
+						PortIPv6:   dnsPortIPv6,
 						CaptureAll: true,
 					},
 					Outbound: config.TrafficFlow{
@@ -290,7 +305,7 @@ var _ = Describe("Outbound IPv6 DNS/TCP traffic to port 53", func() {
 				IPv6:          true,
 				RuntimeStdout: ioutil.Discard,
 			}
-			serverAddress := fmt.Sprintf("%s:%d", consts.LocalhostIPv6, dnsPort)
+			serverAddress := fmt.Sprintf("%s:%d", consts.LocalhostIPv6, tproxyConfig.Redirect.DNS.GetPort(true))
 
 			readyC, errC := tcp.UnsafeStartTCPServer(
 				ns,
@@ -319,13 +334,14 @@ var _ = Describe("Outbound IPv6 DNS/TCP traffic to port 53", func() {
 			lockedPorts := []uint16{consts.DNSPort}
 
 			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
-				// We are drawing two ports instead of one as the first one will be used
+				// We are drawing three ports instead of one as the first one will be used
 				// to expose TCP server inside the namespace, which will be pretending
 				// a DNS server which should intercept all DNS traffic on port TCP#53,
-				// and the second one will be set as an outbound redirection port,
+				// the second one will be set as an outbound redirection port,
 				// which wound intercept the packet if no DNS redirection would be set,
-				// and we don't want them to be the same
-				randomPorts := socket.GenerateRandomPortsSlice(2, lockedPorts...)
+				// and we don't want them to be the same, and the third one will
+				// be used as an IPv6 specific DNS port
+				randomPorts := socket.GenerateRandomPortsSlice(3, lockedPorts...)
 				// This gives us more entropy as all generated ports will be
 				// different from each other
 				lockedPorts = append(lockedPorts, randomPorts...)
@@ -334,7 +350,16 @@ var _ = Describe("Outbound IPv6 DNS/TCP traffic to port 53", func() {
 					randomPorts[0],
 					consts.DNSPort,
 				)
-				entry := Entry(EntryDescription(desc), randomPorts[0], randomPorts[1])
+				entry := Entry(EntryDescription(desc), randomPorts[0], uint16(0), randomPorts[1])
+				entries = append(entries, entry)
+
+				desc = fmt.Sprintf(
+					"to ipv6 specific port %d (ipv4 port: %d), from port %d",
+					randomPorts[2],
+					randomPorts[0],
+					consts.DNSPort,
+				)
+				entry = Entry(EntryDescription(desc), randomPorts[0], randomPorts[2], randomPorts[1])
 				entries = append(entries, entry)
 			}
 
@@ -457,6 +482,66 @@ var _ = Describe("Outbound IPv4 DNS/UDP conntrack zone splitting", func() {
 	)
 })
 
+var _ = Describe("DNS/UDP conntrack zone splitting with zones used by other rules", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should fail before applying any rules",
+		func(upstreamZone, proxyZone uint16, usedZone string) {
+			// given
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					DNS: config.DNS{
+						Enabled:               true,
+						ConntrackZoneSplit:    true,
+						CaptureAll:            true,
+						UpstreamConntrackZone: upstreamZone,
+						ProxyConntrackZone:    proxyZone,
+					},
+					Outbound: config.TrafficFlow{
+						Enabled: true,
+					},
+					Inbound: config.TrafficFlow{
+						Enabled: true,
+					},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+
+			Eventually(ns.UnsafeExec(func() {
+				Expect(exec.Command(
+					"iptables", "-t", "raw", "-A", "PREROUTING",
+					"-p", "udp", "-j", "CT", "--zone", usedZone,
+				).Run()).To(Succeed())
+			})).Should(BeClosed())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(MatchError(
+					ContainSubstring("conntrack zone %s is already used", usedZone),
+				))
+			})).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				Expect(exec.Command("iptables-save", "-t", "nat").Output()).
+					NotTo(ContainSubstring("MESH_OUTBOUND"))
+			})).Should(BeClosed())
+		},
+		Entry("default upstream zone", uint16(0), uint16(0), "1"),
+		Entry("custom proxy zone", uint16(11), uint16(12), "12"),
+	)
+})
+
 var _ = Describe("Outbound IPv6 DNS/UDP conntrack zone splitting", func() {
 	var err error
 	var ns *netns.NetNS
@@ -649,6 +734,274 @@ var _ = Describe("Outbound IPv4 DNS/UDP traffic to port 53 only for addresses in
 	)
 })
 
+var _ = Describe("Outbound IPv4 DNS/UDP traffic to port 53 only for explicitly configured addresses", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should be redirected to provided port",
+		func(randomPort uint16) {
+			// given
+			explicitDnsServer := &net.UDPAddr{IP: net.ParseIP("10.0.0.77"), Port: int(consts.DNSPort)}
+			resolvConfDnsServer := &net.UDPAddr{IP: net.ParseIP("10.0.0.53"), Port: int(consts.DNSPort)}
+			stubResolver := &net.UDPAddr{IP: net.ParseIP("127.0.0.53"), Port: int(consts.DNSPort)}
+			randomAddressDnsRequest := udp.GenRandomAddressIPv4(consts.DNSPort)
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					DNS: config.DNS{
+						Enabled:              true,
+						CaptureAll:           false,
+						Port:                 randomPort,
+						ResolvConfigPath:     "testdata/resolv4.conf",
+						Servers:              []string{explicitDnsServer.IP.String()},
+						ExcludeStubResolvers: true,
+					},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+			serverAddress := fmt.Sprintf("%s:%d", consts.LocalhostIPv4, randomPort)
+
+			readyC, errC := udp.UnsafeStartUDPServer(ns, serverAddress, udp.ReplyWithReceivedMsg)
+			Consistently(errC).ShouldNot(Receive())
+			Eventually(readyC).Should(BeClosed())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// and
+			for _, dnsServer := range []*net.UDPAddr{explicitDnsServer, resolvConfDnsServer} {
+				Eventually(ns.UnsafeExec(func() {
+					Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(dnsServer, dnsServer)).
+						To(Equal(dnsServer.String()))
+				})).Should(BeClosed())
+			}
+
+			// and do not redirect dns requests to the stub resolver and other addresses
+			for _, address := range []*net.UDPAddr{stubResolver, randomAddressDnsRequest} {
+				Eventually(ns.UnsafeExec(func() {
+					Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(address, address))
+				})).ShouldNot(BeClosed())
+			}
+
+			// then
+			Consistently(errC).ShouldNot(Receive())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			lockedPorts := []uint16{consts.DNSPort}
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				randomPorts := socket.GenerateRandomPortsSlice(1, lockedPorts...)
+				// This gives us more entropy as all generated ports will be
+				// different from each other
+				lockedPorts = append(lockedPorts, randomPorts...)
+				desc := fmt.Sprintf("to port %%d, from port %d", consts.DNSPort)
+				entry := Entry(EntryDescription(desc), randomPorts[0])
+				entries = append(entries, entry)
+			}
+
+			return entries
+		}(),
+	)
+})
+
+var _ = Describe("Outbound IPv4 DNS/UDP traffic to port 53 only for addresses in watched configuration", func() {
+	var err error
+	var ns *netns.NetNS
+	var resolvConfDir string
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+
+		resolvConfDir, err = ioutil.TempDir("", "resolv-conf")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+		Expect(os.RemoveAll(resolvConfDir)).To(Succeed())
+	})
+
+	DescribeTable("should be redirected to provided port after resolv.conf changes",
+		func(randomPort uint16) {
+			// given
+			oldDnsServer := &net.UDPAddr{IP: net.ParseIP("10.0.0.53"), Port: int(consts.DNSPort)}
+			newDnsServer := &net.UDPAddr{IP: net.ParseIP("10.0.0.63"), Port: int(consts.DNSPort)}
+			resolvConfPath := filepath.Join(resolvConfDir, "resolv.conf")
+			writeResolvConf := func(dnsServer *net.UDPAddr) {
+				// the file is replaced the same way as resolvconf does it
+				tmpPath := resolvConfPath + ".tmp"
+				content := fmt.Sprintf("nameserver %s\n", dnsServer.IP)
+				Expect(ioutil.WriteFile(tmpPath, []byte(content), 0o644)).To(Succeed())
+				Expect(os.Rename(tmpPath, resolvConfPath)).To(Succeed())
+			}
+			writeResolvConf(oldDnsServer)
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					DNS: config.DNS{
+						Enabled:          true,
+						CaptureAll:       false,
+						Port:             randomPort,
+						ResolvConfigPath: resolvConfPath,
+					},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+			serverAddress := fmt.Sprintf("%s:%d", consts.LocalhostIPv4, randomPort)
+			stopC := make(chan struct{})
+
+			readyC, errC := udp.UnsafeStartUDPServer(ns, serverAddress, udp.ReplyWithReceivedMsg)
+			Consistently(errC).ShouldNot(Receive())
+			Eventually(readyC).Should(BeClosed())
+
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			watcherC := ns.UnsafeExec(func() {
+				Expect(builder.WatchResolvConf(tproxyConfig, stopC)).To(Succeed())
+			})
+
+			Eventually(ns.UnsafeExec(func() {
+				Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(oldDnsServer, oldDnsServer)).
+					To(Equal(oldDnsServer.String()))
+			})).Should(BeClosed())
+
+			// when
+			writeResolvConf(newDnsServer)
+
+			// then
+			Eventually(func() string {
+				var rules []byte
+
+				<-ns.UnsafeExec(func() {
+					rules, _ = exec.Command("iptables-save", "-t", "nat").Output()
+				})
+
+				return string(rules)
+			}, 5*time.Second).Should(ContainSubstring(newDnsServer.IP.String()))
+
+			Eventually(ns.UnsafeExec(func() {
+				Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(newDnsServer, newDnsServer)).
+					To(Equal(newDnsServer.String()))
+			})).Should(BeClosed())
+
+			// and do not redirect dns requests to the old server anymore
+			Eventually(ns.UnsafeExec(func() {
+				Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(oldDnsServer, oldDnsServer))
+			})).ShouldNot(BeClosed())
+
+			close(stopC)
+			Eventually(watcherC).Should(BeClosed())
+			Consistently(errC).ShouldNot(Receive())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			lockedPorts := []uint16{consts.DNSPort}
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				randomPorts := socket.GenerateRandomPortsSlice(1, lockedPorts...)
+				// This gives us more entropy as all generated ports will be
+				// different from each other
+				lockedPorts = append(lockedPorts, randomPorts...)
+				desc := fmt.Sprintf("to port %%d, from port %d", consts.DNSPort)
+				entry := Entry(EntryDescription(desc), randomPorts[0])
+				entries = append(entries, entry)
+			}
+
+			return entries
+		}(),
+	)
+})
+
+var _ = Describe("Outbound IPv4 DNS/UDP traffic to port 53 only for addresses in configuration with systemd-resolved", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should be redirected to provided port",
+		func(randomPort uint16) {
+			// given
+			stubResolver := getDnsServers("testdata/resolv4-systemd-resolved-stub.conf", 1, false)
+			upstreams := getDnsServers("testdata/resolv4-systemd-resolved.conf", 1, false)
+			randomAddressDnsRequest := udp.GenRandomAddressIPv4(consts.DNSPort)
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					DNS: config.DNS{
+						Enabled:                   true,
+						CaptureAll:                false,
+						Port:                      randomPort,
+						ResolvConfigPath:          "testdata/resolv4-systemd-resolved-stub.conf",
+						SystemdResolvedConfigPath: "testdata/resolv4-systemd-resolved.conf",
+					},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+			serverAddress := fmt.Sprintf("%s:%d", consts.LocalhostIPv4, randomPort)
+
+			readyC, errC := udp.UnsafeStartUDPServer(ns, serverAddress, udp.ReplyWithReceivedMsg)
+			Consistently(errC).ShouldNot(Receive())
+			Eventually(readyC).Should(BeClosed())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// and both the stub resolver and real upstreams are captured
+			for _, dnsServer := range append(stubResolver, upstreams...) {
+				Eventually(ns.UnsafeExec(func() {
+					Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(dnsServer, dnsServer)).
+						To(Equal(dnsServer.String()))
+				})).Should(BeClosed())
+			}
+
+			// and do not redirect any other dns request
+			Eventually(ns.UnsafeExec(func() {
+				Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(randomAddressDnsRequest, randomAddressDnsRequest))
+			})).ShouldNot(BeClosed())
+
+			// then
+			Consistently(errC).ShouldNot(Receive())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			lockedPorts := []uint16{consts.DNSPort}
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				randomPorts := socket.GenerateRandomPortsSlice(1, lockedPorts...)
+				// This gives us more entropy as all generated ports will be
+				// different from each other
+				lockedPorts = append(lockedPorts, randomPorts...)
+				desc := fmt.Sprintf("to port %%d, from port %d", consts.DNSPort)
+				entry := Entry(EntryDescription(desc), randomPorts[0])
+				entries = append(entries, entry)
+			}
+
+			return entries
+		}(),
+	)
+})
+
 var _ = Describe("Outbound IPv6 DNS/UDP traffic to port 53 only for addresses in configuration ", func() {
 	var err error
 	var ns *netns.NetNS
@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os/exec"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/kumahq/kuma-net/iptables"
 	"github.com/kumahq/kuma-net/iptables/builder"
 	"github.com/kumahq/kuma-net/iptables/consts"
 	"github.com/kumahq/kuma-net/test/blackbox_tests"
@@ -15,6 +19,7 @@ import (
 	"github.com/kumahq/kuma-net/test/framework/netns"
 	"github.com/kumahq/kuma-net/test/framework/socket"
 	"github.com/kumahq/kuma-net/test/framework/tcp"
+	"github.com/kumahq/kuma-net/test/framework/udp"
 	"github.com/kumahq/kuma-net/transparent-proxy/config"
 )
 
@@ -175,6 +180,87 @@ var _ = Describe("Outbound IPv6 TCP traffic to any address:port", func() {
 	)
 })
 
+var _ = Describe("Outbound IPv6 TCP traffic to any address:port with separate IPv6 outbound port", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().WithIPv6(true).Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should be redirected to outbound IPv6 port",
+		func(serverPort, ipv4ServerPort, randomPort uint16) {
+			// given
+			address := fmt.Sprintf(":%d", serverPort)
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					Outbound: config.TrafficFlow{
+						Enabled:  true,
+						Port:     ipv4ServerPort,
+						PortIPv6: serverPort,
+					},
+					Inbound: config.TrafficFlow{
+						Enabled: true,
+					},
+				},
+				IPv6:          true,
+				RuntimeStdout: ioutil.Discard,
+			}
+
+			tcpReadyC, tcpErrC := tcp.UnsafeStartTCPServer(
+				ns,
+				address,
+				tcp.ReplyWithOriginalDstIPv6,
+				tcp.CloseConn,
+			)
+			Eventually(tcpReadyC).Should(BeClosed())
+			Consistently(tcpErrC).ShouldNot(Receive())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				address := ip.GenRandomIPv6()
+
+				Expect(tcp.DialIPWithPortAndGetReply(address, randomPort)).
+					To(Equal(fmt.Sprintf("[%s]:%d", address, randomPort)))
+			})).Should(BeClosed())
+
+			// then
+			Eventually(tcpErrC).Should(BeClosed())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			var lockedPorts []uint16
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				randomPorts := socket.GenerateRandomPortsSlice(3, lockedPorts...)
+				// This gives us more entropy as all generated ports will be
+				// different from each other
+				lockedPorts = append(lockedPorts, randomPorts...)
+				desc := fmt.Sprintf("to port %%d (IPv4 port: %%d), from port %%d")
+				entry := Entry(
+					EntryDescription(desc),
+					randomPorts[0],
+					randomPorts[1],
+					randomPorts[2],
+				)
+				entries = append(entries, entry)
+			}
+
+			return entries
+		}(),
+	)
+})
+
 var _ = Describe("Outbound IPv4 TCP traffic to any address:port except excluded ones", func() {
 	var err error
 	var ns *netns.NetNS
@@ -271,6 +357,418 @@ var _ = Describe("Outbound IPv4 TCP traffic to any address:port except excluded
 	)
 })
 
+var _ = Describe("Outbound IPv4 TCP traffic to any address:port except excluded port ranges", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should be redirected to outbound port",
+		func(serverPort, randomPort uint16, excludedRange config.PortRange, excludedPorts []uint16) {
+			// given
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					Outbound: config.TrafficFlow{
+						Enabled:           true,
+						Port:              serverPort,
+						ExcludePorts:      excludedPorts,
+						ExcludePortRanges: []config.PortRange{excludedRange},
+					},
+					Inbound: config.TrafficFlow{
+						Enabled: true,
+					},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+
+			tcpReadyC, tcpErrC := tcp.UnsafeStartTCPServer(
+				ns,
+				fmt.Sprintf(":%d", serverPort),
+				tcp.ReplyWithOriginalDstIPv4,
+				tcp.CloseConn,
+			)
+			Eventually(tcpReadyC).Should(BeClosed())
+			Consistently(tcpErrC).ShouldNot(Receive())
+
+			// the last port of the range and the last of excluded ports, which
+			// are matched by the last multiport rule
+			for _, excludedPort := range []uint16{excludedRange.To, excludedPorts[len(excludedPorts)-1]} {
+				excludedReadyC, excludedErrC := tcp.UnsafeStartTCPServer(
+					ns,
+					fmt.Sprintf(":%d", excludedPort),
+					tcp.ReplyWith("excluded"),
+					tcp.CloseConn,
+				)
+				Eventually(excludedReadyC).Should(BeClosed())
+				Consistently(excludedErrC).ShouldNot(Receive())
+			}
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				address := ip.GenRandomIPv4()
+
+				Expect(tcp.DialIPWithPortAndGetReply(address, randomPort)).
+					To(Equal(fmt.Sprintf("%s:%d", address, randomPort)))
+			})).Should(BeClosed())
+
+			// then
+			for _, excludedPort := range []uint16{excludedRange.To, excludedPorts[len(excludedPorts)-1]} {
+				Eventually(ns.UnsafeExec(func() {
+					Expect(tcp.DialIPWithPortAndGetReply(net.IPv4zero, excludedPort)).
+						To(Equal("excluded"))
+				})).Should(BeClosed())
+			}
+
+			// then
+			Eventually(tcpErrC).Should(BeClosed())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			var lockedPorts []uint16
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				var excludedRange config.PortRange
+				for excludedRange.From == 0 || excludedRange.From > 65000 {
+					port := socket.GenerateRandomPortsSlice(1, lockedPorts...)[0]
+					excludedRange = config.PortRange{From: port, To: port + 10}
+				}
+
+				for port := excludedRange.From; port <= excludedRange.To; port++ {
+					lockedPorts = append(lockedPorts, port)
+				}
+
+				// more excluded ports than fits in a single multiport match
+				excludedPorts := socket.GenerateRandomPortsSlice(20, lockedPorts...)
+				lockedPorts = append(lockedPorts, excludedPorts...)
+
+				randomPorts := socket.GenerateRandomPortsSlice(2, lockedPorts...)
+				// This gives us more entropy as all generated ports will be
+				// different from each other
+				lockedPorts = append(lockedPorts, randomPorts...)
+				desc := fmt.Sprintf("to port %%d, from port %%d (excluded: %%s and %%v)")
+				entry := Entry(
+					EntryDescription(desc),
+					randomPorts[0],
+					randomPorts[1],
+					excludedRange,
+					excludedPorts,
+				)
+				entries = append(entries, entry)
+			}
+
+			return entries
+		}(),
+	)
+})
+
+var _ = Describe("Outbound IPv4 TCP traffic to any address:port except excluded CIDRs", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should be redirected to outbound port",
+		func(serverPort, randomPort uint16, ipSets bool) {
+			// given
+			excludedAddress := net.ParseIP("203.0.113.10")
+			excludedCIDRs := []string{"203.0.113.0/24"}
+			// a lot of other excluded addresses (100.64.0.0 - 100.64.7.255)
+			for i := 0; i < 2048; i++ {
+				excludedCIDRs = append(excludedCIDRs, fmt.Sprintf("100.64.%d.%d", i/256, i%256))
+			}
+
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					Outbound: config.TrafficFlow{
+						Enabled:      true,
+						Port:         serverPort,
+						ExcludeCIDRs: excludedCIDRs,
+					},
+					Inbound: config.TrafficFlow{
+						Enabled: true,
+					},
+					IPSets: config.IPSets{
+						Enabled: ipSets,
+					},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+
+			// the server is accepting only one connection, so if the connection
+			// to the excluded address would be redirected, the next one would fail
+			tcpReadyC, tcpErrC := tcp.UnsafeStartTCPServer(
+				ns,
+				fmt.Sprintf(":%d", serverPort),
+				tcp.ReplyWithOriginalDstIPv4,
+				tcp.CloseConn,
+			)
+			Eventually(tcpReadyC).Should(BeClosed())
+			Consistently(tcpErrC).ShouldNot(Receive())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				address := net.JoinHostPort(excludedAddress.String(), fmt.Sprint(randomPort))
+
+				if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+					conn.Close()
+				}
+			}), 2*time.Second).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				address := ip.GenRandomIPv4()
+
+				Expect(tcp.DialIPWithPortAndGetReply(address, randomPort)).
+					To(Equal(fmt.Sprintf("%s:%d", address, randomPort)))
+			})).Should(BeClosed())
+
+			// then
+			Eventually(tcpErrC).Should(BeClosed())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(iptables.Uninstall(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				Expect(exec.Command("ipset", "list", "-name").Output()).
+					NotTo(ContainSubstring("MESH_OUTBOUND"))
+			})).Should(BeClosed())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			var lockedPorts []uint16
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				for _, ipSets := range []bool{false, true} {
+					randomPorts := socket.GenerateRandomPortsSlice(2, lockedPorts...)
+					// This gives us more entropy as all generated ports will be
+					// different from each other
+					lockedPorts = append(lockedPorts, randomPorts...)
+					desc := fmt.Sprintf("to port %%d, from port %%d (ipsets: %%t)")
+					entry := Entry(
+						EntryDescription(desc),
+						randomPorts[0],
+						randomPorts[1],
+						ipSets,
+					)
+					entries = append(entries, entry)
+				}
+			}
+
+			return entries
+		}(),
+	)
+})
+
+var _ = Describe("Outbound IPv4 TCP traffic via excluded interfaces", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should not be redirected to outbound port",
+		func(serverPort, randomPort uint16, wildcard bool) {
+			// given
+			excludedInterface := ns.Veth().PeerName()
+			if wildcard {
+				excludedInterface = strings.TrimRight(excludedInterface, "-0123456789") + "+"
+			}
+
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					Outbound: config.TrafficFlow{
+						Enabled: true,
+						Port:    serverPort,
+					},
+					Inbound: config.TrafficFlow{
+						Enabled: true,
+					},
+					ExcludeInterfaces: []string{excludedInterface},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+
+			tcpReadyC, tcpErrC := tcp.UnsafeStartTCPServer(
+				ns,
+				fmt.Sprintf(":%d", serverPort),
+				tcp.ReplyWithOriginalDstIPv4,
+				tcp.CloseConn,
+			)
+			Eventually(tcpReadyC).Should(BeClosed())
+			Consistently(tcpErrC).ShouldNot(Receive())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				address := net.JoinHostPort(ip.GenRandomIPv4().String(), fmt.Sprint(randomPort))
+
+				if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+					conn.Close()
+				}
+			}), 2*time.Second).Should(BeClosed())
+
+			// then the server accepting only one connection is still waiting for it
+			Consistently(tcpErrC).ShouldNot(BeClosed())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			var lockedPorts []uint16
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				for _, wildcard := range []bool{false, true} {
+					randomPorts := socket.GenerateRandomPortsSlice(2, lockedPorts...)
+					// This gives us more entropy as all generated ports will be
+					// different from each other
+					lockedPorts = append(lockedPorts, randomPorts...)
+					desc := fmt.Sprintf("to port %%d, from port %%d (wildcard: %%t)")
+					entry := Entry(
+						EntryDescription(desc),
+						randomPorts[0],
+						randomPorts[1],
+						wildcard,
+					)
+					entries = append(entries, entry)
+				}
+			}
+
+			return entries
+		}(),
+	)
+})
+
+var _ = Describe("Outbound IPv4 traffic with egress lockdown", func() {
+	var err error
+	var ns *netns.NetNS
+
+	BeforeEach(func() {
+		ns, err = netns.NewNetNSBuilder().Build()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(ns.Cleanup()).To(Succeed())
+	})
+
+	DescribeTable("should redirect TCP traffic and block UDP traffic bypassing the proxy",
+		func(serverPort, randomPort uint16, target config.EgressLockdownTarget) {
+			// given
+			tproxyConfig := config.Config{
+				Redirect: config.Redirect{
+					Outbound: config.TrafficFlow{
+						Enabled: true,
+						Port:    serverPort,
+					},
+					Inbound: config.TrafficFlow{
+						Enabled: true,
+					},
+					EgressLockdown: config.EgressLockdown{
+						Enabled: true,
+						Target:  target,
+					},
+				},
+				RuntimeStdout: ioutil.Discard,
+			}
+
+			tcpReadyC, tcpErrC := tcp.UnsafeStartTCPServer(
+				ns,
+				fmt.Sprintf(":%d", serverPort),
+				tcp.ReplyWithOriginalDstIPv4,
+				tcp.CloseConn,
+			)
+			Eventually(tcpReadyC).Should(BeClosed())
+			Consistently(tcpErrC).ShouldNot(Receive())
+
+			// when
+			Eventually(ns.UnsafeExec(func() {
+				Expect(builder.RestoreIPTables(tproxyConfig)).Error().To(Succeed())
+			})).Should(BeClosed())
+
+			// then
+			Eventually(ns.UnsafeExec(func() {
+				address := ip.GenRandomIPv4()
+
+				Expect(tcp.DialIPWithPortAndGetReply(address, randomPort)).
+					To(Equal(fmt.Sprintf("%s:%d", address, randomPort)))
+			})).Should(BeClosed())
+
+			// then packets rejected or dropped in the OUTPUT chain are failing
+			// the send operation
+			Eventually(ns.UnsafeExec(func() {
+				address := udp.GenRandomAddressIPv4(randomPort)
+
+				Expect(udp.DialUDPAddrWithHelloMsgAndGetReply(address, address)).
+					Error().To(HaveOccurred())
+			})).Should(BeClosed())
+
+			// then
+			Eventually(tcpErrC).Should(BeClosed())
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			var lockedPorts []uint16
+
+			for i := 0; i < blackbox_tests.TestCasesAmount; i++ {
+				for _, target := range []config.EgressLockdownTarget{
+					config.EgressLockdownReject,
+					config.EgressLockdownDrop,
+				} {
+					randomPorts := socket.GenerateRandomPortsSlice(2, lockedPorts...)
+					// This gives us more entropy as all generated ports will be
+					// different from each other
+					lockedPorts = append(lockedPorts, randomPorts...)
+					desc := fmt.Sprintf("to port %%d, from port %%d (target: %%s)")
+					entry := Entry(
+						EntryDescription(desc),
+						randomPorts[0],
+						randomPorts[1],
+						target,
+					)
+					entries = append(entries, entry)
+				}
+			}
+
+			return entries
+		}(),
+	)
+})
+
 var _ = Describe("Outbound IPv6 TCP traffic to any address:port except excluded ones", func() {
 	var err error
 	var ns *netns.NetNS
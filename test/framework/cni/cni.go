@@ -0,0 +1,116 @@
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kumahq/kuma-net/cni"
+	"github.com/kumahq/kuma-net/test/framework/netns"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+const (
+	DefaultContainerID = "kuma-net-test"
+	DefaultIfName      = "eth0"
+)
+
+// Invocation describes the invocation of the plugin by the container runtime
+type Invocation struct {
+	Command     string
+	ContainerID string
+	NetNS       string
+	IfName      string
+	// Args are additional arguments (CNI_ARGS), i.e.
+	// "K8S_POD_NAMESPACE=default;K8S_POD_NAME=demo-app"
+	Args string
+	Conf []byte
+}
+
+// Output is the output of the plugin
+type Output struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// Invoke invokes the plugin the same way container runtimes do (parameters
+// are passed in CNI_* environment variables, and the network configuration
+// to stdin). Errors reported by the plugin are returned as *cni.Error
+func Invoke(invocation Invocation) (Output, error) {
+	env := map[string]string{
+		"CNI_COMMAND":     invocation.Command,
+		"CNI_CONTAINERID": invocation.ContainerID,
+		"CNI_NETNS":       invocation.NetNS,
+		"CNI_IFNAME":      invocation.IfName,
+		"CNI_ARGS":        invocation.Args,
+		"CNI_PATH":        "/opt/cni/bin",
+	}
+
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	exitCode := cni.Main(lookupEnv, bytes.NewReader(invocation.Conf), stdout, stderr)
+
+	output := Output{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+
+	if exitCode != 0 {
+		cniErr := &cni.Error{}
+		if err := json.Unmarshal(output.Stdout, cniErr); err != nil {
+			return output, fmt.Errorf("plugin failed with exit code %d and invalid error %q: %s",
+				exitCode, output.Stdout, err)
+		}
+
+		return output, cniErr
+	}
+
+	return output, nil
+}
+
+// NewConf returns the network configuration of the plugin chained after
+// the plugin, which assigned provided addresses (in CIDR notation)
+// to the interface in the network namespace with provided path
+func NewConf(cfg config.Config, netNSPath string, addresses ...string) ([]byte, error) {
+	var ips []map[string]interface{}
+	for _, address := range addresses {
+		ips = append(ips, map[string]interface{}{"interface": 0, "address": address})
+	}
+
+	conf := map[string]interface{}{
+		"cniVersion": "1.0.0",
+		"name":       "kuma-net-test",
+		"type":       "kuma-net-cni",
+		"kumaNet":    cfg,
+		"prevResult": map[string]interface{}{
+			"cniVersion": "1.0.0",
+			"interfaces": []map[string]interface{}{{
+				"name":    DefaultIfName,
+				"sandbox": netNSPath,
+			}},
+			"ips": ips,
+		},
+	}
+
+	return json.Marshal(conf)
+}
+
+// InvokeInNetNS invokes the plugin with provided command and configuration
+// of the transparent proxy for the pod in provided network namespace
+func InvokeInNetNS(ns *netns.NetNS, command string, cfg config.Config) (Output, error) {
+	conf, err := NewConf(cfg, ns.Path(), ns.Veth().PeerAddressCIDR().String())
+	if err != nil {
+		return Output{}, fmt.Errorf("cannot build network configuration: %s", err)
+	}
+
+	return Invoke(Invocation{
+		Command:     command,
+		ContainerID: DefaultContainerID,
+		NetNS:       ns.Path(),
+		IfName:      DefaultIfName,
+		Conf:        conf,
+	})
+}
@@ -2,6 +2,7 @@ package netns
 
 import (
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -30,6 +31,13 @@ func (ns *NetNS) Name() string {
 	return ns.name
 }
 
+// Path returns the path to the named network namespace (created
+// by netns.NewNamed in /run/netns), as passed by container runtimes
+// to CNI plugins
+func (ns *NetNS) Path() string {
+	return filepath.Join("/run/netns", ns.name)
+}
+
 func (ns *NetNS) Veth() *Veth {
 	return ns.veth
 }
@@ -3,14 +3,66 @@ package config
 import (
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 )
 
 type Owner struct {
 	UID string
 }
 
+// PortRange is an inclusive range of ports (single port is represented
+// by the range with equal From and To)
+type PortRange struct {
+	From uint16
+	To   uint16
+}
+
+// ParsePortRange parses port ranges in formats "8080", "30000-32767"
+// and "30000:32767"
+func ParsePortRange(value string) (PortRange, error) {
+	from, to := value, value
+	if i := strings.IndexAny(value, "-:"); i != -1 {
+		from, to = value[:i], value[i+1:]
+	}
+
+	var ports []uint16
+	for _, bound := range []string{from, to} {
+		port, err := strconv.ParseUint(bound, 10, 16)
+		if err != nil {
+			return PortRange{}, fmt.Errorf("invalid port range %q: %s", value, err)
+		}
+
+		ports = append(ports, uint16(port))
+	}
+
+	result := PortRange{From: ports[0], To: ports[1]}
+	if err := result.Validate(); err != nil {
+		return PortRange{}, err
+	}
+
+	return result, nil
+}
+
+func (r PortRange) Validate() error {
+	if r.From == 0 || r.From > r.To {
+		return fmt.Errorf("invalid port range %s", r)
+	}
+
+	return nil
+}
+
+func (r PortRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(int(r.From))
+	}
+
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
 // TrafficFlow is a struct for Inbound/Outbound configuration
 type TrafficFlow struct {
 	Enabled       bool
@@ -20,14 +72,222 @@ type TrafficFlow struct {
 	RedirectChain Chain
 	ExcludePorts  []uint16
 	IncludePorts  []uint16
+	// ExcludePortRanges and IncludePortRanges are applied together with
+	// ExcludePorts and IncludePorts respectively
+	ExcludePortRanges []PortRange
+	IncludePortRanges []PortRange
+	// ExcludeCIDRs are IPv4 and IPv6 addresses or CIDRs, traffic of which
+	// won't be redirected (destination addresses for outbound, and source
+	// addresses for inbound traffic)
+	ExcludeCIDRs []string
+}
+
+func portsToRanges(ports []uint16, ranges []PortRange) []PortRange {
+	var result []PortRange
+
+	for _, port := range ports {
+		result = append(result, PortRange{From: port, To: port})
+	}
+
+	return append(result, ranges...)
+}
+
+// GetExcludePortRanges returns excluded ports and port ranges
+func (t TrafficFlow) GetExcludePortRanges() []PortRange {
+	return portsToRanges(t.ExcludePorts, t.ExcludePortRanges)
+}
+
+// GetIncludePortRanges returns included ports and port ranges
+func (t TrafficFlow) GetIncludePortRanges() []PortRange {
+	return portsToRanges(t.IncludePorts, t.IncludePortRanges)
+}
+
+// GetExcludeCIDRs returns excluded CIDRs of provided ip family. Addresses
+// without the mask are returned as single host CIDRs
+func (t TrafficFlow) GetExcludeCIDRs(ipv6 bool) ([]string, error) {
+	var result []string
+
+	for _, value := range t.ExcludeCIDRs {
+		cidr := value
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() == nil {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded CIDR %q: %s", value, err)
+		}
+
+		if (ip.To4() == nil) == ipv6 {
+			result = append(result, ipNet.String())
+		}
+	}
+
+	return result, nil
+}
+
+// ValidatePortRanges checks if all included and excluded port ranges are valid
+func (t TrafficFlow) ValidatePortRanges() error {
+	for _, r := range append(t.GetExcludePortRanges(), t.GetIncludePortRanges()...) {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetPort returns the redirect port for provided ip family (Port is used
+// for IPv6 as well, when PortIPv6 is not set)
+func (t TrafficFlow) GetPort(ipv6 bool) uint16 {
+	if ipv6 && t.PortIPv6 != 0 {
+		return t.PortIPv6
+	}
+
+	return t.Port
 }
 
 type DNS struct {
 	Enabled            bool
 	CaptureAll         bool
 	Port               uint16
+	PortIPv6           uint16
 	ConntrackZoneSplit bool
 	ResolvConfigPath   string
+	// WatchResolvConf when set makes the installation keep watching
+	// ResolvConfigPath (and SystemdResolvedConfigPath) and update rules
+	// capturing DNS traffic to the selected servers when they change. It's
+	// used only when CaptureAll is not set
+	WatchResolvConf bool
+	// Servers are explicitly configured upstream DNS servers (IPv4 or IPv6
+	// addresses) which will be captured together with the ones from
+	// ResolvConfigPath, when CaptureAll is not set
+	Servers []string
+	// ExcludeLinkLocalServers when set will not capture DNS servers with
+	// link-local addresses (i.e. 169.254.169.253 on AWS)
+	ExcludeLinkLocalServers bool
+	// ExcludeStubResolvers when set will not capture DNS servers with
+	// loopback addresses, used by local stub resolvers (i.e. 127.0.0.53
+	// of systemd-resolved)
+	ExcludeStubResolvers bool
+	// SystemdResolvedConfigPath is the path to the resolv.conf file maintained
+	// by systemd-resolved, which contains real upstream DNS servers. It's read
+	// when ResolvConfigPath lists the systemd-resolved stub resolver (127.0.0.53)
+	SystemdResolvedConfigPath string
+	// SystemdResolvedUser is the user systemd-resolved is running as (i.e.
+	// systemd-resolve). DNS traffic of this user won't be captured, as
+	// otherwise queries forwarded by systemd-resolved to the upstream servers
+	// would loop back to the proxy. It's not set by default, so generated
+	// rules don't depend on users present on the machine building them,
+	// and building rules fails when the configured user doesn't exist
+	SystemdResolvedUser string
+	// UpstreamConntrackZone is the conntrack zone of the DNS traffic between
+	// the DNS proxy (or systemd-resolved) and upstream DNS servers, used when
+	// ConntrackZoneSplit is set
+	UpstreamConntrackZone uint16
+	// ProxyConntrackZone is the conntrack zone of the DNS traffic between
+	// applications and the DNS proxy, used when ConntrackZoneSplit is set
+	ProxyConntrackZone uint16
+	// RedirectChain (nat table) contains rules redirecting DNS traffic to
+	// the DNS proxy, when only traffic to selected servers is captured
+	RedirectChain Chain
+	// ConntrackOutputChain and ConntrackPreroutingChain (raw table) contain
+	// conntrack zone splitting rules for selected servers
+	ConntrackOutputChain     Chain
+	ConntrackPreroutingChain Chain
+}
+
+// SelectiveCaptureRequested returns true when capturing DNS traffic only
+// to selected servers was explicitly requested (by configured Servers,
+// or by excluding some of the servers from the capture)
+func (d DNS) SelectiveCaptureRequested() bool {
+	return len(d.Servers) > 0 || d.ExcludeLinkLocalServers || d.ExcludeStubResolvers
+}
+
+// GetPort returns the port of the DNS proxy for provided ip family (Port
+// is used for IPv6 as well, when PortIPv6 is not set)
+func (d DNS) GetPort(ipv6 bool) uint16 {
+	if ipv6 && d.PortIPv6 != 0 {
+		return d.PortIPv6
+	}
+
+	return d.Port
+}
+
+// ValidateConntrackZones checks if conntrack zones are in the allowed range
+// (zone 0 is the default zone of all the connections) and are distinct
+func (d DNS) ValidateConntrackZones() error {
+	if d.UpstreamConntrackZone == 0 || d.ProxyConntrackZone == 0 {
+		return fmt.Errorf("conntrack zones have to be in range 1-65535 "+
+			"(upstream zone: %d, proxy zone: %d)",
+			d.UpstreamConntrackZone, d.ProxyConntrackZone)
+	}
+
+	if d.UpstreamConntrackZone == d.ProxyConntrackZone {
+		return fmt.Errorf("upstream and proxy conntrack zones have to be "+
+			"different (both are: %d)", d.UpstreamConntrackZone)
+	}
+
+	return nil
+}
+
+// IPSets configures putting exclusion lists (CIDRs and ports) into ipsets,
+// so every list will be matched by a single rule instead of rule per entry
+type IPSets struct {
+	Enabled bool
+}
+
+// EgressLockdownTarget is the target of the outbound traffic, which is not
+// allowed in the egress lockdown mode
+type EgressLockdownTarget string
+
+const (
+	// EgressLockdownReject rejects the traffic, so applications will get
+	// an error immediately (connection reset for tcp, port unreachable
+	// for other protocols)
+	EgressLockdownReject EgressLockdownTarget = "REJECT"
+	// EgressLockdownDrop silently drops the traffic
+	EgressLockdownDrop EgressLockdownTarget = "DROP"
+)
+
+// EgressLockdown configures the filter table chain, which blocks outbound
+// traffic of applications which wasn't redirected to the proxy (i.e. UDP,
+// or traffic to not included ports), so only the proxy can reach the network
+// directly. Traffic through the loopback interface, replies in already
+// established connections, DNS queries and traffic explicitly excluded
+// from the redirection (excluded CIDRs, ports and interfaces) are allowed
+type EgressLockdown struct {
+	Enabled bool
+	// Target is EgressLockdownReject by default
+	Target EgressLockdownTarget
+	Chain  Chain
+}
+
+// Validate checks if the target is supported and the outbound traffic
+// is redirected, as otherwise all the tcp traffic would be blocked
+func (e EgressLockdown) Validate(outbound TrafficFlow) error {
+	if !e.Enabled {
+		return nil
+	}
+
+	switch e.Target {
+	case EgressLockdownReject, EgressLockdownDrop:
+	default:
+		return fmt.Errorf("unsupported egress lockdown target %q "+
+			"(supported targets: %s, %s)",
+			e.Target, EgressLockdownReject, EgressLockdownDrop)
+	}
+
+	if !outbound.Enabled {
+		return fmt.Errorf("egress lockdown requires outbound traffic " +
+			"redirection to be enabled")
+	}
+
+	return nil
 }
 
 type Redirect struct {
@@ -36,6 +296,75 @@ type Redirect struct {
 	Inbound    TrafficFlow
 	Outbound   TrafficFlow
 	DNS        DNS
+	IPSets     IPSets
+	// ExcludeInterfaces are names of output interfaces (i.e. wg0), outbound
+	// traffic (including DNS) via which won't be redirected. Names ending
+	// with "+" are matching all the interfaces beginning with the name
+	// (i.e. wg+). eBPF programs are redirecting connections before the output
+	// interface is known, so they cannot exclude interfaces and when it's set,
+	// eBPF backend won't be selected (see EbpfPolicy)
+	ExcludeInterfaces []string
+	// ExcludeInboundInterfaces are names of input interfaces, inbound traffic
+	// from which won't be redirected. Wildcards and eBPF backend are handled
+	// the same way as in ExcludeInterfaces
+	ExcludeInboundInterfaces []string
+	// EgressLockdown when enabled will block outbound traffic bypassing
+	// the proxy. It's not supported by eBPF backend
+	EgressLockdown EgressLockdown
+}
+
+// maxInterfaceNameLen is the maximal length of the interface name
+// (IFNAMSIZ without the terminating null byte)
+const maxInterfaceNameLen = 15
+
+func validateInterfaceName(name string) error {
+	trimmed := strings.TrimSuffix(name, "+")
+
+	switch {
+	case trimmed == "":
+		return fmt.Errorf("invalid interface name %q", name)
+	case len(name) > maxInterfaceNameLen:
+		return fmt.Errorf("interface name %q is longer than %d characters",
+			name, maxInterfaceNameLen)
+	case strings.ContainsAny(trimmed, "+/ \t\n") || strings.HasPrefix(name, "!"):
+		return fmt.Errorf("invalid interface name %q", name)
+	}
+
+	return nil
+}
+
+// ValidateExcludeInterfaces checks if names of excluded interfaces are valid
+// interface names (with optional "+" wildcard at the end)
+func (r Redirect) ValidateExcludeInterfaces() error {
+	for _, name := range append(r.ExcludeInterfaces, r.ExcludeInboundInterfaces...) {
+		if err := validateInterfaceName(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateRedirectPorts checks if inbound and outbound traffic of the same
+// ip family is not redirected to the same port, as then the proxy would be
+// unable to distinguish these
+func (r Redirect) ValidateRedirectPorts() error {
+	for _, ipv6 := range []bool{false, true} {
+		inboundPort := r.Inbound.GetPort(ipv6)
+		outboundPort := r.Outbound.GetPort(ipv6)
+
+		if r.Inbound.Enabled && r.Outbound.Enabled && inboundPort == outboundPort {
+			family := "IPv4"
+			if ipv6 {
+				family = "IPv6"
+			}
+
+			return fmt.Errorf("inbound and outbound %s traffic cannot be "+
+				"redirected to the same port (%d)", family, inboundPort)
+		}
+	}
+
+	return nil
 }
 
 type Chain struct {
@@ -46,20 +375,186 @@ func (c Chain) GetFullName(prefix string) string {
 	return prefix + c.Name
 }
 
+// EbpfPolicy decides which backend (eBPF or iptables) will be used to install
+// the transparent proxy
+type EbpfPolicy string
+
+const (
+	// EbpfPolicyRequired will use eBPF and fail if the kernel or the process
+	// doesn't support it
+	EbpfPolicyRequired EbpfPolicy = "required"
+	// EbpfPolicyPreferred will use eBPF when it's supported, and fall back
+	// to iptables otherwise
+	EbpfPolicyPreferred EbpfPolicy = "preferred"
+	// EbpfPolicyDisabled will always use iptables
+	EbpfPolicyDisabled EbpfPolicy = "disabled"
+)
+
 type Ebpf struct {
-	Enabled            bool
+	Enabled bool
+	// Policy when not set, will be EbpfPolicyRequired if Enabled is set
+	// and EbpfPolicyDisabled otherwise
+	Policy             EbpfPolicy
 	InstanceIP         string
 	BPFFSPath          string
 	ProgramsSourcePath string
 }
 
+// GetPolicy returns the configured Policy or the one implied by Enabled,
+// when Policy is not set
+func (e Ebpf) GetPolicy() EbpfPolicy {
+	if e.Policy != "" {
+		return e.Policy
+	}
+
+	if e.Enabled {
+		return EbpfPolicyRequired
+	}
+
+	return EbpfPolicyDisabled
+}
+
+// maxCommentPrefixLen is the maximal length of the comment prefix, which
+// leaves enough space for purposes of the rules, as comments are limited
+// to 255 characters
+const maxCommentPrefixLen = 200
+
+// Comment configures tagging of all the generated rules with the comment
+// match (i.e. "kuma/outbound/exclude-port"), so they can be distinguished
+// from other rules once applied (i.e. in iptables-save output)
+type Comment struct {
+	// Enabled when set will generate rules with comments. It's not set
+	// by default, so generated rules are the same as without tagging, and
+	// when rules are applied, comments are not added if the comment iptables
+	// extension is not available (see Config.ShouldComment)
+	Enabled bool
+	// Prefix is the marker placed before the purpose of the rule
+	Prefix string
+}
+
+// Validate checks if the prefix will fit into the comment together
+// with the purpose of the rule
+func (c Comment) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if len(c.Prefix) > maxCommentPrefixLen {
+		return fmt.Errorf("comment prefix %q is longer than %d characters",
+			c.Prefix, maxCommentPrefixLen)
+	}
+
+	if strings.ContainsAny(c.Prefix, "\n\r") {
+		return fmt.Errorf("comment prefix %q cannot contain new lines", c.Prefix)
+	}
+
+	return nil
+}
+
+// TraceTarget is the target of the rules logging packets in the tracing mode
+type TraceTarget string
+
+const (
+	// TraceTargetLog logs packets to the kernel log (prefixes are truncated,
+	// so they fit in 29 characters with the "#<id>" suffix, which is unique
+	// for every traced rule in the table)
+	TraceTargetLog TraceTarget = "LOG"
+	// TraceTargetNflog passes packets to the nfnetlink_log backend, from which
+	// they can be read i.e. by the trace.ListenNflog
+	TraceTargetNflog TraceTarget = "NFLOG"
+)
+
+// maxTracePrefixLen is the maximal length of the trace prefix, which leaves
+// enough space in the LOG target prefix for the decision
+const maxTracePrefixLen = 16
+
+// Trace configures the debugging mode in which every rule with the terminal
+// decision (RETURN, REDIRECT, CT zone, DROP or REJECT) is preceded by
+// the rate-limited rule logging matching packets, with the prefix in format
+// "<prefix>:<decision>:<purpose>" (purpose of the rule is the one placed
+// in its comment, so it's empty unless comments are enabled)
+type Trace struct {
+	Enabled bool
+	// Target is TraceTargetLog by default
+	Target TraceTarget
+	Prefix string
+	// NflogGroup is the nfnetlink_log group used by TraceTargetNflog
+	NflogGroup uint16
+	// Limit is the maximum average rate of logged packets per rule
+	// (i.e. "10/second")
+	Limit string
+	// LimitBurst is the maximum initial number of logged packets per rule
+	LimitBurst uint
+}
+
+// Validate checks if the target is supported and the prefix can be parsed
+// back from logged messages
+func (t Trace) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+
+	switch t.Target {
+	case TraceTargetLog, TraceTargetNflog:
+	default:
+		return fmt.Errorf("unsupported trace target %q (supported targets: %s, %s)",
+			t.Target, TraceTargetLog, TraceTargetNflog)
+	}
+
+	switch {
+	case t.Prefix == "":
+		return fmt.Errorf("trace prefix cannot be empty")
+	case len(t.Prefix) > maxTracePrefixLen:
+		return fmt.Errorf("trace prefix %q is longer than %d characters",
+			t.Prefix, maxTracePrefixLen)
+	case strings.ContainsAny(t.Prefix, ": \t\n\r\"\\"):
+		return fmt.Errorf("trace prefix %q cannot contain colons, whitespaces, "+
+			"quotes or backslashes", t.Prefix)
+	}
+
+	return nil
+}
+
+// Hardening configures the mangle table chain with rules dropping packets,
+// which should never reach the proxy (it's jumped to from PREROUTING
+// and OUTPUT chains, so it's applied to incoming and locally generated packets,
+// in iptables as well as ip6tables)
+type Hardening struct {
+	// DropInvalid drops packets in the INVALID conntrack state. ICMPv6
+	// neighbour discovery packets, which are not tracked (and so are seen
+	// as INVALID ones), are not dropped
+	DropInvalid bool
+	// DropInvalidTCPFlags drops TCP packets with illegal combinations
+	// of flags (i.e. NULL or XMAS packets, or with both SYN and FIN set)
+	DropInvalidTCPFlags bool
+	// MeshOnly scopes the rules to TCP traffic in directions with enabled
+	// redirection. Exclusions of the mesh chains in the nat table (ports,
+	// CIDRs, interfaces and the proxy user) are not applied, so such traffic
+	// is still hardened. ICMPv6 neighbour discovery packets are not TCP ones,
+	// so rules allowing them are not generated
+	MeshOnly bool
+	Chain    Chain
+}
+
+// Enabled returns true if any of the hardening rules should be generated
+func (h Hardening) Enabled() bool {
+	return h.DropInvalid || h.DropInvalidTCPFlags
+}
+
 type Config struct {
 	Owner    Owner
 	Redirect Redirect
 	Ebpf     Ebpf
 	// DropInvalidPackets when set will enable configuration which should drop
-	// packets in invalid states
+	// packets in invalid states (it's the same as Hardening.DropInvalid)
 	DropInvalidPackets bool
+	// Hardening configures rules dropping invalid packets
+	Hardening Hardening
+	// Comment configures comments added to all the generated rules
+	Comment Comment
+	// Trace configures logging of packets matched by the generated rules,
+	// which is useful when debugging redirection decisions
+	Trace Trace
 	// IPv6 when set will be used to configure iptables as well as ip6tables
 	IPv6 bool
 	// RuntimeStdout is the place where Any debugging, runtime information
@@ -80,7 +575,7 @@ type Config struct {
 // iptables conditional command generations instead of inlining anonymous functions
 // i.e. AppendIf(ShouldDropInvalidPackets, Match(...), Jump(Drop()))
 func (c Config) ShouldDropInvalidPackets() bool {
-	return c.DropInvalidPackets
+	return c.DropInvalidPackets || c.Hardening.DropInvalid
 }
 
 // ShouldRedirectDNS is just a convenience function which can be used in
@@ -122,6 +617,29 @@ func (c Config) ShouldConntrackZoneSplit() bool {
 	return true
 }
 
+// ShouldComment is a function which will check if tagging generated rules
+// with comments is enabled (return false if not), and then will verify
+// if there is comment iptables extension available
+func (c Config) ShouldComment() bool {
+	if !c.Comment.Enabled {
+		return false
+	}
+
+	// There are kernels built without the comment extension (xt_comment),
+	// instead of failing the whole iptables application, we can log
+	// the warning and apply rules without comments
+	if err := exec.Command("iptables", "-m", "comment", "--help").Run(); err != nil {
+		_, _ = fmt.Fprintf(c.RuntimeStdout,
+			"[WARNING] error occured when validating if 'comment' iptables "+
+				"module is present. Rules won't be tagged with comments: %s\n", err,
+		)
+
+		return false
+	}
+
+	return true
+}
+
 func defaultConfig() Config {
 	return Config{
 		Owner: Owner{UID: "5678"},
@@ -150,6 +668,19 @@ func defaultConfig() Config {
 				CaptureAll:         true,
 				ConntrackZoneSplit: true,
 				ResolvConfigPath:   "/etc/resolv.conf",
+				Servers:            []string{},
+				// https://www.freedesktop.org/software/systemd/man/systemd-resolved.service.html#/etc/resolv.conf
+				SystemdResolvedConfigPath: "/run/systemd/resolve/resolv.conf",
+				UpstreamConntrackZone:     1,
+				ProxyConntrackZone:        2,
+				RedirectChain:             Chain{Name: "MESH_DNS_REDIRECT"},
+				ConntrackOutputChain:      Chain{Name: "MESH_DNS_CT_OUTPUT"},
+				ConntrackPreroutingChain:  Chain{Name: "MESH_DNS_CT_PREROUTING"},
+			},
+			EgressLockdown: EgressLockdown{
+				Enabled: false,
+				Target:  EgressLockdownReject,
+				Chain:   Chain{Name: "MESH_EGRESS"},
 			},
 		},
 		Ebpf: Ebpf{
@@ -163,6 +694,24 @@ func defaultConfig() Config {
 		RuntimeStderr:      os.Stderr,
 		Verbose:            true,
 		DryRun:             false,
+		Comment: Comment{
+			Enabled: false,
+			Prefix:  "kuma",
+		},
+		Trace: Trace{
+			Enabled:    false,
+			Target:     TraceTargetLog,
+			Prefix:     "kuma",
+			NflogGroup: 0,
+			Limit:      "10/second",
+			LimitBurst: 10,
+		},
+		Hardening: Hardening{
+			DropInvalid:         false,
+			DropInvalidTCPFlags: false,
+			MeshOnly:            false,
+			Chain:               Chain{Name: "MESH_HARDENING"},
+		},
 	}
 }
 
@@ -205,12 +754,28 @@ func MergeConfigWithDefaults(cfg Config) Config {
 		result.Redirect.Inbound.IncludePorts = cfg.Redirect.Inbound.IncludePorts
 	}
 
+	if len(cfg.Redirect.Inbound.ExcludePortRanges) > 0 {
+		result.Redirect.Inbound.ExcludePortRanges = cfg.Redirect.Inbound.ExcludePortRanges
+	}
+
+	if len(cfg.Redirect.Inbound.IncludePortRanges) > 0 {
+		result.Redirect.Inbound.IncludePortRanges = cfg.Redirect.Inbound.IncludePortRanges
+	}
+
+	if len(cfg.Redirect.Inbound.ExcludeCIDRs) > 0 {
+		result.Redirect.Inbound.ExcludeCIDRs = cfg.Redirect.Inbound.ExcludeCIDRs
+	}
+
 	// .Redirect.Outbound
 	result.Redirect.Outbound.Enabled = cfg.Redirect.Outbound.Enabled
 	if cfg.Redirect.Outbound.Port != 0 {
 		result.Redirect.Outbound.Port = cfg.Redirect.Outbound.Port
 	}
 
+	if cfg.Redirect.Outbound.PortIPv6 != 0 {
+		result.Redirect.Outbound.PortIPv6 = cfg.Redirect.Outbound.PortIPv6
+	}
+
 	if cfg.Redirect.Outbound.Chain.Name != "" {
 		result.Redirect.Outbound.Chain.Name = cfg.Redirect.Outbound.Chain.Name
 	}
@@ -227,6 +792,18 @@ func MergeConfigWithDefaults(cfg Config) Config {
 		result.Redirect.Outbound.IncludePorts = cfg.Redirect.Outbound.IncludePorts
 	}
 
+	if len(cfg.Redirect.Outbound.ExcludePortRanges) > 0 {
+		result.Redirect.Outbound.ExcludePortRanges = cfg.Redirect.Outbound.ExcludePortRanges
+	}
+
+	if len(cfg.Redirect.Outbound.IncludePortRanges) > 0 {
+		result.Redirect.Outbound.IncludePortRanges = cfg.Redirect.Outbound.IncludePortRanges
+	}
+
+	if len(cfg.Redirect.Outbound.ExcludeCIDRs) > 0 {
+		result.Redirect.Outbound.ExcludeCIDRs = cfg.Redirect.Outbound.ExcludeCIDRs
+	}
+
 	// .Redirect.DNS
 	result.Redirect.DNS.Enabled = cfg.Redirect.DNS.Enabled
 	result.Redirect.DNS.ConntrackZoneSplit = cfg.Redirect.DNS.ConntrackZoneSplit
@@ -239,8 +816,72 @@ func MergeConfigWithDefaults(cfg Config) Config {
 		result.Redirect.DNS.Port = cfg.Redirect.DNS.Port
 	}
 
+	if cfg.Redirect.DNS.PortIPv6 != 0 {
+		result.Redirect.DNS.PortIPv6 = cfg.Redirect.DNS.PortIPv6
+	}
+
+	if len(cfg.Redirect.DNS.Servers) > 0 {
+		result.Redirect.DNS.Servers = cfg.Redirect.DNS.Servers
+	}
+
+	result.Redirect.DNS.WatchResolvConf = cfg.Redirect.DNS.WatchResolvConf
+	result.Redirect.DNS.ExcludeLinkLocalServers = cfg.Redirect.DNS.ExcludeLinkLocalServers
+	result.Redirect.DNS.ExcludeStubResolvers = cfg.Redirect.DNS.ExcludeStubResolvers
+
+	if cfg.Redirect.DNS.SystemdResolvedConfigPath != "" {
+		result.Redirect.DNS.SystemdResolvedConfigPath = cfg.Redirect.DNS.SystemdResolvedConfigPath
+	}
+
+	if cfg.Redirect.DNS.SystemdResolvedUser != "" {
+		result.Redirect.DNS.SystemdResolvedUser = cfg.Redirect.DNS.SystemdResolvedUser
+	}
+
+	if cfg.Redirect.DNS.UpstreamConntrackZone != 0 {
+		result.Redirect.DNS.UpstreamConntrackZone = cfg.Redirect.DNS.UpstreamConntrackZone
+	}
+
+	if cfg.Redirect.DNS.ProxyConntrackZone != 0 {
+		result.Redirect.DNS.ProxyConntrackZone = cfg.Redirect.DNS.ProxyConntrackZone
+	}
+
+	if cfg.Redirect.DNS.RedirectChain.Name != "" {
+		result.Redirect.DNS.RedirectChain.Name = cfg.Redirect.DNS.RedirectChain.Name
+	}
+
+	if cfg.Redirect.DNS.ConntrackOutputChain.Name != "" {
+		result.Redirect.DNS.ConntrackOutputChain.Name = cfg.Redirect.DNS.ConntrackOutputChain.Name
+	}
+
+	if cfg.Redirect.DNS.ConntrackPreroutingChain.Name != "" {
+		result.Redirect.DNS.ConntrackPreroutingChain.Name = cfg.Redirect.DNS.ConntrackPreroutingChain.Name
+	}
+
+	// .Redirect.IPSets
+	result.Redirect.IPSets.Enabled = cfg.Redirect.IPSets.Enabled
+
+	// .Redirect.ExcludeInterfaces
+	if len(cfg.Redirect.ExcludeInterfaces) > 0 {
+		result.Redirect.ExcludeInterfaces = cfg.Redirect.ExcludeInterfaces
+	}
+
+	// .Redirect.ExcludeInboundInterfaces
+	if len(cfg.Redirect.ExcludeInboundInterfaces) > 0 {
+		result.Redirect.ExcludeInboundInterfaces = cfg.Redirect.ExcludeInboundInterfaces
+	}
+
+	// .Redirect.EgressLockdown
+	result.Redirect.EgressLockdown.Enabled = cfg.Redirect.EgressLockdown.Enabled
+	if cfg.Redirect.EgressLockdown.Target != "" {
+		result.Redirect.EgressLockdown.Target = cfg.Redirect.EgressLockdown.Target
+	}
+
+	if cfg.Redirect.EgressLockdown.Chain.Name != "" {
+		result.Redirect.EgressLockdown.Chain.Name = cfg.Redirect.EgressLockdown.Chain.Name
+	}
+
 	// .Ebpf
 	result.Ebpf.Enabled = cfg.Ebpf.Enabled
+	result.Ebpf.Policy = cfg.Ebpf.GetPolicy()
 	if cfg.Ebpf.InstanceIP != "" {
 		result.Ebpf.InstanceIP = cfg.Ebpf.InstanceIP
 	}
@@ -256,6 +897,42 @@ func MergeConfigWithDefaults(cfg Config) Config {
 	// .DropInvalidPackets
 	result.DropInvalidPackets = cfg.DropInvalidPackets
 
+	// .Hardening
+	result.Hardening.DropInvalid = cfg.Hardening.DropInvalid
+	result.Hardening.DropInvalidTCPFlags = cfg.Hardening.DropInvalidTCPFlags
+	result.Hardening.MeshOnly = cfg.Hardening.MeshOnly
+	if cfg.Hardening.Chain.Name != "" {
+		result.Hardening.Chain.Name = cfg.Hardening.Chain.Name
+	}
+
+	// .Comment
+	result.Comment.Enabled = cfg.Comment.Enabled
+	if cfg.Comment.Prefix != "" {
+		result.Comment.Prefix = cfg.Comment.Prefix
+	}
+
+	// .Trace
+	result.Trace.Enabled = cfg.Trace.Enabled
+	if cfg.Trace.Target != "" {
+		result.Trace.Target = cfg.Trace.Target
+	}
+
+	if cfg.Trace.Prefix != "" {
+		result.Trace.Prefix = cfg.Trace.Prefix
+	}
+
+	if cfg.Trace.NflogGroup != 0 {
+		result.Trace.NflogGroup = cfg.Trace.NflogGroup
+	}
+
+	if cfg.Trace.Limit != "" {
+		result.Trace.Limit = cfg.Trace.Limit
+	}
+
+	if cfg.Trace.LimitBurst != 0 {
+		result.Trace.LimitBurst = cfg.Trace.LimitBurst
+	}
+
 	// .IPv6
 	result.IPv6 = cfg.IPv6
 
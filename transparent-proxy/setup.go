@@ -1,15 +1,166 @@
 package transparent_proxy
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/kumahq/kuma-net/ebpf"
 	"github.com/kumahq/kuma-net/iptables"
 	"github.com/kumahq/kuma-net/transparent-proxy/config"
 )
 
+type Backend string
+
+const (
+	BackendEbpf     Backend = "ebpf"
+	BackendIptables Backend = "iptables"
+)
+
+// BackendDecision describes which backend was selected to install
+// the transparent proxy and why
+type BackendDecision struct {
+	Backend Backend
+	Reasons []string
+}
+
+func (d *BackendDecision) String() string {
+	if len(d.Reasons) == 0 {
+		return fmt.Sprintf("selected transparent proxy backend: %s", d.Backend)
+	}
+
+	return fmt.Sprintf("selected transparent proxy backend: %s (%s)",
+		d.Backend, strings.Join(d.Reasons, "; "))
+}
+
+// SelectBackend will choose the backend basing on the configured eBPF policy
+// and (if necessary) the result of probing the kernel for eBPF support
+func SelectBackend(cfg config.Config) (*BackendDecision, error) {
+	return selectBackend(cfg, ebpf.Probe)
+}
+
+func selectBackend(
+	cfg config.Config,
+	probe func(config.Config) *ebpf.ProbeResult,
+) (*BackendDecision, error) {
+	// the probe needs defaults (i.e. the path of the BPF file system)
+	cfg = config.MergeConfigWithDefaults(cfg)
+	policy := cfg.Ebpf.GetPolicy()
+
+	switch policy {
+	case config.EbpfPolicyDisabled:
+		return &BackendDecision{
+			Backend: BackendIptables,
+			Reasons: []string{"eBPF is disabled"},
+		}, nil
+	case config.EbpfPolicyRequired, config.EbpfPolicyPreferred:
+	default:
+		return nil, fmt.Errorf("unknown eBPF policy: %q", policy)
+	}
+
+	var reasons []string
+
+	// eBPF programs are capturing all the DNS traffic, as they have
+	// no information about selected DNS servers, which is fine unless
+	// capturing only the selected ones was explicitly requested
+	if cfg.ShouldRedirectDNS() && !cfg.ShouldCaptureAllDNS() &&
+		cfg.Redirect.DNS.SelectiveCaptureRequested() {
+		reasons = append(reasons, "capturing DNS traffic only to selected "+
+			"DNS servers is not supported by eBPF programs")
+	}
+
+	if len(cfg.Redirect.Inbound.ExcludeCIDRs) > 0 || len(cfg.Redirect.Outbound.ExcludeCIDRs) > 0 {
+		reasons = append(reasons, "excluding CIDRs from the redirection "+
+			"is not supported by eBPF programs")
+	}
+
+	// eBPF programs are redirecting connections before the route (and so
+	// the interface) is known
+	if len(cfg.Redirect.ExcludeInterfaces) > 0 || len(cfg.Redirect.ExcludeInboundInterfaces) > 0 {
+		reasons = append(reasons, "excluding interfaces from the redirection "+
+			"is not supported by eBPF programs")
+	}
+
+	if cfg.Redirect.EgressLockdown.Enabled {
+		reasons = append(reasons, "egress lockdown is not supported "+
+			"by eBPF programs")
+	}
+
+	reasons = append(reasons, probe(cfg).Reasons()...)
+
+	if len(reasons) == 0 {
+		return &BackendDecision{
+			Backend: BackendEbpf,
+			Reasons: []string{"eBPF is supported"},
+		}, nil
+	}
+
+	if policy == config.EbpfPolicyRequired {
+		return nil, fmt.Errorf("eBPF is required, but it's not supported:\n\t%s",
+			strings.Join(reasons, "\n\t"))
+	}
+
+	return &BackendDecision{
+		Backend: BackendIptables,
+		Reasons: append(
+			[]string{"eBPF is preferred, but it's not supported, falling back to iptables"},
+			reasons...,
+		),
+	}, nil
+}
+
 func Setup(cfg config.Config) (string, error) {
-	if cfg.Ebpf.Enabled {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	if cfg.Ebpf.GetPolicy() == config.EbpfPolicyDisabled {
+		return iptables.Setup(cfg)
+	}
+
+	decision, err := SelectBackend(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	if cfg.RuntimeStdout != nil {
+		_, _ = fmt.Fprintln(cfg.RuntimeStdout, decision.String())
+	}
+
+	if decision.Backend == BackendEbpf {
 		return ebpf.Setup(cfg)
 	}
 
 	return iptables.Setup(cfg)
 }
+
+// Uninstall removes iptables rules, chains and ipsets created by Setup.
+// Removing eBPF programs and maps is not supported, so it fails when they
+// are found pinned in the BPF file system
+func Uninstall(cfg config.Config) (string, error) {
+	return uninstall(cfg, ebpf.Installed)
+}
+
+// Cleanup removes iptables rules, chains and ipsets created by Setup
+//
+// Deprecated: use Uninstall instead
+func Cleanup(cfg config.Config) (string, error) {
+	return Uninstall(cfg)
+}
+
+func uninstall(
+	cfg config.Config,
+	ebpfInstalled func(config.Config) (bool, error),
+) (string, error) {
+	cfg = config.MergeConfigWithDefaults(cfg)
+
+	installed, err := ebpfInstalled(cfg)
+	if err != nil {
+		return "", fmt.Errorf("cannot check if eBPF programs are installed: %s", err)
+	}
+
+	if installed {
+		return "", fmt.Errorf("eBPF programs are pinned in %s, but uninstalling "+
+			"the eBPF backend is not supported, they have to be unpinned and "+
+			"detached manually", cfg.Ebpf.BPFFSPath)
+	}
+
+	return iptables.Uninstall(cfg)
+}
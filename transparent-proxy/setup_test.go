@@ -0,0 +1,267 @@
+package transparent_proxy
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kumahq/kuma-net/ebpf"
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+func probeSupported(config.Config) *ebpf.ProbeResult {
+	return &ebpf.ProbeResult{Checks: []ebpf.ProbeCheck{
+		{Name: "root user", Supported: true},
+	}}
+}
+
+func probeUnsupported(config.Config) *ebpf.ProbeResult {
+	return &ebpf.ProbeResult{Checks: []ebpf.ProbeCheck{
+		{Name: "root user", Supported: true},
+		{Name: "map type SockHash", Reason: "not supported by the kernel"},
+	}}
+}
+
+// backendReason is the reason of rejecting eBPF backend, together with
+// the configuration (or the probe result) resulting in it
+type backendReason struct {
+	name   string
+	reason string
+	apply  func(cfg *config.Config)
+	probe  func(config.Config) *ebpf.ProbeResult
+}
+
+var _ = Describe("selectBackend", func() {
+	reasons := []backendReason{
+		{
+			name:   "capturing DNS traffic to selected servers",
+			reason: "capturing DNS traffic only to selected DNS servers is not supported by eBPF programs",
+			apply: func(cfg *config.Config) {
+				cfg.Redirect.DNS = config.DNS{Enabled: true, ExcludeStubResolvers: true}
+			},
+		},
+		{
+			name:   "excluded CIDRs",
+			reason: "excluding CIDRs from the redirection is not supported by eBPF programs",
+			apply: func(cfg *config.Config) {
+				cfg.Redirect.Inbound.ExcludeCIDRs = []string{"10.0.0.0/8"}
+			},
+		},
+		{
+			name:   "excluded interfaces",
+			reason: "excluding interfaces from the redirection is not supported by eBPF programs",
+			apply: func(cfg *config.Config) {
+				cfg.Redirect.ExcludeInboundInterfaces = []string{"eth1"}
+			},
+		},
+		{
+			name:   "egress lockdown",
+			reason: "egress lockdown is not supported by eBPF programs",
+			apply: func(cfg *config.Config) {
+				cfg.Redirect.EgressLockdown.Enabled = true
+			},
+		},
+		{
+			name:   "not supported by the kernel",
+			reason: "map type SockHash: not supported by the kernel",
+			probe:  probeUnsupported,
+		},
+	}
+
+	DescribeTable("should select backend by the policy",
+		func(policy config.EbpfPolicy, reason backendReason, backend Backend, reasons []string) {
+			// given
+			cfg := config.Config{Ebpf: config.Ebpf{Policy: policy}}
+			if reason.apply != nil {
+				reason.apply(&cfg)
+			}
+
+			probe := reason.probe
+			if probe == nil {
+				probe = probeSupported
+			}
+
+			// when
+			decision, err := selectBackend(cfg, probe)
+
+			// then
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decision.Backend).To(Equal(backend))
+			Expect(decision.Reasons).To(Equal(reasons))
+		},
+		func() []TableEntry {
+			entries := []TableEntry{
+				Entry("disabled, without reasons", config.EbpfPolicyDisabled, backendReason{},
+					BackendIptables, []string{"eBPF is disabled"}),
+				Entry("preferred, without reasons", config.EbpfPolicyPreferred, backendReason{},
+					BackendEbpf, []string{"eBPF is supported"}),
+				Entry("required, without reasons", config.EbpfPolicyRequired, backendReason{},
+					BackendEbpf, []string{"eBPF is supported"}),
+			}
+
+			for _, reason := range reasons {
+				entries = append(entries,
+					Entry("disabled, "+reason.name, config.EbpfPolicyDisabled, reason,
+						BackendIptables, []string{"eBPF is disabled"}),
+					Entry("preferred, "+reason.name, config.EbpfPolicyPreferred, reason,
+						BackendIptables, []string{
+							"eBPF is preferred, but it's not supported, falling back to iptables",
+							reason.reason,
+						}),
+				)
+			}
+
+			return entries
+		}(),
+	)
+
+	DescribeTable("should fail when eBPF is required, but it's not supported",
+		func(reason backendReason) {
+			// given
+			cfg := config.Config{Ebpf: config.Ebpf{Policy: config.EbpfPolicyRequired}}
+			if reason.apply != nil {
+				reason.apply(&cfg)
+			}
+
+			probe := reason.probe
+			if probe == nil {
+				probe = probeSupported
+			}
+
+			// when
+			_, err := selectBackend(cfg, probe)
+
+			// then
+			Expect(err).To(MatchError("eBPF is required, but it's not supported:\n\t" + reason.reason))
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+
+			for _, reason := range reasons {
+				entries = append(entries, Entry(reason.name, reason))
+			}
+
+			return entries
+		}(),
+	)
+
+	It("should fail for unknown policy", func() {
+		// when
+		_, err := selectBackend(config.Config{Ebpf: config.Ebpf{Policy: "sometimes"}}, probeSupported)
+
+		// then
+		Expect(err).To(MatchError(`unknown eBPF policy: "sometimes"`))
+	})
+
+	It("should probe the kernel with the default configuration", func() {
+		// given
+		var bpffsPath string
+		probe := func(cfg config.Config) *ebpf.ProbeResult {
+			bpffsPath = cfg.Ebpf.BPFFSPath
+			return probeSupported(cfg)
+		}
+
+		// when
+		decision, err := selectBackend(config.Config{Ebpf: config.Ebpf{Policy: config.EbpfPolicyPreferred}}, probe)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decision.Backend).To(Equal(BackendEbpf))
+		Expect(bpffsPath).To(Equal("/run/kuma/bpf"))
+	})
+
+	It("should not probe the kernel when eBPF is disabled", func() {
+		// given
+		probe := func(config.Config) *ebpf.ProbeResult {
+			Fail("probe should not be called")
+			return nil
+		}
+
+		// when
+		decision, err := selectBackend(config.Config{}, probe)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decision.Backend).To(Equal(BackendIptables))
+	})
+
+	It("should select eBPF capturing all the DNS traffic when DNS servers are not selected", func() {
+		// given
+		cfg := config.Config{
+			Redirect: config.Redirect{
+				DNS: config.DNS{Enabled: true},
+			},
+			Ebpf: config.Ebpf{Enabled: true},
+		}
+
+		// when
+		decision, err := selectBackend(cfg, probeSupported)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decision.Backend).To(Equal(BackendEbpf))
+	})
+
+	It("should reject eBPF when capturing DNS traffic only to selected servers", func() {
+		// given
+		cfg := config.Config{
+			Redirect: config.Redirect{
+				DNS: config.DNS{Enabled: true, Servers: []string{"10.0.0.10"}},
+			},
+			Ebpf: config.Ebpf{Enabled: true},
+		}
+
+		// when
+		_, err := selectBackend(cfg, probeSupported)
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring("capturing DNS traffic only to selected")))
+	})
+})
+
+var _ = Describe("uninstall", func() {
+	It("should fail when eBPF programs are installed", func() {
+		// given
+		ebpfInstalled := func(config.Config) (bool, error) {
+			return true, nil
+		}
+
+		// when
+		_, err := uninstall(config.Config{}, ebpfInstalled)
+
+		// then
+		Expect(err).To(MatchError(ContainSubstring(
+			"eBPF programs are pinned in /run/kuma/bpf, but uninstalling the eBPF backend is not supported",
+		)))
+	})
+})
+
+var _ = Describe("Setup", func() {
+	It("should print ipsets referenced by rules in the dry run mode", func() {
+		// given
+		stdout := &bytes.Buffer{}
+		cfg := config.Config{
+			Redirect: config.Redirect{
+				Inbound: config.TrafficFlow{Enabled: true},
+				Outbound: config.TrafficFlow{
+					Enabled:      true,
+					ExcludeCIDRs: []string{"203.0.113.0/24"},
+				},
+				IPSets: config.IPSets{Enabled: true},
+			},
+			DryRun:        true,
+			RuntimeStdout: stdout,
+		}
+
+		// when
+		output, err := Setup(cfg)
+
+		// then
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stdout.String()).To(HavePrefix("# ipset restore\ncreate "))
+		Expect(stdout.String()).To(HaveSuffix("# iptables-restore\n" + output))
+		Expect(output).ToNot(ContainSubstring("create "))
+		Expect(output).To(ContainSubstring("--match-set"))
+	})
+})